@@ -0,0 +1,90 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Agent manages the keys of the running ssh agent, (Unix systems only)
+type Agent struct {
+	agent.ExtendedAgent
+	conn net.Conn
+}
+
+// NewAgent connects to the ssh agent of SSH_AUTH_SOCK.
+func NewAgent() (*Agent, error) {
+
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, fmt.Errorf("could not find ssh agent: %w", err)
+	}
+
+	return &Agent{
+		ExtendedAgent: agent.NewClient(conn),
+		conn:          conn,
+	}, nil
+}
+
+// AddKey adds a raw private key (*rsa.PrivateKey, ed25519.PrivateKey...) to the agent,
+// a zero lifetime keeps the key until it's removed.
+func (a *Agent) AddKey(key interface{}, comment string, lifetime time.Duration) error {
+	return a.ExtendedAgent.Add(agent.AddedKey{
+		PrivateKey:   key,
+		Comment:      comment,
+		LifetimeSecs: uint32(lifetime / time.Second),
+	})
+}
+
+// AddKeyFile adds a private key file with or without passphrase to the agent.
+func (a *Agent) AddKeyFile(prvFile string, passphrase string, lifetime time.Duration) error {
+
+	var key interface{}
+
+	pem, err := ioutil.ReadFile(prvFile)
+	if err != nil {
+		return err
+	}
+
+	if passphrase != "" {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(pem, []byte(passphrase))
+	} else {
+		key, err = ssh.ParseRawPrivateKey(pem)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return a.AddKey(key, prvFile, lifetime)
+}
+
+// RemoveKey removes the key of pub from the agent.
+func (a *Agent) RemoveKey(pub ssh.PublicKey) error {
+	return a.ExtendedAgent.Remove(pub)
+}
+
+// Identities returns the keys held by the agent.
+func (a *Agent) Identities() ([]*agent.Key, error) {
+	return a.ExtendedAgent.List()
+}
+
+// Auth returns auth method using the agent keys.
+func (a *Agent) Auth() Auth {
+	return Auth{
+		ssh.PublicKeysCallback(a.ExtendedAgent.Signers),
+	}
+}
+
+// Close the agent connection.
+func (a *Agent) Close() error {
+	return a.conn.Close()
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// msgKexInit is the ssh key exchange init message number.
+const msgKexInit = 20
+
+// WeakAlgorithms are the negotiated algorithms reported as weak.
+var WeakAlgorithms = map[string]bool{
+	"diffie-hellman-group1-sha1":         true,
+	"diffie-hellman-group14-sha1":        true,
+	"diffie-hellman-group-exchange-sha1": true,
+	"hmac-sha1":                          true,
+	"hmac-sha1-96":                       true,
+	"aes128-cbc":                         true,
+	"3des-cbc":                           true,
+	"arcfour":                            true,
+	"arcfour128":                         true,
+	"arcfour256":                         true,
+	ssh.KeyAlgoRSA:                       true,
+	ssh.KeyAlgoDSA:                       true,
+	ssh.CertAlgoRSAv01:                   true,
+	ssh.CertAlgoDSAv01:                   true,
+}
+
+// defaultHostKeyAlgorithms mirrors the x/crypto client preference when
+// ClientConfig.HostKeyAlgorithms is empty.
+var defaultHostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	ssh.KeyAlgoED25519,
+}
+
+// Algorithms are the algorithms negotiated with the server, MACs are empty
+// when an AEAD cipher is used.
+type Algorithms struct {
+	KeyExchange        string
+	HostKey            string
+	CipherClientServer string
+	CipherServerClient string
+	MACClientServer    string
+	MACServerClient    string
+}
+
+// List returns the non empty algorithms.
+func (a Algorithms) List() []string {
+
+	var list []string
+	for _, algo := range []string{a.KeyExchange, a.HostKey, a.CipherClientServer, a.CipherServerClient, a.MACClientServer, a.MACServerClient} {
+		if algo != "" {
+			list = append(list, algo)
+		}
+	}
+
+	return list
+}
+
+// Weak returns the negotiated algorithms listed in WeakAlgorithms.
+func (a Algorithms) Weak() []string {
+
+	var (
+		weak []string
+		seen = map[string]bool{}
+	)
+
+	for _, algo := range a.List() {
+		if WeakAlgorithms[algo] && !seen[algo] {
+			seen[algo] = true
+			weak = append(weak, algo)
+		}
+	}
+
+	return weak
+}
+
+// WeakAlgorithmError is returned in strict mode when weak algorithms were negotiated.
+type WeakAlgorithmError struct {
+	Algorithms []string
+}
+
+func (e *WeakAlgorithmError) Error() string {
+	return fmt.Sprintf("weak algorithms negotiated: %s", strings.Join(e.Algorithms, ", "))
+}
+
+// checkAlgorithms reports weak algorithms to the config hook, in strict mode
+// it returns a *WeakAlgorithmError.
+func (c *Config) checkAlgorithms(algos Algorithms) error {
+
+	weak := algos.Weak()
+	if len(weak) == 0 {
+		return nil
+	}
+
+	if c.OnWeakAlgorithm != nil {
+		c.OnWeakAlgorithm(weak, algos)
+	}
+
+	if c.StrictAlgorithms {
+		return &WeakAlgorithmError{Algorithms: weak}
+	}
+
+	return nil
+}
+
+// negotiate finds the algorithms the client and server agreed on, from the
+// recorded server key exchange init and the client config.
+func negotiate(recorded []byte, config *ssh.ClientConfig, hostKeyType string) (Algorithms, error) {
+
+	var algos Algorithms
+
+	_, rest := serverVersion(recorded)
+	server, err := parseKexInit(rest)
+	if err != nil {
+		return algos, err
+	}
+
+	cfg := config.Config
+	cfg.SetDefaults()
+
+	hostKeyAlgos := config.HostKeyAlgorithms
+	if len(hostKeyAlgos) == 0 {
+		hostKeyAlgos = defaultHostKeyAlgorithms
+	}
+
+	// Only algorithms usable with the received host key may have been chosen.
+	var usable []string
+	for _, algo := range hostKeyAlgos {
+		if hostKeyType == "" || keyFormat(algo) == hostKeyType {
+			usable = append(usable, algo)
+		}
+	}
+
+	algos.KeyExchange = firstCommon(cfg.KeyExchanges, server[0])
+	algos.HostKey = firstCommon(usable, server[1])
+	algos.CipherClientServer = firstCommon(cfg.Ciphers, server[2])
+	algos.CipherServerClient = firstCommon(cfg.Ciphers, server[3])
+
+	if !isAEAD(algos.CipherClientServer) {
+		algos.MACClientServer = firstCommon(cfg.MACs, server[4])
+	}
+	if !isAEAD(algos.CipherServerClient) {
+		algos.MACServerClient = firstCommon(cfg.MACs, server[5])
+	}
+
+	return algos, nil
+}
+
+// parseKexInit returns the name lists of the first server binary packet.
+func parseKexInit(b []byte) ([10][]string, error) {
+
+	var lists [10][]string
+
+	if len(b) < 6 {
+		return lists, errors.New("short kex init packet")
+	}
+
+	length := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < length || length < 2 {
+		return lists, errors.New("short kex init packet")
+	}
+
+	padding := uint32(b[4])
+	if padding+1 > length {
+		return lists, errors.New("invalid kex init padding")
+	}
+
+	payload := b[5 : 4+length-padding]
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return lists, errors.New("first server packet is not kex init")
+	}
+
+	payload = payload[17:]
+	for i := range lists {
+
+		if len(payload) < 4 {
+			return lists, errors.New("short kex init packet")
+		}
+
+		n := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < n {
+			return lists, errors.New("short kex init packet")
+		}
+
+		if n > 0 {
+			lists[i] = strings.Split(string(payload[4:4+n]), ",")
+		}
+		payload = payload[4+n:]
+	}
+
+	return lists, nil
+}
+
+func firstCommon(client, server []string) string {
+
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+
+	return ""
+}
+
+func isAEAD(cipher string) bool {
+	return strings.Contains(cipher, "gcm") || strings.HasPrefix(cipher, "chacha20-poly1305")
+}
+
+// keyFormat returns the public key format of a host key signature algorithm.
+func keyFormat(algo string) string {
+
+	switch algo {
+	case ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512:
+		return ssh.KeyAlgoRSA
+	case ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01:
+		return ssh.CertAlgoRSAv01
+	}
+
+	return algo
+}
@@ -42,6 +42,14 @@ func KeyboardInteractive(pass string) Auth {
 	}
 }
 
+// KeyboardInteractiveChallenge returns keyboard interactive auth method answering
+// the server questions with fn, for one time passwords and other 2FA prompts.
+func KeyboardInteractiveChallenge(fn func(name, instruction string, questions []string, echos []bool) ([]string, error)) Auth {
+	return Auth{
+		ssh.KeyboardInteractive(fn),
+	}
+}
+
 // Key returns auth method from private key with or without passphrase.
 func Key(prvFile string, passphrase string) (Auth, error) {
 
@@ -56,6 +64,40 @@ func Key(prvFile string, passphrase string) (Auth, error) {
 	}, nil
 }
 
+// Certificate returns auth method from an OpenSSH certificate file, like
+// id_ed25519-cert.pub, and its private key with or without passphrase.
+func Certificate(certFile string, prvFile string, passphrase string) (Auth, error) {
+
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s: %w", certFile, err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", certFile)
+	}
+
+	signer, err := GetSigner(prvFile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", certFile, err)
+	}
+
+	return Auth{
+		ssh.PublicKeys(certSigner),
+	}, nil
+}
+
 func RawKey(privateKey string, passphrase string) (Auth, error) {
 	signer, err := GetSignerForRawKey([]byte(privateKey), passphrase)
 	if err != nil {
@@ -0,0 +1,125 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKey is a parsed authorized_keys line.
+type AuthorizedKey struct {
+	Key     ssh.PublicKey
+	Comment string
+	Options []string
+}
+
+// AuthorizedKeys edits the authorized_keys file of a remote user, see
+// SetAuthorizedKeys for the file modes and ownership.
+type AuthorizedKeys struct {
+	client Client
+	user   string
+}
+
+// AuthorizedKeys returns the authorized keys of user, empty means the
+// connected user.
+func (c Client) AuthorizedKeys(user string) *AuthorizedKeys {
+
+	if user == "" {
+		user = c.User()
+	}
+
+	return &AuthorizedKeys{client: c, user: user}
+}
+
+// List returns the keys, none when the file doesn't exist. Comments and
+// invalid lines are skipped.
+func (a *AuthorizedKeys) List(ctx context.Context) ([]AuthorizedKey, error) {
+
+	_, data, err := a.client.readAuthorizedKeys(ctx, a.user)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []AuthorizedKey
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, ok := parseAuthorizedLine(line); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Add appends the authorized_keys line, it returns false when the key is
+// already authorized whatever its options and comment.
+func (a *AuthorizedKeys) Add(ctx context.Context, line string) (bool, error) {
+
+	line = strings.TrimSpace(line)
+
+	key, ok := parseAuthorizedLine(line)
+	if !ok || strings.ContainsAny(line, "\r\n") {
+		return false, fmt.Errorf("invalid authorized key %q", line)
+	}
+
+	_, data, err := a.client.readAuthorizedKeys(ctx, a.user)
+	if err != nil {
+		return false, err
+	}
+
+	for _, cur := range strings.Split(string(data), "\n") {
+		if k, ok := parseAuthorizedLine(cur); ok && bytes.Equal(k.Key.Marshal(), key.Key.Marshal()) {
+			return false, nil
+		}
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	return a.client.writeAuthorizedKeys(ctx, a.user, append(data, line+"\n"...))
+}
+
+// Remove deletes the lines of key, it returns false when none was found.
+// The other lines, comments included, are kept as is.
+func (a *AuthorizedKeys) Remove(ctx context.Context, key ssh.PublicKey) (bool, error) {
+
+	_, data, err := a.client.readAuthorizedKeys(ctx, a.user)
+	if err != nil {
+		return false, err
+	}
+
+	var kept bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if k, ok := parseAuthorizedLine(line); ok && bytes.Equal(k.Key.Marshal(), key.Marshal()) {
+			continue
+		}
+		kept.WriteString(line)
+	}
+
+	if kept.Len() == len(data) {
+		return false, nil
+	}
+
+	return a.client.writeAuthorizedKeys(ctx, a.user, kept.Bytes())
+}
+
+func parseAuthorizedLine(line string) (AuthorizedKey, bool) {
+
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return AuthorizedKey{}, false
+	}
+
+	key, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil || len(rest) > 0 {
+		return AuthorizedKey{}, false
+	}
+
+	return AuthorizedKey{Key: key, Comment: comment, Options: options}, true
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// RunBinary uploads the local binary to a remote temp dir, runs it with args
+// and removes it, it returns the combined output.
+func (c Client) RunBinary(localPath string, args ...string) ([]byte, error) {
+
+	var b syncBuffer
+
+	err := c.RunBinaryWriter(localPath, &b, &b, args...)
+
+	return b.Bytes(), err
+}
+
+// RunBinaryWriter is like RunBinary but streams the binary stdout and stderr.
+func (c Client) RunBinaryWriter(localPath string, stdout io.Writer, stderr io.Writer, args ...string) (err error) {
+
+	remotePath, cleanup, err := c.pushExecutable(localPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	shell := c.Config.shell()
+
+	cmd, err := c.Command(shell.Wrap(shell.command(remotePath, args)))
+	if err != nil {
+		return err
+	}
+	defer cmd.Session.Close()
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	return cmd.Run()
+}
+
+// pushExecutable uploads localPath executable to a new remote temp dir,
+// cleanup removes it.
+func (c Client) pushExecutable(localPath string) (string, func(), error) {
+
+	dir, err := c.tempDir()
+	if err != nil {
+		return "", nil, err
+	}
+
+	remotePath := path.Join(dir, filepath.Base(localPath))
+
+	cleanup := func() {
+		if ftp, err := c.NewSftp(); err == nil {
+			ftp.Remove(remotePath)
+			ftp.RemoveDirectory(dir)
+			ftp.Close()
+		}
+	}
+
+	if err = c.Upload(localPath, remotePath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	defer ftp.Close()
+
+	if err = ftp.Chmod(remotePath, 0700); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return remotePath, cleanup, nil
+}
+
+// tempDir creates a remote temp dir and returns its sftp path.
+func (c Client) tempDir() (string, error) {
+
+	shell := c.Config.shell()
+
+	var cmd string
+	switch shell {
+	case ShellPowerShell:
+		cmd = "$d = Join-Path ([IO.Path]::GetTempPath()) ([Guid]::NewGuid()); New-Item -ItemType Directory $d | Out-Null; $d"
+	case ShellCmd:
+		// %d% would expand before set runs, !d! is expanded when used.
+		cmd = `set "d=%TEMP%\goph-%RANDOM%%RANDOM%"&& mkdir "!d!" && echo !d!`
+	default:
+		cmd = "mktemp -d"
+	}
+
+	if shell == ShellCmd {
+		cmd = `cmd /V:ON /S /C "` + cmd + `"`
+	} else {
+		cmd = shell.Wrap(cmd)
+	}
+
+	out, err := c.Run(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	dir := RemotePath(string(bytes.TrimSpace(out)))
+	if !strings.HasPrefix(dir, "/") {
+		return "", fmt.Errorf("unexpected temp dir %q", dir)
+	}
+
+	return dir, nil
+}
+
+// RemotePlatform returns the remote "GOOS/GOARCH" detected with uname, or
+// from PROCESSOR_ARCHITECTURE with a Windows RemoteShell.
+func (c Client) RemotePlatform() (string, error) {
+
+	switch shell := c.Config.shell(); shell {
+	case ShellPowerShell, ShellCmd:
+		cmd := "echo %PROCESSOR_ARCHITECTURE%"
+		if shell == ShellPowerShell {
+			cmd = "$env:PROCESSOR_ARCHITECTURE"
+		}
+
+		out, err := c.Run(shell.Wrap(cmd))
+		if err != nil {
+			return "", err
+		}
+
+		arch := strings.ToLower(strings.TrimSpace(string(out)))
+		if arch == "x86" {
+			arch = "386"
+		}
+
+		return "windows/" + arch, nil
+	}
+
+	out, err := c.Run(c.Config.shell().Wrap("uname -sm"))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("unexpected uname output %q", out)
+	}
+
+	goos := strings.ToLower(fields[0])
+
+	goarch, ok := unameArch[fields[1]]
+	if !ok {
+		goarch = fields[1]
+	}
+
+	return goos + "/" + goarch, nil
+}
+
+var unameArch = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv6l":  "arm",
+	"armv7l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// RunSelf uploads the binary matching the remote platform and runs it with args,
+// binaries maps "GOOS/GOARCH" to local paths. The running executable is used
+// when the remote platform matches the local one and isn't in binaries.
+func (c Client) RunSelf(binaries map[string]string, args ...string) ([]byte, error) {
+
+	platform, err := c.RemotePlatform()
+	if err != nil {
+		return nil, err
+	}
+
+	localPath, ok := binaries[platform]
+	if !ok {
+		if platform != runtime.GOOS+"/"+runtime.GOARCH {
+			return nil, fmt.Errorf("no binary for remote platform %s", platform)
+		}
+
+		if localPath, err = os.Executable(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.RunBinary(localPath, args...)
+}
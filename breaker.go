@@ -0,0 +1,236 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when a host circuit is open and connections are not attempted.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerState is the state of a host circuit.
+type BreakerState int
+
+const (
+	// BreakerClosed lets connections through.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen rejects connections until the cool-down period ends.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single connection through to probe the host.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a per host circuit breaker, it stops connecting to a host after
+// Threshold consecutive failures until Cooldown elapsed.
+type Breaker struct {
+
+	// Consecutive failures that open the circuit, 0 means 5.
+	Threshold int
+
+	// Time the circuit stays open before probing the host again, 0 means 30 seconds.
+	Cooldown time.Duration
+
+	// OnStateChange is called on every host state transition, it runs while
+	// the breaker is locked and must not call the breaker back.
+	OnStateChange func(host string, from, to BreakerState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker returns new breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// NewClient connects with the config skipping the addresses whose circuit is
+// open, circuits are keyed by host:port. Only network errors are recorded as
+// failures, a host rejecting the client is still reachable.
+func (b *Breaker) NewClient(c *Config) (*Client, error) {
+	return b.NewClientContext(context.Background(), c)
+}
+
+// NewClientContext is like NewClient, canceling ctx aborts the dial and
+// returns the ctx error without recording a failure. Invalid configs are
+// rejected before any address is attempted.
+func (b *Breaker) NewClientContext(ctx context.Context, c *Config) (*Client, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	addrs := c.addresses()
+	failover := &FailoverError{}
+
+	for _, addr := range addrs {
+
+		if err := b.Allow(addr); err != nil {
+			failover.Errors = append(failover.Errors, &AddrError{Addr: addr, Err: err})
+			continue
+		}
+
+		client, err := c.dialAddr(ctx, addr)
+
+		if err != nil && ctx.Err() != nil {
+			b.release(addr)
+			return nil, ctx.Err()
+		}
+
+		if err == nil || !isNetworkError(err) {
+			b.Success(addr)
+			return client, c.redactor().RedactError(err)
+		}
+
+		b.Failure(addr)
+		failover.Errors = append(failover.Errors, &AddrError{Addr: addr, Err: err})
+	}
+
+	return nil, c.redactor().RedactError(failover.err())
+}
+
+// State returns the current state of host circuit.
+func (b *Breaker) State(host string) BreakerState {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.get(host).state
+}
+
+// Allow returns ErrCircuitOpen if host should not be attempted now.
+func (b *Breaker) Allow(host string) error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.get(host)
+
+	switch c.state {
+	case BreakerOpen:
+		if time.Since(c.openedAt) < b.cooldown() {
+			return ErrCircuitOpen
+		}
+		b.transition(host, c, BreakerHalfOpen)
+		c.probing = true
+	case BreakerHalfOpen:
+		if c.probing {
+			return ErrCircuitOpen
+		}
+		c.probing = true
+	}
+
+	return nil
+}
+
+// Success records a successful connection to host and closes its circuit.
+func (b *Breaker) Success(host string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.get(host)
+	c.failures = 0
+	c.probing = false
+	b.transition(host, c, BreakerClosed)
+}
+
+// Failure records a failed connection to host.
+func (b *Breaker) Failure(host string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.get(host)
+	c.failures++
+	c.probing = false
+
+	if c.state == BreakerHalfOpen || c.failures >= b.threshold() {
+		c.openedAt = time.Now()
+		b.transition(host, c, BreakerOpen)
+	}
+}
+
+// release ends a half-open probe of host without changing its state.
+func (b *Breaker) release(host string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.get(host).probing = false
+}
+
+// Reset forgets host state.
+func (b *Breaker) Reset(host string) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.hosts, host)
+}
+
+func (b *Breaker) get(host string) *circuit {
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+
+	return c
+}
+
+func (b *Breaker) transition(host string, c *circuit, to BreakerState) {
+
+	from := c.state
+	c.state = to
+
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
+
+func (b *Breaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return 5
+}
+
+func (b *Breaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return 30 * time.Second
+}
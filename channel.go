@@ -0,0 +1,73 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type openChannelResult struct {
+	channel ssh.Channel
+	reqs    <-chan *ssh.Request
+	err     error
+}
+
+// OpenChannelContext opens a channel of a custom type, like the embedded
+// OpenChannel but the open is cancelled when ctx is done, and the channel
+// is closed once ctx is done, like exec.CommandContext kills the process.
+func (c Client) OpenChannelContext(ctx context.Context, channelType string, payload []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+
+	if err := c.faults().channelOpen(channelType); err != nil {
+		return nil, nil, err
+	}
+
+	result := make(chan openChannelResult, 1)
+	go func() {
+		channel, reqs, err := c.Client.OpenChannel(channelType, payload)
+		result <- openChannelResult{channel: channel, reqs: reqs, err: channelError(err)}
+	}()
+
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-result; r.err == nil {
+				r.channel.Close()
+				go ssh.DiscardRequests(r.reqs)
+			}
+		}()
+		return nil, nil, ctx.Err()
+	case r := <-result:
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+
+		if ctx.Done() == nil {
+			return r.channel, r.reqs, nil
+		}
+
+		// The requests are forwarded to learn when the channel is closed,
+		// so the close on ctx doesn't wait after a closed channel.
+		reqs := make(chan *ssh.Request)
+		closed := make(chan struct{})
+		go func() {
+			for req := range r.reqs {
+				reqs <- req
+			}
+			close(reqs)
+			close(closed)
+		}()
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				r.channel.Close()
+			case <-closed:
+			}
+		}()
+
+		return r.channel, reqs, nil
+	}
+}
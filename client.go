@@ -5,10 +5,15 @@ package goph
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -16,22 +21,166 @@ import (
 )
 
 type Config struct {
-	Auth         Auth
-	Protocol     string
-	Addr         string
+	Auth     Auth
+	Protocol string
+	Addr     string
+
+	// Addrs are fallback addresses tried in order when Addr can't be reached,
+	// an address may include its own port, otherwise Port is used.
+	Addrs []string
+
 	Port         uint
 	ClientConfig *ssh.ClientConfig
+
+	// Timeout bounds the connection dial and ssh handshake, 0 means
+	// ClientConfig.Timeout.
+	Timeout time.Duration
+
+	// Resolver used to lookup host names, nil means the default resolver.
+	Resolver *net.Resolver
+
+	// Hosts overrides name resolution, it maps a host name to an ip address.
+	Hosts map[string]string
+
+	// IOTimeout is the default Cmd.IOTimeout of the client commands.
+	IOTimeout time.Duration
+
+	// Knock sequence sent to the host before dialing the ssh port.
+	Knock []Knock
+
+	// Jump is the config of the jump host the connection goes through, like
+	// ssh ProxyJump. A jump config can have its own Jump to chain hosts,
+	// Knock is not sent to a host reached through a jump host.
+	Jump *Config
+
+	// SftpAutoTune sizes sftp packets to the server limits@openssh.com limits,
+	// it costs an extra session the first time NewSftp is called.
+	SftpAutoTune bool
+
+	// MinRSABits rejects RSA host keys smaller than this size, 0 means no check.
+	MinRSABits int
+
+	// PinnedHostKeyAlgorithms are the only host key algorithms negotiated and
+	// accepted, even if another key of the host is in known_hosts.
+	PinnedHostKeyAlgorithms []string
+
+	// OnWeakAlgorithm is called when WeakAlgorithms were negotiated.
+	OnWeakAlgorithm func(weak []string, negotiated Algorithms)
+
+	// StrictAlgorithms fails the connection with a *WeakAlgorithmError
+	// when WeakAlgorithms were negotiated.
+	StrictAlgorithms bool
+
+	// Faults injects failures for chaos testing, nil disables it.
+	Faults *Faults
+
+	// GlobalRequests are the handlers of server global requests by name.
+	GlobalRequests map[string]GlobalRequestHandler
+
+	// Logger logs client operations, nil disables logging.
+	Logger Logger
+
+	// Redactor masks its secrets in logs, command labels, recorded
+	// interactions and errors produced by goph.
+	Redactor *Redactor
+
+	// OutputEncoding is the default Cmd.Encoding of the client commands.
+	OutputEncoding string
+
+	// RemoteWindows forces Windows path handling in sftp helpers,
+	// otherwise it's detected from the server working directory.
+	RemoteWindows bool
+
+	// RemoteShell is the shell command helpers generate syntax for, empty
+	// means ShellNone: commands run as is with POSIX syntax.
+	RemoteShell Shell
+
+	// SendEnv are patterns of local env vars sent to every session.
+	SendEnv []string
+
+	// SetEnv are env vars set on every session.
+	SetEnv map[string]string
+
+	// Env vars set on every session before the Cmd.Env vars, unlike SetEnv
+	// a var rejected by the server (see sshd AcceptEnv) fails the session.
+	Env map[string]string
+
+	// CertRenewer certificate is tried before Auth, connections rejected by
+	// the server are retried once with a renewed certificate.
+	CertRenewer *CertRenewer
+
+	// OnCommand is the default Cmd.OnDone of the client commands.
+	OnCommand func(label string, duration time.Duration, exitCode int)
+
+	// IdleTimeout closes the sessions without activity for this duration,
+	// 0 disables it.
+	IdleTimeout time.Duration
+
+	// IdleCloseConn also closes the connection once it has no session for IdleTimeout.
+	IdleCloseConn bool
+
+	// OnIdle is called before closing an idle session, or the connection
+	// when s is nil, returning false keeps it open.
+	OnIdle func(c *Client, s *SessionInfo) bool
+
+	// OnDisconnect is called when the connection ends without Close.
+	OnDisconnect func(err *DisconnectError)
+
+	// MaxConcurrentSessions caps the sessions open at once, like the server
+	// MaxSessions, more sessions wait for one to close. A server refusing a
+	// session below the cap also makes it wait. 0 means no limit.
+	MaxConcurrentSessions int
+
+	// LeakDetection records where each channel is opened to report the
+	// ones not closed, see Leak. It's meant for debugging: a stack trace is
+	// captured per session.
+	LeakDetection bool
+
+	// OnLeak is called for each leak found with LeakDetection, nil logs it.
+	OnLeak func(leak Leak)
+
+	// KeepAliveInterval sends a keepalive@openssh.com request at this
+	// interval, like ssh ServerAliveInterval, 0 disables keepalives.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveCountMax closes the connection after this many unanswered
+	// keepalives, Wait then returns DisconnectKeepalive. 0 means 3.
+	KeepAliveCountMax int
+
+	// Escalate is the command prefix RunEscalate retries commands failing
+	// with a permission error with, like "sudo -n". Empty disables retries
+	// unless Sudo is set.
+	Escalate string
+
+	// Sudo is the privilege escalation of the host, see SudoConfig.
+	Sudo *SudoConfig
 }
 
 type Client struct {
 	*ssh.Client
 	Config *Config
+
+	limits     *limitsCache
+	algorithms Algorithms
+	requests   *requestRegistry
+	paths      *pathsCache
+	closed     *int32
+	counter    *countConn
+	tracker    *trackedConn
+	deadPeer   *int32
 }
 
-// DefaultTimeout is the timeout of ssh client connection.
+// DefaultTimeout is the Config.Timeout set by NewConfig.
 var DefaultTimeout = 20 * time.Second
 
+// DefaultPort is the standard ssh port.
+const DefaultPort = 22
+
+// NewConfig returns a tcp config with DefaultTimeout checking host keys with
+// DefaultKnownHosts. port is used as is, pass DefaultPort for the standard
+// ssh port. A zero port fails Validate unless every address has its own.
 func NewConfig(user string, addr string, port uint, auth Auth) (*Config, error) {
+	timeout := DefaultTimeout
 	callback, err := DefaultKnownHosts()
 	if err != nil {
 		return nil, err
@@ -42,22 +191,266 @@ func NewConfig(user string, addr string, port uint, auth Auth) (*Config, error)
 		Addr:     addr,
 		Port:     port,
 		Protocol: "tcp",
+		Timeout:  timeout,
 		ClientConfig: &ssh.ClientConfig{
 			User:            user,
 			Auth:            auth,
-			Timeout:         DefaultTimeout,
+			Timeout:         timeout,
 			HostKeyCallback: callback,
 		},
 	}, nil
 }
 
+// NewClient connects to the first reachable address of the config, it tries
+// Addr then Addrs in order. Only network errors fail over to the next address,
+// other errors like ErrAuthFailed or ErrHostKeyMismatch are returned right away.
+// When no address connects the error is a *FailoverError.
 func NewClient(c *Config) (*Client, error) {
-	client, err := ssh.Dial(c.Protocol, net.JoinHostPort(c.Addr, fmt.Sprint(c.Port)), c.ClientConfig)
+	return NewClientContext(context.Background(), c)
+}
+
+// NewClientContext is like NewClient, canceling ctx aborts the dial and the
+// handshake and returns the ctx error. ctx doesn't bound the connection once
+// established.
+func NewClientContext(ctx context.Context, c *Config) (*Client, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	addrs := c.addresses()
+	failover := &FailoverError{}
+
+	for _, addr := range addrs {
+
+		client, err := c.dialAddr(ctx, addr)
+		if err == nil {
+			return client, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if !isNetworkError(err) {
+			return nil, c.redactor().RedactError(err)
+		}
+
+		failover.Errors = append(failover.Errors, &AddrError{Addr: addr, Err: err})
+	}
+
+	return nil, c.redactor().RedactError(failover.err())
+}
+
+// dialAddr connects to addr, retrying once with a renewed certificate when
+// the server rejected the client.
+func (c *Config) dialAddr(ctx context.Context, addr string) (*Client, error) {
+
+	client, err := c.dial(ctx, addr)
+
+	if errors.Is(err, ErrAuthFailed) && c.CertRenewer != nil {
+		if err = c.CertRenewer.Renew(); err == nil {
+			client, err = c.dial(ctx, addr)
+		}
+	}
+
+	return client, err
+}
+
+// timeout returns the connection timeout.
+func (c *Config) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return c.ClientConfig.Timeout
+}
+
+// Validate checks the config is complete before dialing, the error is an
+// ErrInvalidConfig naming the invalid field. NewClient calls it, the Jump
+// configs are validated too.
+func (c *Config) Validate() error {
+
+	if c == nil {
+		return wrapError(ErrInvalidConfig, errors.New("config is nil"))
+	}
+
+	for jumps, config := 0, c; config != nil; jumps, config = jumps+1, config.Jump {
+
+		err := config.validate()
+		if err != nil && jumps > 0 {
+			err = fmt.Errorf("jump host %d: %w", jumps, err)
+		}
+
+		if err != nil {
+			return wrapError(ErrInvalidConfig, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validate() error {
+
+	switch c.Protocol {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("unsupported Protocol %q, want tcp, tcp4 or tcp6", c.Protocol)
+	}
+
+	if c.ClientConfig == nil {
+		return errors.New("ClientConfig is nil")
+	}
+
+	if c.ClientConfig.HostKeyCallback == nil {
+		return errors.New("ClientConfig.HostKeyCallback is nil, use ssh.InsecureIgnoreHostKey to skip host key checks")
+	}
+
+	if len(c.ClientConfig.Auth) == 0 && c.CertRenewer == nil {
+		return errors.New("no auth method in ClientConfig.Auth")
+	}
+
+	for i, method := range c.ClientConfig.Auth {
+		if method == nil {
+			return fmt.Errorf("auth method %d is nil", i)
+		}
+	}
+
+	for name, handler := range c.GlobalRequests {
+		if handler == nil {
+			return fmt.Errorf("GlobalRequests handler of %q is nil", name)
+		}
+	}
+
+	if c.Addr == "" && len(c.Addrs) == 0 {
+		return errors.New("no address to connect to")
+	}
+
+	for _, addr := range append([]string{c.Addr}, c.Addrs...) {
+
+		if addr == "" {
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			// Only a bare IPv6 address has colons without a port.
+			if strings.Contains(addr, ":") && net.ParseIP(addr) == nil {
+				return fmt.Errorf("invalid address %q: %w", addr, err)
+			}
+
+			if c.Port == 0 {
+				return fmt.Errorf("address %q has no port and Port is 0, use DefaultPort for the standard ssh port", addr)
+			}
+
+			host, port = addr, fmt.Sprint(c.Port)
+		}
+
+		if host == "" {
+			return fmt.Errorf("address %q has no host", addr)
+		}
+
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return fmt.Errorf("address %q has an invalid port", net.JoinHostPort(host, port))
+		}
+	}
+
+	return nil
+}
+
+// addresses returns the host:port list to dial, primary address first.
+func (c *Config) addresses() []string {
+
+	var addrs []string
+
+	for _, addr := range append([]string{c.Addr}, c.Addrs...) {
+
+		if addr == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, fmt.Sprint(c.Port))
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// Clone dials a new connection with the client config and overrides applied,
+// the client itself is left untouched.
+func (c Client) Clone(overrides ...Option) (*Client, error) {
+	return NewClient(c.Config.clone(overrides))
+}
+
+// BytesRead returns the number of bytes read from the connection.
+func (c Client) BytesRead() int64 {
+
+	if c.counter == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&c.counter.read)
+}
+
+// BytesWritten returns the number of bytes written to the connection.
+func (c Client) BytesWritten() int64 {
+
+	if c.counter == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&c.counter.written)
+}
+
+// Traffic returns the byte counters with the rates since the previous call.
+func (c Client) Traffic() Traffic {
+
+	if c.counter == nil {
+		return Traffic{}
+	}
+
+	return c.counter.snapshot()
+}
+
+// Algorithms returns the algorithms negotiated with the server.
+func (c Client) Algorithms() Algorithms {
+	return c.algorithms
+}
+
+// NewSession opens a new session channel.
+func (c Client) NewSession() (*ssh.Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// NewSessionContext is like NewSession, with Config.MaxConcurrentSessions it
+// waits for a free session until ctx is done.
+func (c Client) NewSessionContext(ctx context.Context) (*ssh.Session, error) {
+
+	if err := c.faults().channelOpen("session"); err != nil {
+		return nil, err
+	}
+
+	sess, err := c.openSession(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{Client: client, Config: c}, nil
+	if c.Config == nil {
+		return sess, nil
+	}
+
+	c.Config.setEnv(sess)
+
+	for name, value := range c.Config.Env {
+		if err = sess.Setenv(name, value); err != nil {
+			sess.Close()
+			return nil, fmt.Errorf("setenv %s: %w", name, err)
+		}
+	}
+
+	return sess, nil
 }
 
 // Run starts a new SSH session and runs the cmd, it returns CombinedOutput and err if any.
@@ -73,7 +466,23 @@ func (c *Client) Run(cmd string) ([]byte, error) {
 
 	defer sess.Close()
 
-	return sess.CombinedOutput(cmd)
+	return c.newCmd(sess, cmd, nil).CombinedOutput()
+}
+
+// RunWithInput is like Run with the cmd stdin read from stdin, for commands
+// like "cat > file" or "sh -s" reading a script. The remote stdin is closed
+// once stdin returns EOF.
+func (c Client) RunWithInput(cmd string, stdin io.Reader) ([]byte, error) {
+
+	command, err := c.Command(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer command.Session.Close()
+
+	command.Stdin = stdin
+
+	return command.CombinedOutput()
 }
 
 // Run starts a new SSH session with context and runs the cmd. It returns CombinedOutput and err if any.
@@ -98,50 +507,88 @@ func (c Client) Command(name string, args ...string) (*Cmd, error) {
 		return nil, err
 	}
 
-	return &Cmd{
+	return c.newCmd(sess, name, args), nil
+}
+
+// newCmd returns a Cmd running on sess with the client config defaults.
+func (c Client) newCmd(sess *ssh.Session, name string, args []string) *Cmd {
+
+	cmd := &Cmd{
 		Path:    name,
 		Args:    args,
 		Session: sess,
 		Context: context.Background(),
-	}, nil
+	}
+
+	if c.Config != nil {
+		cmd.IOTimeout = c.Config.IOTimeout
+		cmd.Encoding = c.Config.OutputEncoding
+		cmd.OnDone = c.Config.onCommand()
+		cmd.redactor = c.Config.Redactor
+		cmd.shell = c.Config.shell()
+	}
+
+	c.watchLeak(cmd)
+
+	return cmd
 }
 
 // Command returns new Cmd with context and error, if any.
 func (c Client) CommandContext(ctx context.Context, name string, args ...string) (*Cmd, error) {
-	cmd, err := c.Command(name, args...)
+
+	sess, err := c.NewSessionContext(ctx)
 	if err != nil {
-		return cmd, err
+		return nil, err
 	}
 
+	cmd := c.newCmd(sess, name, args)
 	cmd.Context = ctx
 
 	return cmd, nil
 }
 
-// NewSftp returns new sftp client and error if any.
-func (c Client) NewSftp(opts ...sftp.ClientOption) (*sftp.Client, error) {
-	return sftp.NewClient(c.Client, opts...)
-}
-
 // Close client net connection.
 func (c Client) Close() error {
+
+	// Leaks are reported by the first Close only.
+	if c.closed == nil || atomic.SwapInt32(c.closed, 1) == 0 {
+		c.reportLeaks()
+	}
+
 	return c.Client.Close()
 }
 
-// Upload a local file to remote server!
-func (c Client) Upload(localPath string, remotePath string) (err error) {
+// Upload a local file to remote server! A local symlink is always read
+// through, its target content is uploaded.
+func (c Client) Upload(localPath string, remotePath string, opts ...TransferOption) (err error) {
+	return c.UploadContext(context.Background(), localPath, remotePath, opts...)
+}
+
+// UploadContext is like Upload, canceling ctx aborts the transfer and
+// returns the ctx error. Use WithProgress to monitor it.
+func (c Client) UploadContext(ctx context.Context, localPath string, remotePath string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
 
 	local, err := os.Open(localPath)
 	if err != nil {
-		return
+		return fileError(err)
 	}
 	defer local.Close()
 
-	ftp, err := c.NewSftp()
-	if err != nil {
-		return
+	return c.withSftp(ctx, func(ftp *sftp.Client) error {
+		return uploadFile(ftp, local, c.remotePath(ftp, remotePath), o)
+	})
+}
+
+// uploadFile copies local to remotePath with the transfer options.
+func uploadFile(ftp *sftp.Client, local *os.File, remotePath string, o *transferOptions) (err error) {
+
+	if o.mkdirs {
+		if err = mkdirAll(ftp, path.Dir(remotePath), o.dirMode); err != nil {
+			return
+		}
 	}
-	defer ftp.Close()
 
 	remote, err := ftp.Create(remotePath)
 	if err != nil {
@@ -149,12 +596,35 @@ func (c Client) Upload(localPath string, remotePath string) (err error) {
 	}
 	defer remote.Close()
 
-	_, err = io.Copy(remote, local)
+	if _, err = o.copy(remote, local, fileSize(local)); err != nil {
+		return
+	}
+
+	if o.fsync {
+		err = remote.Sync()
+	}
+
 	return
 }
 
 // Download file from remote server!
-func (c Client) Download(remotePath string, localPath string) (err error) {
+func (c Client) Download(remotePath string, localPath string, opts ...TransferOption) (err error) {
+	return c.DownloadContext(context.Background(), remotePath, localPath, opts...)
+}
+
+// DownloadContext is like Download, canceling ctx aborts the transfer and
+// returns the ctx error. Use WithProgress to monitor it.
+func (c Client) DownloadContext(ctx context.Context, remotePath string, localPath string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
+
+	return c.withSftp(ctx, func(ftp *sftp.Client) error {
+		return downloadFile(ftp, c.remotePath(ftp, remotePath), localPath, o)
+	})
+}
+
+// downloadFile copies remotePath to localPath with the transfer options.
+func downloadFile(ftp *sftp.Client, remotePath string, localPath string, o *transferOptions) (err error) {
 
 	local, err := os.Create(localPath)
 	if err != nil {
@@ -162,22 +632,21 @@ func (c Client) Download(remotePath string, localPath string) (err error) {
 	}
 	defer local.Close()
 
-	ftp, err := c.NewSftp()
-	if err != nil {
-		return
-	}
-	defer ftp.Close()
-
 	remote, err := ftp.Open(remotePath)
 	if err != nil {
-		return
+		return fileError(err)
 	}
 	defer remote.Close()
 
-	if _, err = io.Copy(local, remote); err != nil {
+	if size := fileSize(remote); o.parallel(size) {
+		err = o.parallelDownload(ftp, remotePath, local, size)
+	} else {
+		_, err = o.copy(local, remote, size)
+	}
+
+	if err != nil {
 		return
 	}
 
 	return local.Sync()
 }
-
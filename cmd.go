@@ -4,30 +4,123 @@
 package goph
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrIOTimeout is returned when a command produces no output for Cmd.IOTimeout.
+var ErrIOTimeout = errors.New("cmd i/o timeout")
+
+// ExitError is the error of a command that exited with a non zero status,
+// was killed by a signal or exited without reporting a status. It wraps the
+// *ssh.ExitError or *ssh.ExitMissingError of the session.
+type ExitError struct {
+
+	// Status is the exit status, 128+n when killed by signal n like shells
+	// report it, -1 when the server reported no status.
+	Status int
+
+	// Signal is the name of the signal that killed the command, like KILL.
+	Signal string
+
+	// Stderr is the start of the command stderr when collected by Output.
+	Stderr []byte
+
+	err error
+}
+
+func (e *ExitError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the session error.
+func (e *ExitError) Unwrap() error {
+	return e.err
+}
+
+// ExitStatus returns the exit status.
+func (e *ExitError) ExitStatus() int {
+	return e.Status
+}
+
+// Exited reports whether the command exited with a status.
+func (e *ExitError) Exited() bool {
+	return e.Status >= 0 && e.Signal == ""
+}
+
+// exitError converts the session exit errors to an *ExitError.
+func exitError(err error, stderr []byte) error {
+
+	switch e := err.(type) {
+	case *ssh.ExitError:
+		return &ExitError{Status: e.ExitStatus(), Signal: e.Signal(), Stderr: stderr, err: e}
+	case *ssh.ExitMissingError:
+		return &ExitError{Status: -1, Stderr: stderr, err: e}
+	}
+
+	return err
+}
+
 // Cmd it's like os/exec.Cmd but for ssh session.
 type Cmd struct {
 
 	// Path to command executable filename
 	Path string
 
-	// Command args.
+	// Command args, each quoted so the remote shell passes it literally.
 	Args []string
 
-	// Session env vars.
+	// Raw joins Args as is, for args using shell syntax like globs or
+	// variables. ssh has no exec without a shell, the remote shell always
+	// parses the command line.
+	Raw bool
+
+	// Session env vars, as KEY=value, set with Session.Setenv. The server
+	// may reject vars, see sshd AcceptEnv.
 	Env []string
 
+	// Dir is the remote working directory of the command, empty means the
+	// server default, usually the user home.
+	Dir string
+
 	// SSH session.
 	*ssh.Session
 
 	// Context for cancellation
 	Context context.Context
+
+	// IOTimeout aborts Run, Output and CombinedOutput when the command
+	// writes nothing to stdout or stderr for this duration, 0 means no timeout.
+	IOTimeout time.Duration
+
+	// Encoding of the command output converted to UTF-8 by Output and
+	// CombinedOutput, empty means no conversion, see Transcode.
+	Encoding string
+
+	// Label names the command in OnDone, empty means Path.
+	Label string
+
+	// OnDone is called after Run, Output and CombinedOutput with the command
+	// duration and exit code, -1 when the command didn't exit normally.
+	OnDone func(label string, duration time.Duration, exitCode int)
+
+	// redactor masks secrets in the command errors.
+	redactor *Redactor
+
+	// waited is closed by Wait to stop watching Context.
+	waited chan struct{}
+
+	// shell is the remote shell Dir is changed with.
+	shell Shell
 }
 
 // CombinedOutput runs cmd on the remote host and returns its combined stdout and stderr.
@@ -36,9 +129,21 @@ func (c *Cmd) CombinedOutput() ([]byte, error) {
 		return nil, errors.Wrap(err, "cmd init")
 	}
 
-	return c.runWithContext(func() ([]byte, error) {
-		return c.Session.CombinedOutput(c.String())
-	})
+	if c.Stdout != nil {
+		return nil, errors.New("ssh: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("ssh: Stderr already set")
+	}
+
+	var b syncBuffer
+	c.Stdout = &b
+	c.Stderr = &b
+
+	return c.transcode(c.runWithContext(func() ([]byte, error) {
+		err := c.Session.Run(c.line())
+		return b.Bytes(), err
+	}))
 }
 
 // Output runs cmd on the remote host and returns its stdout.
@@ -47,9 +152,48 @@ func (c *Cmd) Output() ([]byte, error) {
 		return nil, errors.Wrap(err, "cmd init")
 	}
 
-	return c.runWithContext(func() ([]byte, error) {
-		return c.Session.Output(c.String())
-	})
+	if c.Stdout != nil {
+		return nil, errors.New("ssh: Stdout already set")
+	}
+
+	var (
+		b      syncBuffer
+		stderr *prefixBuffer
+	)
+	c.Stdout = &b
+
+	// Like os/exec the start of stderr is kept for the ExitError.
+	if c.Stderr == nil {
+		stderr = &prefixBuffer{max: stderrPrefixSize}
+		c.Stderr = stderr
+	}
+
+	return c.transcode(c.runWithContext(func() ([]byte, error) {
+		err := c.Session.Run(c.line())
+		if stderr != nil {
+			err = exitError(err, stderr.b.Bytes())
+		}
+		return b.Bytes(), err
+	}))
+}
+
+// transcode converts output from the cmd encoding, the command error takes
+// precedence over a conversion error.
+func (c *Cmd) transcode(output []byte, err error) ([]byte, error) {
+
+	if c.Encoding == "" {
+		return output, err
+	}
+
+	converted, terr := Transcode(output, c.Encoding)
+	if terr != nil {
+		if err == nil {
+			err = errors.Wrap(terr, "transcode output")
+		}
+		return output, err
+	}
+
+	return converted, err
 }
 
 // Run runs cmd on the remote host.
@@ -59,28 +203,151 @@ func (c *Cmd) Run() error {
 	}
 
 	_, err := c.runWithContext(func() ([]byte, error) {
-		return nil, c.Session.Run(c.String())
+		return nil, c.Session.Run(c.line())
 	})
 
 	return err
 }
 
-// Start runs the command on the remote host.
+// Start starts the command on the remote host without waiting for it, the
+// command is interrupted and its session closed when Context is done before
+// Wait returns.
 func (c *Cmd) Start() error {
 	if err := c.init(); err != nil {
 		return errors.Wrap(err, "cmd init")
 	}
-	return c.Session.Start(c.String())
+
+	if err := c.Session.Start(c.line()); err != nil {
+		return err
+	}
+
+	if done := c.Context.Done(); done != nil {
+		c.waited = make(chan struct{})
+		go func() {
+			select {
+			case <-done:
+				// Servers may ignore signals, closing the session ends Wait.
+				_ = c.Session.Signal(ssh.SIGINT)
+				_ = c.Session.Close()
+			case <-c.waited:
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Wait waits for the started command to exit, the error is an *ExitError
+// when the command didn't exit successfully or the Context error when the
+// command was interrupted.
+func (c *Cmd) Wait() error {
+
+	err := c.Session.Wait()
+	if c.waited != nil {
+		close(c.waited)
+	}
+
+	if err != nil && c.Context.Err() != nil {
+		return c.Context.Err()
+	}
+
+	return c.redactor.RedactError(exitError(err, nil))
+}
+
+// Stdio starts the command and returns its stdin and stdout as a single
+// stream, for protocols spoken over a remote process like nc or socat.
+// Closing the stream closes stdin and waits for the command to exit.
+func (c *Cmd) Stdio() (io.ReadWriteCloser, error) {
+
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Start(); err != nil {
+		return nil, err
+	}
+
+	return &cmdStdio{Reader: stdout, stdin: stdin, cmd: c}, nil
+}
+
+type cmdStdio struct {
+	io.Reader
+	stdin io.WriteCloser
+	cmd   *Cmd
+	once  sync.Once
+	err   error
+}
+
+func (s *cmdStdio) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+func (s *cmdStdio) Close() error {
+
+	s.once.Do(func() {
+		s.stdin.Close()
+		s.err = s.cmd.Wait()
+		s.cmd.Session.Close()
+	})
+
+	return s.err
 }
 
-// String return the command line string.
+// String return the command line string, Path is used as is so it can
+// hold a whole command line.
 func (c *Cmd) String() string {
-	return fmt.Sprintf("%s %s", c.Path, strings.Join(c.Args, " "))
+
+	if len(c.Args) == 0 {
+		return c.Path
+	}
+
+	if c.Raw {
+		return fmt.Sprintf("%s %s", c.Path, strings.Join(c.Args, " "))
+	}
+
+	shell := c.shell
+	if !shell.Windows() {
+		shell = ShellSh
+	}
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = shell.Quote(arg)
+	}
+
+	return fmt.Sprintf("%s %s", c.Path, strings.Join(args, " "))
+}
+
+// line returns the command line run by the session, it changes to Dir first.
+func (c *Cmd) line() string {
+
+	if c.Dir == "" {
+		return c.String()
+	}
+
+	switch c.shell {
+	case ShellPowerShell:
+		return "Set-Location -LiteralPath " + c.shell.Quote(c.Dir) + " -ErrorAction Stop; " + c.String()
+	case ShellCmd:
+		return "cd /d " + c.shell.Quote(c.Dir) + " && " + c.String()
+	default:
+		return "cd " + ShellSh.Quote(c.Dir) + " && " + c.String()
+	}
 }
 
 // Init inits and sets session env vars.
 func (c *Cmd) init() (err error) {
 
+	if err = checkEncoding(c.Encoding); err != nil {
+		return
+	}
+
 	// Set session env vars
 	var env []string
 	for _, value := range c.Env {
@@ -100,8 +367,27 @@ type ctxCmdOutput struct {
 }
 
 // Executes the given callback within session. Sends SIGINT when the context is canceled.
-func (c *Cmd) runWithContext(callback func() ([]byte, error)) ([]byte, error) {
-	outputChan := make(chan ctxCmdOutput)
+func (c *Cmd) runWithContext(callback func() ([]byte, error)) (_ []byte, err error) {
+
+	defer func() {
+		err = c.redactor.RedactError(err)
+	}()
+
+	if c.OnDone != nil {
+		start := time.Now()
+		defer func() {
+			c.OnDone(c.label(), time.Since(start), exitCode(err))
+		}()
+	}
+
+	var idle <-chan struct{}
+	if c.IOTimeout > 0 {
+		w := c.watchIO()
+		defer w.stop()
+		idle = w.expired
+	}
+
+	outputChan := make(chan ctxCmdOutput, 1)
 	go func() {
 		output, err := callback()
 		outputChan <- ctxCmdOutput{
@@ -115,7 +401,146 @@ func (c *Cmd) runWithContext(callback func() ([]byte, error)) ([]byte, error) {
 		_ = c.Session.Signal(ssh.SIGINT)
 
 		return nil, c.Context.Err()
+	case <-idle:
+		_ = c.Session.Signal(ssh.SIGINT)
+
+		return nil, ErrIOTimeout
 	case result := <-outputChan:
-		return result.output, result.err
+		return result.output, exitError(result.err, nil)
+	}
+}
+
+func (c *Cmd) label() string {
+	if c.Label != "" {
+		return c.Label
+	}
+	return c.Path
+}
+
+// exitCode returns the command exit code of a run error.
+func exitCode(err error) int {
+
+	if err == nil {
+		return 0
 	}
+
+	var exitErr ExitStatusError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+
+	return -1
+}
+
+// ioWatcher tracks the last time the command wrote output.
+type ioWatcher struct {
+	last    int64
+	expired chan struct{}
+	done    chan struct{}
+}
+
+// watchIO wraps the session stdout and stderr to record activity, expired is
+// closed once the command stays silent longer than IOTimeout.
+func (c *Cmd) watchIO() *ioWatcher {
+
+	w := &ioWatcher{
+		last:    time.Now().UnixNano(),
+		expired: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if c.Stdout == nil {
+		c.Stdout = ioutil.Discard
+	}
+	if c.Stderr == nil {
+		c.Stderr = ioutil.Discard
+	}
+
+	c.Stdout = &activityWriter{Writer: c.Stdout, last: &w.last}
+	c.Stderr = &activityWriter{Writer: c.Stderr, last: &w.last}
+
+	go func() {
+		ticker := time.NewTicker(checkInterval(c.IOTimeout, 4))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case now := <-ticker.C:
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&w.last))) > c.IOTimeout {
+					close(w.expired)
+					return
+				}
+			}
+		}
+	}()
+
+	return w
+}
+
+// minCheckInterval is the shortest interval timeouts are checked at.
+const minCheckInterval = 10 * time.Millisecond
+
+// checkInterval returns timeout/n, at least minCheckInterval as
+// time.NewTicker panics on intervals below 1ns.
+func checkInterval(timeout time.Duration, n time.Duration) time.Duration {
+	if interval := timeout / n; interval > minCheckInterval {
+		return interval
+	}
+	return minCheckInterval
+}
+
+func (w *ioWatcher) stop() {
+	close(w.done)
+}
+
+// activityWriter records the time of every write.
+type activityWriter struct {
+	io.Writer
+	last *int64
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(w.last, time.Now().UnixNano())
+	return w.Writer.Write(p)
+}
+
+// stderrPrefixSize is the stderr size kept by Output.
+const stderrPrefixSize = 32 << 10
+
+// prefixBuffer keeps the first max bytes written.
+type prefixBuffer struct {
+	b   bytes.Buffer
+	max int
+}
+
+func (b *prefixBuffer) Write(p []byte) (int, error) {
+
+	if n := b.max - b.b.Len(); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		b.b.Write(p[:n])
+	}
+
+	return len(p), nil
+}
+
+// syncBuffer is a bytes.Buffer safe to share between stdout and stderr.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Bytes()
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahmet2mir/goph"
+	"github.com/ahmet2mir/goph/internal/cli"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const usage = `Usage: goph [flags] <command> [args]
+
+Commands:
+  run      [user@]host command...          run a command
+  shell    [user@]host                     open an interactive shell
+  upload   [user@]host local remote        upload a file
+  download [user@]host remote local        download a file
+  sync     [user@]host localdir remotedir  upload a directory tree
+  tunnel   [user@]host laddr raddr         forward local laddr to raddr
+  socks    [user@]host laddr               run a SOCKS5 proxy on laddr
+  fleet    host1,host2,... command...      run a command on many hosts
+
+Flags:
+`
+
+var (
+	port     uint
+	auth     cli.AuthFlags
+	insecure bool
+	timeout  time.Duration
+	parallel int
+)
+
+func init() {
+	flag.UintVar(&port, "port", 22, "ssh port number, a host:port target overrides it.")
+	auth.Register(flag.CommandLine)
+	flag.BoolVar(&insecure, "insecure", false, "skip host key verification, for test machines only.")
+	flag.DurationVar(&timeout, "timeout", 0, "interrupt commands with SIGINT after a given timeout (0 means no timeout).")
+	flag.IntVar(&parallel, "parallel", 10, "number of fleet hosts handled at once (0 means all).")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the command line and returns the exit code, so deferred calls
+// like closing the client run before exiting.
+func run() int {
+
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		return 2
+	}
+
+	sshAuth, err := auth.Auth()
+	if err != nil {
+		return fatal(err)
+	}
+
+	cmd, target, args := args[0], args[1], args[2:]
+
+	if cmd == "fleet" {
+		if len(args) == 0 {
+			return fatal(errors.New("fleet: missing command"))
+		}
+		return fleet(strings.Split(target, ","), sshAuth, strings.Join(args, " "))
+	}
+
+	client, err := connect(target, sshAuth)
+	if err != nil {
+		return fatal(err)
+	}
+	defer client.Close()
+
+	switch cmd {
+	case "run":
+		if len(args) == 0 {
+			err = errors.New("run: missing command")
+			break
+		}
+		err = runCommand(client, strings.Join(args, " "))
+
+	case "shell":
+		err = shell(client)
+
+	case "upload":
+		if len(args) != 2 {
+			err = errors.New("upload: want local and remote paths")
+			break
+		}
+		err = client.Upload(args[0], args[1], progress())
+
+	case "download":
+		if len(args) != 2 {
+			err = errors.New("download: want remote and local paths")
+			break
+		}
+		err = client.Download(args[0], args[1], progress())
+
+	case "sync":
+		if len(args) != 2 {
+			err = errors.New("sync: want local and remote directories")
+			break
+		}
+		err = client.UploadDir(args[0], args[1])
+
+	case "tunnel":
+		if len(args) != 2 {
+			err = errors.New("tunnel: want local and remote addresses")
+			break
+		}
+		err = tunnel(client, args[0], args[1])
+
+	case "socks":
+		if len(args) != 1 {
+			err = errors.New("socks: want a local address")
+			break
+		}
+		fmt.Fprintf(os.Stderr, "socks proxy on %s\n", args[0])
+		err = client.StartSocksProxy(context.Background(), args[0])
+
+	default:
+		flag.Usage()
+		return 2
+	}
+
+	if err != nil {
+		return fatal(err)
+	}
+
+	return 0
+}
+
+// fatal prints err and returns the failure exit code.
+func fatal(err error) int {
+	fmt.Fprintln(os.Stderr, "goph:", err)
+	return 1
+}
+
+// connect parses a [user@]host[:port] target and connects to it.
+func connect(target string, auth goph.Auth) (*goph.Client, error) {
+
+	config, err := targetConfig(target, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return goph.NewClient(config)
+}
+
+// targetConfig parses a [user@]host[:port] target and returns its config.
+func targetConfig(target string, auth goph.Auth) (*goph.Config, error) {
+
+	user, addr, p, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := goph.NewConfig(user, addr, p, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if insecure {
+		config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
+
+	return config, nil
+}
+
+func parseTarget(target string) (user string, addr string, p uint, err error) {
+
+	p = port
+	addr = target
+
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		user, addr = target[:i], target[i+1:]
+	} else {
+		usr, err := osuser.Current()
+		if err != nil {
+			return "", "", 0, fmt.Errorf("couldn't determine current user: %w", err)
+		}
+		user = usr.Username
+	}
+
+	host, sport, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		// A host without port, a bracketed IPv6 address loses its brackets.
+		if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+			addr = addr[1 : len(addr)-1]
+		}
+		return user, addr, p, nil
+	}
+
+	n, err := strconv.ParseUint(sport, 10, 16)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid port %q", sport)
+	}
+
+	return user, host, uint(n), nil
+}
+
+func commandContext(parent context.Context) (context.Context, context.CancelFunc) {
+
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	return context.WithCancel(parent)
+}
+
+func runCommand(client *goph.Client, command string) error {
+
+	ctx, cancel := commandContext(context.Background())
+	defer cancel()
+
+	cmd, err := client.CommandContext(ctx, command)
+	if err != nil {
+		return err
+	}
+	defer cmd.Session.Close()
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func shell(client *goph.Client) error {
+
+	var opts []goph.ShellOption
+
+	// Protect the local terminal from remote escape sequences.
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		opts = append(opts, goph.WithTerminalFilter())
+	}
+
+	return client.Shell(opts...)
+}
+
+// progress prints the transfer progress on stderr.
+func progress() goph.TransferOption {
+	return goph.WithProgress(func(p goph.Progress) {
+		if p.Total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%d/%d bytes (%.0f B/s)", p.Transferred, p.Total, p.Rate)
+			if p.Transferred == p.Total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	})
+}
+
+// tunnel forwards connections of localAddr to remoteAddr through the client.
+func tunnel(client *goph.Client, localAddr string, remoteAddr string) error {
+
+	ln, err := client.LocalForward(context.Background(), localAddr, remoteAddr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "forwarding %s to %s\n", ln.Addr(), remoteAddr)
+
+	return client.Wait()
+}
+
+// fleet runs command on hosts, parallel at once, it prints the output of
+// every host prefixed by its name and returns 1 when any host failed.
+func fleet(hosts []string, auth goph.Auth, command string) int {
+
+	failed := 0
+
+	g := goph.NewGroup(parallel)
+	defer g.Close()
+
+	for _, host := range hosts {
+
+		config, err := targetConfig(host, auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", host, err)
+			failed++
+			continue
+		}
+
+		g.AddConfig(host, config)
+	}
+
+	results := g.Do(context.Background(), func(ctx context.Context, c *goph.Client) ([]byte, error) {
+
+		ctx, cancel := commandContext(ctx)
+		defer cancel()
+
+		return c.RunContext(ctx, command)
+	})
+
+	for _, host := range g.Hosts() {
+
+		res := results[host]
+
+		scanner := bufio.NewScanner(bytes.NewReader(res.Output))
+		for scanner.Scan() {
+			fmt.Printf("%s: %s\n", host, scanner.Text())
+		}
+
+		if res.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", host, res.Err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+
+	port = 22
+
+	tests := []struct {
+		target string
+		addr   string
+		port   uint
+	}{
+		{"root@example.com", "example.com", 22},
+		{"root@example.com:2222", "example.com", 2222},
+		{"root@[::1]:2222", "::1", 2222},
+		{"root@[::1]", "::1", 22},
+		{"root@::1", "::1", 22},
+	}
+
+	for _, tt := range tests {
+		user, addr, p, err := parseTarget(tt.target)
+		if err != nil || user != "root" || addr != tt.addr || p != tt.port {
+			t.Errorf("parseTarget(%q) = %s, %s, %d, %v", tt.target, user, addr, p, err)
+		}
+	}
+
+	if _, _, _, err := parseTarget("root@example.com:ssh"); err == nil {
+		t.Error("expected invalid port error")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var cronEnvLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+
+// CronEntry is a scheduled command of a crontab.
+type CronEntry struct {
+
+	// Schedule is the five time fields, like "*/5 * * * *", or a special
+	// string like "@reboot".
+	Schedule string
+
+	// Command is the rest of the line.
+	Command string
+}
+
+func (e CronEntry) String() string {
+	return e.Schedule + " " + e.Command
+}
+
+// Crontab edits the crontab of the remote user with the crontab command,
+// comments and env lines are kept as is.
+type Crontab struct {
+
+	// User whose crontab is edited with crontab -u, empty means the
+	// connected user.
+	User string
+
+	client Client
+}
+
+// Crontab returns the crontab of the connected user.
+func (c Client) Crontab() *Crontab {
+	return &Crontab{client: c}
+}
+
+// List returns the crontab entries, none when the user has no crontab.
+func (t *Crontab) List() ([]CronEntry, error) {
+
+	lines, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CronEntry
+	for _, line := range lines {
+		if e, ok := parseCronLine(line); ok {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// Add appends the entry, an identical entry isn't added twice.
+func (t *Crontab) Add(e CronEntry) error {
+
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	lines, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if cur, ok := parseCronLine(line); ok && cur == e {
+			return nil
+		}
+	}
+
+	return t.write(append(lines, e.String()))
+}
+
+// Remove deletes the entries running command and returns their number, the
+// crontab isn't written when none matches.
+func (t *Crontab) Remove(command string) (int, error) {
+
+	lines, err := t.read()
+	if err != nil {
+		return 0, err
+	}
+
+	kept := lines[:0]
+	for _, line := range lines {
+		if e, ok := parseCronLine(line); ok && e.Command == command {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	removed := len(lines) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, t.write(kept)
+}
+
+func (t *Crontab) command(arg string) string {
+
+	cmd := "crontab"
+	if t.User != "" {
+		cmd += " -u " + ShellSh.Quote(t.User)
+	}
+
+	return cmd + " " + arg
+}
+
+// read returns the crontab lines.
+func (t *Crontab) read() ([]string, error) {
+
+	cmd, err := t.client.Command(t.command("-l"))
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Session.Close()
+
+	var stderr syncBuffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("no crontab for")) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("crontab -l: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	text := strings.TrimRight(string(out), "\n")
+	if text == "" {
+		return nil, nil
+	}
+
+	return strings.Split(text, "\n"), nil
+}
+
+// write replaces the crontab with lines.
+func (t *Crontab) write(lines []string) error {
+
+	cmd, err := t.client.Command(t.command("-"))
+	if err != nil {
+		return err
+	}
+	defer cmd.Session.Close()
+
+	// cron ignores a last line without newline.
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("crontab: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+func (e CronEntry) validate() error {
+
+	if strings.ContainsAny(e.Schedule+e.Command, "\r\n") {
+		return errors.New("crontab: entry contains a newline")
+	}
+
+	if strings.TrimSpace(e.Command) == "" {
+		return errors.New("crontab: empty command")
+	}
+
+	parsed, ok := parseCronLine(e.String())
+	if !ok || parsed != e {
+		return fmt.Errorf("crontab: invalid schedule %q", e.Schedule)
+	}
+
+	return nil
+}
+
+// parseCronLine parses an entry line, comments, env and blank lines are
+// not entries.
+func parseCronLine(line string) (CronEntry, bool) {
+
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || cronEnvLine.MatchString(line) {
+		return CronEntry{}, false
+	}
+
+	n := 5
+	if line[0] == '@' {
+		n = 1
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) <= n {
+		return CronEntry{}, false
+	}
+
+	// The command is the rest of the line after the n schedule fields.
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[strings.IndexAny(rest, " \t"):]
+	}
+
+	return CronEntry{Schedule: strings.Join(fields[:n], " "), Command: strings.TrimSpace(rest)}, true
+}
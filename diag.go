@@ -0,0 +1,244 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultDiagnosticsMaxFileSize is the DiagnosticsSpec.MaxFileSize default.
+const defaultDiagnosticsMaxFileSize = 16 << 20
+
+// systemDiagnostics are the commands collected with DiagnosticsSpec.SystemInfo.
+var systemDiagnostics = []DiagnosticsCommand{
+	{Name: "uname", Cmd: "uname -a"},
+	{Name: "uptime", Cmd: "uptime"},
+	{Name: "df", Cmd: "df -Pk"},
+	{Name: "os-release", Cmd: "cat /etc/os-release"},
+}
+
+// DiagnosticsSpec declares what CollectDiagnostics gathers.
+type DiagnosticsSpec struct {
+
+	// Output is the local tar.gz path.
+	Output string
+
+	// Files are remote files, stored under files/ with their full path.
+	Files []string
+
+	// Commands outputs are stored under commands/.
+	Commands []DiagnosticsCommand
+
+	// SystemInfo adds the uname, uptime, df and os-release of POSIX hosts
+	// under system/.
+	SystemInfo bool
+
+	// MaxFileSize keeps the end of larger files, 0 means 16 MiB.
+	MaxFileSize int64
+
+	// Timeout bounds each command, 0 means no timeout.
+	Timeout time.Duration
+}
+
+// DiagnosticsCommand is a command collected by CollectDiagnostics.
+type DiagnosticsCommand struct {
+
+	// Name is the archived file name without the .txt extension.
+	Name string
+
+	// Cmd is the command, its combined output is archived.
+	Cmd string
+}
+
+// DiagnosticsManifest describes a diagnostics bundle, it's archived as
+// manifest.json.
+type DiagnosticsManifest struct {
+	Host      string             `json:"host"`
+	Collected time.Time          `json:"collected"`
+	Entries   []DiagnosticsEntry `json:"entries"`
+}
+
+// DiagnosticsEntry is a collected file or command, a failed entry has an
+// Error and is archived only if it got some output.
+type DiagnosticsEntry struct {
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+	ExitCode  int    `json:"exit_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CollectDiagnostics gathers the spec files and command outputs into a
+// support bundle. Missing files and failed commands are recorded in the
+// manifest and don't stop the collection.
+func (c Client) CollectDiagnostics(ctx context.Context, spec DiagnosticsSpec) (*DiagnosticsManifest, error) {
+
+	if spec.Output == "" {
+		return nil, errors.New("diagnostics: missing output path")
+	}
+
+	if spec.MaxFileSize <= 0 {
+		spec.MaxFileSize = defaultDiagnosticsMaxFileSize
+	}
+
+	manifest := &DiagnosticsManifest{Collected: time.Now().UTC()}
+	if c.Config != nil {
+		manifest.Host = c.Config.Addr
+	}
+
+	f, err := os.Create(spec.Output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	add := func(e DiagnosticsEntry, data []byte) error {
+		e.Size = int64(len(data))
+		manifest.Entries = append(manifest.Entries, e)
+		if e.Error != "" && len(data) == 0 {
+			return nil
+		}
+		return writeTarFile(tw, e.Name, data, manifest.Collected)
+	}
+
+	if len(spec.Files) > 0 {
+		err = c.withSftp(ctx, func(ftp *sftp.Client) error {
+			for _, file := range spec.Files {
+				e := DiagnosticsEntry{Name: path.Join("files", strings.TrimLeft(file, "/")), Source: file}
+				data, truncated, err := readTail(ftp, c.remotePath(ftp, file), spec.MaxFileSize)
+				if err != nil {
+					e.Error = err.Error()
+				}
+				e.Truncated = truncated
+				if err = add(e, data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commands := make([]DiagnosticsEntry, 0, len(spec.Commands)+len(systemDiagnostics))
+	for _, cmd := range spec.Commands {
+		commands = append(commands, DiagnosticsEntry{Name: path.Join("commands", cmd.Name+".txt"), Source: cmd.Cmd})
+	}
+	if spec.SystemInfo {
+		for _, cmd := range systemDiagnostics {
+			commands = append(commands, DiagnosticsEntry{Name: path.Join("system", cmd.Name+".txt"), Source: cmd.Cmd})
+		}
+	}
+
+	for _, e := range commands {
+
+		out, err := c.runDiagnostics(ctx, e.Source, spec.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			e.Error = err.Error()
+			var exit *ssh.ExitError
+			if errors.As(err, &exit) {
+				e.ExitCode = exit.ExitStatus()
+			}
+		}
+
+		if err = add(e, out); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = writeTarFile(tw, "manifest.json", data, manifest.Collected); err != nil {
+		return nil, err
+	}
+
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, f.Close()
+}
+
+func (c Client) runDiagnostics(ctx context.Context, cmd string, timeout time.Duration) ([]byte, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return c.RunContext(ctx, cmd)
+}
+
+// readTail reads the last max bytes of the remote file.
+func readTail(ftp *sftp.Client, file string, max int64) ([]byte, bool, error) {
+
+	f, err := ftp.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := info.Size() > max
+	if truncated {
+		if _, err = f.Seek(info.Size()-max, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, max))
+
+	return data, truncated, err
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+
+	return err
+}
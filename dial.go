@@ -0,0 +1,266 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dial connects to addr and performs the ssh handshake, canceling ctx
+// closes the connection during the handshake.
+func (c *Config) dial(ctx context.Context, addr string) (*Client, error) {
+
+	nconn, err := c.dialConn(ctx, addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, dialError(err, nil)
+	}
+
+	var (
+		hostKeyErr  error
+		hostKeyType string
+		counter     = newCountConn(c.Faults.wrap(nconn))
+		conn        = &recordConn{Conn: counter}
+		config      = c.clientConfig()
+		callback    = config.HostKeyCallback
+	)
+
+	if callback != nil {
+		config.HostKeyCallback = func(host string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyType = key.Type()
+			hostKeyErr = callback(host, remote, key)
+			return hostKeyErr
+		}
+	}
+
+	// ClientConfig.Timeout only bounds ssh.Dial, the handshake gets its own
+	// deadline. Jump host channels don't support deadlines, closing the conn
+	// on timeout bounds the handshake instead.
+	var (
+		deadline time.Time
+		stop     = func() bool { return true }
+	)
+	if timeout := c.timeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		if conn.SetDeadline(deadline) != nil {
+			stop = time.AfterFunc(timeout, func() { conn.Close() }).Stop
+		}
+	}
+
+	handshake := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshake:
+		}
+	}()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(handshake)
+	if !stop() && err == nil {
+		sshConn.Close()
+		err = errors.New("handshake timed out")
+	}
+
+	if ctx.Err() != nil {
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+
+		// The handshake error is a string, the deadline tells timeouts apart.
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, wrapError(ErrConnTimeout, err)
+		}
+
+		// Keep the connection error the handshake failed on, so network
+		// failures can be told apart from the server rejecting the client.
+		if ioErr := conn.ioErr(); ioErr != nil && hostKeyErr == nil && !strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, fmt.Errorf("ssh: handshake failed: %w", ioErr)
+		}
+
+		return nil, dialError(err, hostKeyErr)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// Global requests are served by the registry, ssh.NewClient gets a
+	// channel without requests closed with the connection.
+	registry := newRequestRegistry(c.GlobalRequests)
+	noReqs := make(chan *ssh.Request)
+	go func() {
+		registry.serve(reqs)
+		close(noReqs)
+	}()
+
+	tracker := newTrackedConn(sshConn)
+	tracker.stacks = c.LeakDetection
+	if c.MaxConcurrentSessions > 0 {
+		tracker.slots = make(chan struct{}, c.MaxConcurrentSessions)
+	}
+
+	client := &Client{
+		Client:   ssh.NewClient(tracker, chans, noReqs),
+		Config:   c,
+		limits:   &limitsCache{},
+		requests: registry,
+		paths:    &pathsCache{},
+		closed:   new(int32),
+		counter:  counter,
+		tracker:  tracker,
+		deadPeer: new(int32),
+	}
+
+	// An unparsable key exchange only leaves Algorithms empty unless strict.
+	if client.algorithms, err = negotiate(conn.stop(), config, hostKeyType); err == nil {
+		err = c.checkAlgorithms(client.algorithms)
+	} else if !c.StrictAlgorithms {
+		err = nil
+	}
+
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if c.IdleTimeout > 0 {
+		go client.watchIdle()
+	}
+
+	if c.KeepAliveInterval > 0 {
+		go client.keepAlive()
+	}
+
+	if c.OnDisconnect != nil {
+		go func() {
+			if err := client.Wait(); err != nil {
+				c.OnDisconnect(err.(*DisconnectError))
+			}
+		}()
+	}
+
+	return client, nil
+}
+
+// clientConfig returns a copy of ClientConfig with the config policies applied.
+func (c *Config) clientConfig() *ssh.ClientConfig {
+
+	config := *c.ClientConfig
+	config.Timeout = c.timeout()
+
+	if c.CertRenewer != nil {
+		config.Auth = append(c.CertRenewer.Auth(), config.Auth...)
+	}
+
+	if len(c.PinnedHostKeyAlgorithms) > 0 {
+		config.HostKeyAlgorithms = c.PinnedHostKeyAlgorithms
+	}
+
+	if config.HostKeyCallback != nil {
+		config.HostKeyCallback = c.policyCallback(config.HostKeyCallback)
+	}
+
+	return &config
+}
+
+// dialConn opens the network connection to addr, host names are resolved
+// with Hosts overrides first then the config Resolver.
+func (c *Config) dialConn(ctx context.Context, addr string) (net.Conn, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip, ok := c.Hosts[host]; ok {
+		addr = net.JoinHostPort(ip, port)
+	}
+
+	if c.Jump != nil {
+		return c.dialJump(ctx, addr)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:  c.timeout(),
+		Resolver: c.Resolver,
+	}
+
+	if len(c.Knock) > 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		if err = knock(dialer, host, c.Knock); err != nil {
+			return nil, err
+		}
+	}
+
+	return dialer.DialContext(ctx, c.Protocol, addr)
+}
+
+// dialJump connects to addr through the Jump host, the jump connection is
+// closed with the returned connection.
+func (c *Config) dialJump(ctx context.Context, addr string) (net.Conn, error) {
+
+	jump, err := NewClientContext(ctx, c.Jump)
+	if err != nil {
+		return nil, err
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := jump.Dial(c.Protocol, addr)
+		done <- dialResult{conn, err}
+	}()
+
+	var timeout <-chan time.Time
+	if t := c.timeout(); t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			jump.Close()
+			return nil, res.err
+		}
+		return &jumpConn{Conn: res.conn, jump: jump}, nil
+	case <-timeout:
+		// Closing the jump connection aborts the pending dial.
+		jump.Close()
+		return nil, wrapError(ErrConnTimeout, fmt.Errorf("dial %s through jump host", addr))
+	case <-ctx.Done():
+		jump.Close()
+		return nil, ctx.Err()
+	}
+}
+
+type jumpConn struct {
+	net.Conn
+	jump *Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.jump.Close()
+	return err
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// UploadDir uploads the localDir tree into remoteDir. Local symlinks are
+// recreated as remote symlinks unless WithFollowSymlinks is set, other
+// special files are skipped.
+func (c Client) UploadDir(localDir string, remoteDir string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	remoteDir = c.remotePath(ftp, remoteDir)
+
+	// Directory times change while their content is written, they are
+	// preserved once the walk is done.
+	var dirs []preservedDir
+
+	err = walkLocal(localDir, o.follow, func(local string, info os.FileInfo) error {
+
+		rel, err := filepath.Rel(localDir, local)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel != "." && !o.selected(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		remote := path.Join(remoteDir, rel)
+
+		switch {
+		case info.IsDir():
+			if o.mkdirs {
+				err = mkdirAll(ftp, remote, o.dirMode)
+			} else {
+				err = ftp.MkdirAll(remote)
+			}
+			if err == nil && o.preserve {
+				dirs = append(dirs, preservedDir{remote, info})
+			}
+			return err
+
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(local)
+			if err != nil {
+				return err
+			}
+			// Replace a previous upload, Symlink fails on existing paths.
+			ftp.Remove(remote)
+			return ftp.Symlink(filepath.ToSlash(target), remote)
+
+		case info.Mode().IsRegular():
+			f, err := os.Open(local)
+			if err != nil {
+				return fileError(err)
+			}
+			defer f.Close()
+
+			if err = uploadFile(ftp, f, remote, o); err != nil || !o.preserve {
+				return err
+			}
+			return preserveRemote(ftp, remote, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = preserveRemote(ftp, dirs[i].path, dirs[i].info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DownloadDir downloads the remoteDir tree into localDir. Remote symlinks
+// are recreated as local symlinks, with WithFollowSymlinks the targets of
+// file symlinks are downloaded instead. Other special files are skipped.
+func (c Client) DownloadDir(remoteDir string, localDir string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	remoteDir = c.remotePath(ftp, remoteDir)
+
+	var dirs []preservedDir
+
+	walker := ftp.Walk(remoteDir)
+	for walker.Step() {
+
+		if err = walker.Err(); err != nil {
+			return fileError(err)
+		}
+
+		remote, info := walker.Path(), walker.Stat()
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(remote, remoteDir), "/")
+		if rel != "" && !o.selected(rel, info.IsDir()) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		local := filepath.Join(localDir, filepath.FromSlash(rel))
+
+		if o.follow && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := ftp.Stat(remote); err == nil && target.Mode().IsRegular() {
+				info = target
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			if o.mkdirs {
+				err = mkdirAllLocal(local, o.dirMode)
+			} else {
+				err = os.MkdirAll(local, 0755)
+			}
+			if err == nil && o.preserve {
+				dirs = append(dirs, preservedDir{local, info})
+			}
+
+		case info.Mode()&os.ModeSymlink != 0:
+			var target string
+			if target, err = ftp.ReadLink(remote); err == nil {
+				os.Remove(local)
+				err = os.Symlink(filepath.FromSlash(target), local)
+			}
+
+		case info.Mode().IsRegular():
+			if err = downloadFile(ftp, remote, local, o); err == nil && o.preserve {
+				err = preserveLocal(local, info)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = preserveLocal(dirs[i].path, dirs[i].info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preservedDir is a directory whose mode and times are applied last.
+type preservedDir struct {
+	path string
+	info os.FileInfo
+}
+
+func preserveRemote(ftp *sftp.Client, remote string, info os.FileInfo) error {
+
+	if err := ftp.Chmod(remote, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return ftp.Chtimes(remote, info.ModTime(), info.ModTime())
+}
+
+func preserveLocal(local string, info os.FileInfo) error {
+
+	if err := os.Chmod(local, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(local, info.ModTime(), info.ModTime())
+}
+
+// walkLocal calls fn for root and the files of its tree, parents first, fn
+// returns filepath.SkipDir to skip a directory. With follow, symlinks are
+// walked as their targets and a symlink to one of its parent directories is
+// an error.
+func walkLocal(root string, follow bool, fn func(path string, info os.FileInfo) error) error {
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return fileError(err)
+	}
+
+	if err = walkLocalDir(root, info, follow, nil, fn); err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+func walkLocalDir(dir string, info os.FileInfo, follow bool, parents []os.FileInfo, fn func(string, os.FileInfo) error) error {
+
+	if err := fn(dir, info); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	parents = append(parents, info)
+
+	for _, entry := range entries {
+
+		name := filepath.Join(dir, entry.Name())
+
+		if follow && entry.Mode()&os.ModeSymlink != 0 {
+			if entry, err = os.Stat(name); err != nil {
+				return err
+			}
+		}
+
+		if !entry.IsDir() {
+			if err = fn(name, entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, parent := range parents {
+			if os.SameFile(parent, entry) {
+				return fmt.Errorf("symlink cycle: %s", name)
+			}
+		}
+
+		if err = walkLocalDir(name, entry, follow, parents, fn); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DisconnectReason classifies why a connection ended.
+type DisconnectReason int
+
+const (
+	// DisconnectUnknown is an unclassified disconnect.
+	DisconnectUnknown DisconnectReason = iota
+
+	// DisconnectIdleTimeout is a server disconnect of an idle connection.
+	DisconnectIdleTimeout
+
+	// DisconnectServerShutdown is any other server disconnect message,
+	// usually sent when the server shuts down or restarts.
+	DisconnectServerShutdown
+
+	// DisconnectNetworkReset is a connection reset, closed or timed out by
+	// the network.
+	DisconnectNetworkReset
+
+	// DisconnectKeepalive is a peer that stopped answering keepalives.
+	DisconnectKeepalive
+)
+
+// ErrKeepAliveTimeout is the error of connections closed by keepalives.
+var ErrKeepAliveTimeout = errors.New("keepalive timeout")
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectIdleTimeout:
+		return "idle timeout"
+	case DisconnectServerShutdown:
+		return "server shutdown"
+	case DisconnectNetworkReset:
+		return "network reset"
+	case DisconnectKeepalive:
+		return "keepalive failure"
+	}
+	return "unknown"
+}
+
+// DisconnectError is the reason a connection ended, returned by Client.Wait.
+type DisconnectError struct {
+	// Reason is the disconnect classification.
+	Reason DisconnectReason
+
+	// Code is the server SSH_MSG_DISCONNECT reason code, 0 if none was sent.
+	Code uint32
+
+	// Message is the server disconnect message.
+	Message string
+
+	// Err is the underlying network error, nil on server disconnect.
+	Err error
+}
+
+func (e *DisconnectError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("server disconnect, reason %d: %s", e.Code, e.Message)
+	}
+
+	return e.Reason.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the network error.
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
+
+// Wait blocks until the connection closes, it returns nil after Close
+// otherwise a *DisconnectError with the cause.
+func (c Client) Wait() error {
+
+	err := c.Client.Wait()
+	if c.closed != nil && atomic.LoadInt32(c.closed) == 1 {
+		return nil
+	}
+
+	if c.deadPeer != nil && atomic.LoadInt32(c.deadPeer) == 1 {
+		return &DisconnectError{Reason: DisconnectKeepalive, Err: ErrKeepAliveTimeout}
+	}
+
+	return disconnectError(err)
+}
+
+// keepAlive sends keepalives until the connection closes, it closes the
+// connection after KeepAliveCountMax unanswered ones.
+func (c Client) keepAlive() {
+
+	interval, max := c.Config.KeepAliveInterval, c.Config.KeepAliveCountMax
+	if max <= 0 {
+		max = 3
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for missed := 0; ; {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if c.ping(interval) {
+			missed = 0
+			continue
+		}
+
+		if missed++; missed >= max {
+			c.Config.logf("keepalive: no answer after %d requests, closing", missed)
+			atomic.StoreInt32(c.deadPeer, 1)
+			c.Client.Close()
+			return
+		}
+	}
+}
+
+// ping reports whether a keepalive got an answer within timeout, servers
+// usually answer with a failure that still proves they're alive.
+func (c Client) ping(timeout time.Duration) bool {
+
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-timer.C:
+		return false
+	}
+}
+
+// disconnectError parses the mux error, x/crypto keeps the disconnect
+// message type unexported so it's recognized by its error string.
+func disconnectError(err error) *DisconnectError {
+
+	e := &DisconnectError{}
+	if _, scanErr := fmt.Sscanf(err.Error(), "ssh: disconnect, reason %d: ", &e.Code); scanErr == nil {
+		e.Message = err.Error()[len(fmt.Sprintf("ssh: disconnect, reason %d: ", e.Code)):]
+		e.Reason = DisconnectServerShutdown
+
+		msg := strings.ToLower(e.Message)
+		if strings.Contains(msg, "idle") || strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out") {
+			e.Reason = DisconnectIdleTimeout
+		}
+
+		return e
+	}
+
+	e.Err = err
+
+	var netErr net.Error
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, ErrInjectedDisconnect) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		e.Reason = DisconnectNetworkReset
+	}
+
+	return e
+}
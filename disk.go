@@ -0,0 +1,110 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+var errStatVFSUnsupported = errors.New("statvfs unsupported")
+
+// DiskUsage is the space of the filesystem holding a remote path, in bytes.
+type DiskUsage struct {
+	Total     uint64
+	Used      uint64
+	Available uint64
+}
+
+// DiskUsage returns the space of the filesystem holding remotePath, with the
+// statvfs@openssh.com sftp extension or by parsing df when it's missing.
+func (c Client) DiskUsage(remotePath string) (usage DiskUsage, err error) {
+
+	err = c.withSftp(context.Background(), func(ftp *sftp.Client) error {
+
+		remotePath = c.remotePath(ftp, remotePath)
+		if _, ok := ftp.HasExtension("statvfs@openssh.com"); !ok {
+			return errStatVFSUnsupported
+		}
+
+		vfs, err := ftp.StatVFS(remotePath)
+		if err != nil {
+			return err
+		}
+
+		usage = DiskUsage{
+			Total:     vfs.TotalSpace(),
+			Used:      (vfs.Blocks - vfs.Bfree) * vfs.Frsize,
+			Available: vfs.Bavail * vfs.Frsize,
+		}
+		return nil
+	})
+
+	if err == errStatVFSUnsupported {
+		return c.df(remotePath)
+	}
+
+	return usage, err
+}
+
+// df parses the POSIX output of df -Pk for remotePath.
+func (c Client) df(remotePath string) (DiskUsage, error) {
+
+	out, err := c.Run("df -Pk " + c.Config.shell().Quote(remotePath))
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("df: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+	// Total, used and available precede the capacity percentage, the
+	// filesystem and mount point names may contain spaces.
+	fields := strings.Fields(lines[len(lines)-1])
+	capacity := -1
+	for i := len(fields) - 1; i >= 3; i-- {
+		if strings.HasSuffix(fields[i], "%") {
+			capacity = i
+			break
+		}
+	}
+
+	if len(lines) < 2 || capacity < 0 {
+		return DiskUsage{}, fmt.Errorf("df: unexpected output %q", out)
+	}
+
+	var kb [3]uint64
+	for i, f := range fields[capacity-3 : capacity] {
+		if kb[i], err = strconv.ParseUint(f, 10, 64); err != nil {
+			return DiskUsage{}, fmt.Errorf("df: unexpected output %q", out)
+		}
+	}
+
+	return DiskUsage{Total: kb[0] << 10, Used: kb[1] << 10, Available: kb[2] << 10}, nil
+}
+
+// DirSize returns the total size of the regular files below remoteDir,
+// symlinks are not followed.
+func (c Client) DirSize(remoteDir string) (size int64, err error) {
+
+	err = c.withSftp(context.Background(), func(ftp *sftp.Client) error {
+
+		walker := ftp.Walk(c.remotePath(ftp, remoteDir))
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return err
+			}
+			if walker.Stat().Mode().IsRegular() {
+				size += walker.Stat().Size()
+			}
+		}
+		return nil
+	})
+
+	return size, err
+}
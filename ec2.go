@@ -0,0 +1,77 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// InstanceConnectPusher pushes a one time public key of osUser to an EC2
+// instance, the EC2 Instance Connect service keeps it for 60 seconds.
+type InstanceConnectPusher interface {
+	SendSSHPublicKey(ctx context.Context, instanceID string, osUser string, publicKey string) error
+}
+
+// InstanceConnectFunc adapts a function to an InstanceConnectPusher.
+type InstanceConnectFunc func(ctx context.Context, instanceID string, osUser string, publicKey string) error
+
+// SendSSHPublicKey calls f.
+func (f InstanceConnectFunc) SendSSHPublicKey(ctx context.Context, instanceID string, osUser string, publicKey string) error {
+	return f(ctx, instanceID, osUser, publicKey)
+}
+
+// AWSInstanceConnect pushes keys with the aws cli.
+var AWSInstanceConnect InstanceConnectPusher = InstanceConnectFunc(func(ctx context.Context, instanceID string, osUser string, publicKey string) error {
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "aws", "ec2-instance-connect", "send-ssh-public-key",
+		"--instance-id", instanceID, "--instance-os-user", osUser, "--ssh-public-key", publicKey)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New("aws: " + msg)
+		}
+		return err
+	}
+
+	return nil
+})
+
+// EphemeralKey generates a new ed25519 key kept in memory only.
+func EphemeralKey() (ssh.Signer, error) {
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+// InstanceConnect pushes an ephemeral key of the config user to instanceID
+// and connects with it, the config itself is left untouched.
+func InstanceConnect(ctx context.Context, pusher InstanceConnectPusher, instanceID string, config *Config) (*Client, error) {
+
+	signer, err := EphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if err = pusher.SendSSHPublicKey(ctx, instanceID, config.ClientConfig.User, publicKey); err != nil {
+		return nil, err
+	}
+
+	return NewClientContext(ctx, config.clone([]Option{WithAuth(Auth{ssh.PublicKeys(signer)})}))
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// EncodingAuto detects UTF-16 output from its byte order mark.
+const EncodingAuto = "auto"
+
+// Transcode converts b from charset to UTF-8. The charset is a WHATWG
+// encoding label like "gbk", "shift_jis" or "utf-16le", or EncodingAuto.
+func Transcode(b []byte, charset string) ([]byte, error) {
+
+	if charset == "" || len(b) == 0 {
+		return b, nil
+	}
+
+	var (
+		err error
+		enc encoding.Encoding
+	)
+
+	if charset == EncodingAuto {
+		switch {
+		case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+			enc = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+		case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+			enc = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+		default:
+			// Without bom only valid UTF-8 can be trusted, anything else is kept as is.
+			if utf8.Valid(b) {
+				return bytes.TrimPrefix(b, []byte{0xef, 0xbb, 0xbf}), nil
+			}
+			return b, nil
+		}
+	} else if enc, err = htmlindex.Get(charset); err != nil {
+		return nil, err
+	}
+
+	return enc.NewDecoder().Bytes(b)
+}
+
+// checkEncoding returns an error when charset is not a known encoding label.
+func checkEncoding(charset string) error {
+
+	if charset == "" || charset == EncodingAuto {
+		return nil
+	}
+
+	_, err := htmlindex.Get(charset)
+	return err
+}
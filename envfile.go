@@ -0,0 +1,87 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	envNameRegexp   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envSecretRegexp = regexp.MustCompile(`(?i)(pass|secret|token|key|credential)`)
+	envEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+)
+
+// RenderEnvFile renders env as sorted KEY="value" lines, values are double
+// quoted and escaped so the file can be sourced by sh or used as a systemd
+// EnvironmentFile. Newlines are kept inside the quotes.
+func RenderEnvFile(env map[string]string) ([]byte, error) {
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		if !envNameRegexp.MatchString(key) {
+			return nil, fmt.Errorf("invalid env var name %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=\"%s\"\n", key, envEscaper.Replace(env[key]))
+	}
+
+	return []byte(b.String()), nil
+}
+
+// WriteEnvFile renders env and atomically writes it to remotePath with mode.
+// Values of names looking like secrets are redacted in logs.
+func (c Client) WriteEnvFile(remotePath string, env map[string]string, mode os.FileMode) error {
+
+	data, err := RenderEnvFile(env)
+	if err != nil {
+		return err
+	}
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	remotePath = c.remotePath(ftp, remotePath)
+
+	if err = writeRemoteFileMode(ftp, remotePath, data, mode); err != nil {
+		return err
+	}
+
+	c.Config.logf("wrote env file %s: %s", remotePath, redactEnv(env))
+
+	return nil
+}
+
+// redactEnv returns the env names and values with secret values masked.
+func redactEnv(env map[string]string) string {
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		value := env[key]
+		if envSecretRegexp.MatchString(key) {
+			value = "***"
+		}
+		pairs[i] = key + "=" + value
+	}
+
+	return strings.Join(pairs, " ")
+}
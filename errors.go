@@ -0,0 +1,184 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// ErrAuthFailed is returned when the server rejected all auth methods.
+	ErrAuthFailed = errors.New("auth failed")
+
+	// ErrHostKeyMismatch is returned when the host is known with another key.
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+
+	// ErrConnTimeout is returned when the connection timed out.
+	ErrConnTimeout = errors.New("connection timeout")
+
+	// ErrSessionLimit is returned when the server refused to open more channels.
+	ErrSessionLimit = errors.New("session limit reached")
+
+	// ErrFileNotFound is returned when a local or remote file doesn't exist.
+	ErrFileNotFound = errors.New("file not found")
+
+	// ErrInvalidConfig is returned by Config.Validate.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Error wraps an error with one of the package sentinel errors, so both
+// errors.Is(err, goph.ErrX) and errors.As on the original error work.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error kind.
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func wrapError(kind error, err error) error {
+
+	var e *Error
+	if errors.As(err, &e) && e.Kind == kind {
+		return err
+	}
+
+	return &Error{Kind: kind, Err: err}
+}
+
+// AddrError is the error of a connection to Addr.
+type AddrError struct {
+	Addr string
+	Err  error
+}
+
+func (e *AddrError) Error() string {
+	return e.Addr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the connection error.
+func (e *AddrError) Unwrap() error {
+	return e.Err
+}
+
+// FailoverError is returned by NewClient when none of the config addresses
+// could be reached, it holds the error of every address in dial order.
+type FailoverError struct {
+	Errors []*AddrError
+}
+
+func (e *FailoverError) Error() string {
+
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return "all addresses failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error of the last address.
+func (e *FailoverError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[len(e.Errors)-1]
+}
+
+// Is reports whether the error of any address matches target.
+func (e *FailoverError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns the error of a single address as is.
+func (e *FailoverError) err() error {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Err
+	}
+	return e
+}
+
+// isNetworkError reports whether err comes from the network rather than
+// the server, connecting to another address may then succeed.
+func isNetworkError(err error) bool {
+
+	if errors.Is(err, ErrConnTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// dialError classifies connection errors, hostKeyErr is the error returned
+// by the host key callback if any, the handshake error loses its type.
+func dialError(err error, hostKeyErr error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if hostKeyErr != nil {
+		if errors.As(hostKeyErr, &keyErr) && len(keyErr.Want) > 0 {
+			return wrapError(ErrHostKeyMismatch, hostKeyErr)
+		}
+		return hostKeyErr
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return wrapError(ErrConnTimeout, err)
+	}
+
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return wrapError(ErrAuthFailed, err)
+	}
+
+	return err
+}
+
+// channelError classifies channel open errors.
+func channelError(err error) error {
+
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) && (openErr.Reason == ssh.ResourceShortage || openErr.Reason == ssh.Prohibited) {
+		return wrapError(ErrSessionLimit, err)
+	}
+
+	return err
+}
+
+// fileError classifies file errors.
+func fileError(err error) error {
+
+	if err != nil && os.IsNotExist(err) {
+		return wrapError(ErrFileNotFound, err)
+	}
+
+	return err
+}
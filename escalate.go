@@ -0,0 +1,81 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// permissionErrors are lowercase output fragments of permission failures.
+var permissionErrors = [][]byte{
+	[]byte("permission denied"),
+	[]byte("operation not permitted"),
+	[]byte("access denied"),
+	[]byte("must be root"),
+	[]byte("are you root"),
+	[]byte("must be superuser"),
+	[]byte("requires superuser"),
+	[]byte("only root can"),
+}
+
+// EscalateResult is the result of RunEscalate.
+type EscalateResult struct {
+
+	// Output is the combined output of the last run.
+	Output []byte
+
+	// Escalated reports whether the command was retried with Config.Escalate.
+	Escalated bool
+
+	// Denied is the combined output of the unprivileged run when escalated.
+	Denied []byte
+}
+
+// RunEscalate runs cmd and, when it fails with a permission error, retries
+// it through sh -c under the Config.Escalate prefix, or with Config.Sudo
+// when there's no prefix. Without both it's not retried. The result tells
+// whether the command was escalated, the error is the one of the last run.
+func (c Client) RunEscalate(ctx context.Context, cmd string) (*EscalateResult, error) {
+
+	out, err := c.RunContext(ctx, cmd)
+	if err == nil || c.Config == nil || (c.Config.Escalate == "" && c.Config.Sudo == nil) || !IsPermissionError(out, err) {
+		return &EscalateResult{Output: out}, err
+	}
+
+	res := &EscalateResult{Escalated: true, Denied: out}
+	if c.Config.Escalate != "" {
+		res.Output, err = c.RunContext(ctx, c.Config.Escalate+" "+ShellSh.Wrap(cmd))
+	} else {
+		res.Output, err = c.RunSudo(ctx, cmd)
+	}
+
+	return res, err
+}
+
+// IsPermissionError reports whether a failed command with output was denied
+// by a lack of privileges: exit code 126 or a permission error message.
+func IsPermissionError(output []byte, err error) bool {
+
+	var exit *ssh.ExitError
+	if !errors.As(err, &exit) {
+		return false
+	}
+
+	if exit.ExitStatus() == 126 {
+		return true
+	}
+
+	output = bytes.ToLower(output)
+	for _, msg := range permissionErrors {
+		if bytes.Contains(output, msg) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -10,14 +10,13 @@ import (
 	"net"
 	"os"
 	osuser "os/user"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/ahmet2mir/goph"
+	"github.com/ahmet2mir/goph/internal/cli"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 //
@@ -39,19 +38,16 @@ import (
 //
 
 var (
-	err        error
-	auth       goph.Auth
-	client     *goph.Client
-	addr       string
-	user       string
-	port       uint
-	key        string
-	cmd        string
-	pass       bool
-	passphrase bool
-	timeout    time.Duration
-	agent      bool
-	sftpc      *sftp.Client
+	err       error
+	auth      goph.Auth
+	authFlags cli.AuthFlags
+	client    *goph.Client
+	addr      string
+	user      string
+	port      uint
+	cmd       string
+	timeout   time.Duration
+	sftpc     *sftp.Client
 )
 
 func init() {
@@ -64,11 +60,8 @@ func init() {
 	flag.StringVar(&addr, "ip", "127.0.0.1", "machine ip address.")
 	flag.StringVar(&user, "user", usr.Username, "ssh user.")
 	flag.UintVar(&port, "port", 22, "ssh port number.")
-	flag.StringVar(&key, "key", filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"), "private key path.")
 	flag.StringVar(&cmd, "cmd", "", "command to run.")
-	flag.BoolVar(&pass, "pass", false, "ask for ssh password instead of private key.")
-	flag.BoolVar(&agent, "agent", false, "use ssh agent for authentication (unix systems only).")
-	flag.BoolVar(&passphrase, "passphrase", false, "ask for private key passphrase.")
+	authFlags.Register(flag.CommandLine)
 	flag.DurationVar(&timeout, "timeout", 0, "interrupt a command with SIGINT after a given timeout (0 means no timeout)")
 }
 
@@ -114,20 +107,7 @@ func main() {
 
 	var err error
 
-	if agent || goph.HasAgent() {
-
-		auth, err = goph.UseAgent()
-
-	} else if pass {
-
-		auth = goph.Password(askPass("Enter SSH Password: "))
-
-	} else {
-
-		auth, err = goph.Key(key, getPassphrase(passphrase))
-	}
-
-	if err != nil {
+	if auth, err = authFlags.Auth(); err != nil {
 		panic(err)
 	}
 
@@ -166,31 +146,6 @@ func main() {
 	playWithSSHJustForTestingThisProgram(client)
 }
 
-func askPass(msg string) string {
-
-	fmt.Print(msg)
-
-	pass, err := terminal.ReadPassword(0)
-
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Println("")
-
-	return strings.TrimSpace(string(pass))
-}
-
-func getPassphrase(ask bool) string {
-
-	if ask {
-
-		return askPass("Enter Private Key Passphrase: ")
-	}
-
-	return ""
-}
-
 func askIsHostTrusted(host string, key ssh.PublicKey) bool {
 
 	reader := bufio.NewReader(os.Stdin)
@@ -303,6 +258,9 @@ loop:
 			if err != nil {
 				panic(err)
 			}
+
+			// The typed line is a shell command line.
+			command.Raw = true
 			out, err = command.CombinedOutput()
 			fmt.Println(string(out), err)
 		}
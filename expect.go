@@ -0,0 +1,175 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrExpectTimeout is returned by Expect when the output didn't match in time.
+var ErrExpectTimeout = errors.New("expect timeout")
+
+// maxExpectBuffer is the unmatched output kept by an ExpectSession, older
+// output is dropped.
+const maxExpectBuffer = 1 << 20
+
+// ExpectSession is a command on a PTY scripted by expecting its output and
+// sending its input, like answering prompts or driving a device CLI.
+type ExpectSession struct {
+	*ssh.Session
+
+	stdin  io.WriteCloser
+	output *expectOutput
+	done   chan struct{}
+	err    error
+}
+
+// expectOutput buffers the output, notify is closed on writes.
+type expectOutput struct {
+	mu     sync.Mutex
+	buf    []byte
+	notify chan struct{}
+}
+
+// Interact starts cmd, or a login shell when cmd is empty, on a PTY. The
+// PTY is an 80x24 xterm with echo off unless set by WithPty, WithTerm and
+// WithTerminalModes, the other options don't apply. Stdout and stderr are
+// both expected.
+func (c Client) Interact(cmd string, opts ...ShellOption) (*ExpectSession, error) {
+
+	o := &shellOptions{term: "xterm", width: 80, height: 24, modes: ssh.TerminalModes{ssh.ECHO: 0}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ExpectSession{
+		Session: sess,
+		output:  &expectOutput{notify: make(chan struct{})},
+		done:    make(chan struct{}),
+	}
+	sess.Stdout, sess.Stderr = e.output, e.output
+
+	if e.stdin, err = sess.StdinPipe(); err == nil {
+		if err = sess.RequestPty(o.term, o.height, o.width, o.modes); err == nil {
+			if cmd == "" {
+				err = sess.Shell()
+			} else {
+				err = sess.Start(cmd)
+			}
+		}
+	}
+
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	go func() {
+		e.err = exitError(sess.Wait(), nil)
+		close(e.done)
+	}()
+
+	return e, nil
+}
+
+func (o *expectOutput) Write(p []byte) (int, error) {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.buf = append(o.buf, p...); len(o.buf) > maxExpectBuffer {
+		o.buf = o.buf[len(o.buf)-maxExpectBuffer:]
+	}
+
+	close(o.notify)
+	o.notify = make(chan struct{})
+
+	return len(p), nil
+}
+
+// Expect waits up to timeout for the output to match the pattern regexp,
+// it returns the output up to the end of the match and consumes it. Unmatched
+// output is kept for the next Expect. It returns io.EOF when the command
+// exited without the output matching.
+func (e *ExpectSession) Expect(pattern string, timeout time.Duration) (string, error) {
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for exited := false; ; {
+
+		o := e.output
+		o.mu.Lock()
+		if loc := re.FindIndex(o.buf); loc != nil {
+			out := string(o.buf[:loc[1]])
+			o.buf = o.buf[loc[1]:]
+			o.mu.Unlock()
+			return out, nil
+		}
+		notify := o.notify
+		o.mu.Unlock()
+
+		if exited {
+			return "", io.EOF
+		}
+
+		select {
+		case <-notify:
+		case <-e.done:
+			exited = true
+		case <-timer.C:
+			return "", fmt.Errorf("%w: %q", ErrExpectTimeout, pattern)
+		}
+	}
+}
+
+// Send writes text to the command input, include the line ending like
+// "yes\n" to answer a prompt.
+func (e *ExpectSession) Send(text string) error {
+	_, err := io.WriteString(e.stdin, text)
+	return err
+}
+
+// Output returns the output not consumed by Expect yet.
+func (e *ExpectSession) Output() string {
+	e.output.mu.Lock()
+	defer e.output.mu.Unlock()
+	return string(e.output.buf)
+}
+
+// Wait closes the command input and waits for it to exit, a non zero exit
+// status returns an *ExitError.
+func (e *ExpectSession) Wait() error {
+	e.stdin.Close()
+	<-e.done
+	return e.err
+}
+
+// Close ends the command and its session.
+func (e *ExpectSession) Close() error {
+
+	err := e.Session.Close()
+	if err == io.EOF {
+		err = nil
+	}
+
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"strings"
+)
+
+// factsScript prints the facts as key=value lines, the package managers are
+// probed in order so dnf wins over its yum alias.
+const factsScript = `. /etc/os-release 2>/dev/null
+echo "os=$(uname -s)"
+echo "arch=$(uname -m)"
+echo "distro=$ID"
+echo "version=$VERSION_ID"
+echo "like=$ID_LIKE"
+for pm in apt-get dnf yum apk zypper; do
+	if command -v $pm >/dev/null 2>&1; then echo "pm=$pm"; break; fi
+done`
+
+// Facts describes a POSIX remote host.
+type Facts struct {
+
+	// OS and Arch are GOOS and GOARCH like, e.g. linux and amd64.
+	OS   string
+	Arch string
+
+	// Distro is the os-release ID, e.g. debian, and DistroVersion its VERSION_ID.
+	Distro        string
+	DistroVersion string
+
+	// DistroLike are the os-release ID_LIKE distros.
+	DistroLike []string
+
+	// PackageManager is apt-get, dnf, yum, apk or zypper, empty if none.
+	PackageManager string
+}
+
+// Facts gathers the remote host facts with a single command.
+func (c Client) Facts(ctx context.Context) (*Facts, error) {
+
+	out, err := c.RunContext(ctx, ShellSh.Wrap(factsScript))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Facts{}
+	for _, line := range strings.Split(string(out), "\n") {
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+
+		value := strings.TrimSpace(line[i+1:])
+		switch line[:i] {
+		case "os":
+			f.OS = strings.ToLower(value)
+		case "arch":
+			if f.Arch = unameArch[value]; f.Arch == "" {
+				f.Arch = value
+			}
+		case "distro":
+			f.Distro = value
+		case "version":
+			f.DistroVersion = value
+		case "like":
+			f.DistroLike = strings.Fields(value)
+		case "pm":
+			f.PackageManager = value
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjectedDisconnect is the error of connections closed by Faults.DisconnectAfter.
+var ErrInjectedDisconnect = errors.New("injected disconnect")
+
+// Faults injects artificial failures into a client, to test application
+// retry and reconnect logic. Never set it in production.
+type Faults struct {
+
+	// Latency added to every read and write of the connection.
+	Latency time.Duration
+
+	// DisconnectAfter closes the connection once this many bytes were read
+	// and written, 0 disables it.
+	DisconnectAfter int64
+
+	// ChannelOpen is called before opening session and sftp channels,
+	// a non nil error fails the open.
+	ChannelOpen func(channelType string) error
+}
+
+func (c Client) faults() *Faults {
+
+	if c.Config == nil {
+		return nil
+	}
+
+	return c.Config.Faults
+}
+
+func (f *Faults) channelOpen(channelType string) error {
+
+	if f == nil || f.ChannelOpen == nil {
+		return nil
+	}
+
+	return f.ChannelOpen(channelType)
+}
+
+// wrap returns conn with the faults applied.
+func (f *Faults) wrap(conn net.Conn) net.Conn {
+
+	if f == nil || (f.Latency == 0 && f.DisconnectAfter == 0) {
+		return conn
+	}
+
+	return &faultConn{Conn: conn, faults: f}
+}
+
+type faultConn struct {
+	net.Conn
+	faults *Faults
+	bytes  int64
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+
+	if err := c.before(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.bytes, int64(n))
+
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+
+	if err := c.before(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.bytes, int64(n))
+
+	return n, err
+}
+
+func (c *faultConn) before() error {
+
+	if c.faults.Latency > 0 {
+		time.Sleep(c.faults.Latency)
+	}
+
+	if c.faults.DisconnectAfter > 0 && atomic.LoadInt64(&c.bytes) >= c.faults.DisconnectAfter {
+		c.Conn.Close()
+		return ErrInjectedDisconnect
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// EditOption configures EditFile.
+type EditOption func(*editOptions)
+
+type editOptions struct {
+	backupSuffix string
+}
+
+// WithBackup keeps a copy of the original file in remotePath+suffix.
+func WithBackup(suffix string) EditOption {
+	return func(o *editOptions) {
+		o.backupSuffix = suffix
+	}
+}
+
+// EditFile downloads remotePath, applies edit on its content and replaces the
+// file with the result, the file mode and owner are preserved. The replacement
+// is atomic when the server supports posix-rename@openssh.com.
+// Nothing is written when the content did not change. When the file can't be
+// read or replaced and Config.Sudo is set, it's edited in place with cat
+// under the escalation, that isn't atomic.
+func (c Client) EditFile(remotePath string, edit func([]byte) ([]byte, error), opts ...EditOption) error {
+	return c.EditFileContext(context.Background(), remotePath, edit, opts...)
+}
+
+// EditFileContext is like EditFile but aborts when ctx is done.
+func (c Client) EditFileContext(ctx context.Context, remotePath string, edit func([]byte) ([]byte, error), opts ...EditOption) error {
+
+	var o editOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return c.withSftp(ctx, func(ftp *sftp.Client) error {
+
+		remotePath := c.remotePath(ftp, remotePath)
+
+		info, err := ftp.Stat(remotePath)
+		if err != nil {
+			return fileError(err)
+		}
+
+		data, err := readRemoteFile(ftp, remotePath)
+		if permissionDenied(err) && c.Config != nil && c.Config.Sudo != nil {
+			return c.sudoEdit(ctx, remotePath, edit, o)
+		}
+		if err != nil {
+			return err
+		}
+
+		edited, err := edit(data)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(data, edited) {
+			return nil
+		}
+
+		if o.backupSuffix != "" {
+			err = writeRemoteFile(ftp, remotePath+o.backupSuffix, data, info)
+			if err == nil {
+				err = writeRemoteFile(ftp, remotePath, edited, info)
+			}
+		} else {
+			err = writeRemoteFile(ftp, remotePath, edited, info)
+		}
+
+		if permissionDenied(err) && c.Config != nil && c.Config.Sudo != nil {
+			return c.sudoWrite(ctx, remotePath, edited, o)
+		}
+
+		return err
+	})
+}
+
+// sudoEdit edits remotePath with commands run under Config.Sudo.
+func (c Client) sudoEdit(ctx context.Context, remotePath string, edit func([]byte) ([]byte, error), o editOptions) error {
+
+	data, err := c.sudoOutput(ctx, "cat "+ShellSh.Quote(remotePath), nil)
+	if err != nil {
+		return err
+	}
+
+	edited, err := edit(data)
+	if err != nil || bytes.Equal(data, edited) {
+		return err
+	}
+
+	return c.sudoWrite(ctx, remotePath, edited, o)
+}
+
+// sudoWrite truncates and writes remotePath under Config.Sudo, its mode and
+// owner are kept, the backup is a copy of the current file.
+func (c Client) sudoWrite(ctx context.Context, remotePath string, data []byte, o editOptions) error {
+
+	cmd := "cat > " + ShellSh.Quote(remotePath)
+	if o.backupSuffix != "" {
+		cmd = "cp -p " + ShellSh.Quote(remotePath) + " " + ShellSh.Quote(remotePath+o.backupSuffix) + " && " + cmd
+	}
+
+	_, err := c.sudoOutput(ctx, cmd, bytes.NewReader(data))
+
+	return err
+}
+
+// permissionDenied reports whether an sftp operation was denied.
+func permissionDenied(err error) bool {
+
+	var status *sftp.StatusError
+	if errors.As(err, &status) {
+		return status.FxCode() == sftp.ErrSSHFxPermissionDenied
+	}
+
+	return errors.Is(err, os.ErrPermission)
+}
+
+func readRemoteFile(ftp *sftp.Client, remotePath string) ([]byte, error) {
+
+	f, err := ftp.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// writeRemoteFile writes data to a temp file next to remotePath then renames
+// it over remotePath, mode and owner are copied from info when not nil. The
+// mode is set before writing, 0600 without info, so the data is never
+// readable by others in the temp file.
+func writeRemoteFile(ftp *sftp.Client, remotePath string, data []byte, info os.FileInfo) (err error) {
+
+	tmp, err := tempName(remotePath)
+	if err != nil {
+		return err
+	}
+
+	f, err := ftp.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			ftp.Remove(tmp)
+		}
+	}()
+
+	mode := os.FileMode(0600)
+	if info != nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if info != nil {
+		if stat, ok := info.Sys().(*sftp.FileStat); ok {
+			// Only root can give files away, a failure keeps the current user as owner.
+			_ = ftp.Chown(tmp, int(stat.UID), int(stat.GID))
+		}
+	}
+
+	return rename(ftp, tmp, remotePath)
+}
+
+// Rename renames a remote file, replacing newname if it exists.
+// See EditFile notes about atomicity.
+func (c Client) Rename(oldname, newname string) error {
+	return c.RenameContext(context.Background(), oldname, newname)
+}
+
+// RenameContext is like Rename but aborts when ctx is done.
+func (c Client) RenameContext(ctx context.Context, oldname, newname string) error {
+	return c.withSftp(ctx, func(ftp *sftp.Client) error {
+		return rename(ftp, c.remotePath(ftp, oldname), c.remotePath(ftp, newname))
+	})
+}
+
+// MkdirAll creates remotePath and its missing parents with mode, regardless
+// of the sftp server umask. Existing directories are left untouched.
+func (c Client) MkdirAll(remotePath string, mode os.FileMode) error {
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	return mkdirAll(ftp, c.remotePath(ftp, remotePath), mode)
+}
+
+func mkdirAll(ftp *sftp.Client, dir string, mode os.FileMode) error {
+
+	info, err := ftp.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if parent := path.Dir(dir); parent != dir {
+		if err = mkdirAll(ftp, parent, mode); err != nil {
+			return err
+		}
+	}
+
+	if err = ftp.Mkdir(dir); err != nil {
+		return err
+	}
+
+	// The server applies its own umask to mkdir, set the mode explicitly.
+	return ftp.Chmod(dir, mode)
+}
+
+// writeRemoteFileMode is like writeRemoteFile but sets the file mode.
+func writeRemoteFileMode(ftp *sftp.Client, remotePath string, data []byte, mode os.FileMode) error {
+	return writeRemoteFile(ftp, remotePath, data, fileMode(mode))
+}
+
+// fileMode is an os.FileInfo only holding a mode.
+type fileMode os.FileMode
+
+func (m fileMode) Name() string       { return "" }
+func (m fileMode) Size() int64        { return 0 }
+func (m fileMode) Mode() os.FileMode  { return os.FileMode(m) }
+func (m fileMode) ModTime() time.Time { return time.Time{} }
+func (m fileMode) IsDir() bool        { return false }
+func (m fileMode) Sys() interface{}   { return nil }
+
+// rename moves oldname to newname, replacing newname if it exists. It uses
+// the posix-rename@openssh.com extension when the server advertises it, so
+// the replacement is atomic. Otherwise it falls back to removing newname then
+// renaming, which leaves a short window where newname does not exist.
+func rename(ftp *sftp.Client, oldname, newname string) error {
+
+	if _, ok := ftp.HasExtension("posix-rename@openssh.com"); ok {
+		return ftp.PosixRename(oldname, newname)
+	}
+
+	if _, err := ftp.Lstat(newname); err == nil {
+		if err = ftp.Remove(newname); err != nil {
+			return err
+		}
+	}
+
+	return ftp.Rename(oldname, newname)
+}
+
+// tempName returns a random hidden file name in the directory of p.
+func tempName(p string) (string, error) {
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	dir, name := path.Split(p)
+	return path.Join(dir, "."+name+".goph-"+hex.EncodeToString(b)), nil
+}
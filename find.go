@@ -0,0 +1,200 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// GrepMaxLineLength is the longest line Grep matches, longer lines are
+// skipped.
+var GrepMaxLineLength = 1024 * 1024
+
+// FindOptions filters Find results.
+type FindOptions struct {
+
+	// Only match regular files.
+	FilesOnly bool
+
+	// Only match directories.
+	DirsOnly bool
+
+	// Max depth below root, 0 means unlimited.
+	MaxDepth int
+}
+
+// FindResult is a path found by Find.
+type FindResult struct {
+	Path string
+	Info os.FileInfo
+}
+
+// GrepMatch is a line matched by Grep.
+type GrepMatch struct {
+	Path string
+	Line int
+	Text string
+}
+
+// Find walks root on the remote host and returns the paths whose base name
+// matches the shell pattern, see path.Match for the pattern syntax. Matching
+// is case insensitive on Windows servers.
+func (c Client) Find(root string, pattern string, opts FindOptions) ([]FindResult, error) {
+	return c.FindContext(context.Background(), root, pattern, opts)
+}
+
+// FindContext is like Find but aborts when ctx is done.
+func (c Client) FindContext(ctx context.Context, root string, pattern string, opts FindOptions) (results []FindResult, err error) {
+
+	if _, err = path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	err = c.withSftp(ctx, func(ftp *sftp.Client) (err error) {
+		results, err = find(ftp, c.remotePath(ftp, root), pattern, opts, c.remoteWindows(ftp))
+		return
+	})
+
+	return
+}
+
+// find walks root, fold makes pattern matching case insensitive.
+func find(ftp *sftp.Client, root string, pattern string, opts FindOptions, fold bool) ([]FindResult, error) {
+
+	pattern = pathKey(pattern, fold)
+
+	var (
+		results []FindResult
+		walker  = ftp.Walk(root)
+		base    = depth(root)
+	)
+
+	for walker.Step() {
+
+		// An unreadable or vanished entry is skipped, not the whole walk.
+		if err := walker.Err(); err != nil {
+			if walker.Path() != root && (permissionDenied(err) || os.IsNotExist(err)) {
+				continue
+			}
+			return results, err
+		}
+
+		p, info := walker.Path(), walker.Stat()
+
+		if opts.MaxDepth > 0 && depth(p)-base >= opts.MaxDepth && info.IsDir() {
+			walker.SkipDir()
+		}
+
+		if opts.MaxDepth > 0 && depth(p)-base > opts.MaxDepth {
+			continue
+		}
+
+		if (opts.FilesOnly && !info.Mode().IsRegular()) || (opts.DirsOnly && !info.IsDir()) {
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, pathKey(path.Base(p), fold)); ok {
+			results = append(results, FindResult{Path: p, Info: info})
+		}
+	}
+
+	return results, nil
+}
+
+// Grep returns the lines matching re in the remote file, or in every regular
+// file below it when p is a directory. Below a directory the files and
+// directories that can't be read are skipped, lines longer than
+// GrepMaxLineLength are skipped too.
+func (c Client) Grep(p string, re *regexp.Regexp) ([]GrepMatch, error) {
+	return c.GrepContext(context.Background(), p, re)
+}
+
+// GrepContext is like Grep but aborts when ctx is done.
+func (c Client) GrepContext(ctx context.Context, p string, re *regexp.Regexp) (matches []GrepMatch, err error) {
+
+	err = c.withSftp(ctx, func(ftp *sftp.Client) error {
+
+		root := c.remotePath(ftp, p)
+
+		files, err := find(ftp, root, "*", FindOptions{FilesOnly: true}, false)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+
+			found, err := grep(ftp, file.Path, re)
+			if err != nil && !(file.Path != root && (permissionDenied(err) || os.IsNotExist(err))) {
+				return err
+			}
+
+			matches = append(matches, found...)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func grep(ftp *sftp.Client, p string, re *regexp.Regexp) ([]GrepMatch, error) {
+
+	f, err := ftp.Open(p)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	defer f.Close()
+
+	var (
+		line    int
+		text    []byte
+		long    bool
+		matches []GrepMatch
+		r       = bufio.NewReaderSize(f, 64*1024)
+	)
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+
+		if len(text)+len(chunk) > GrepMaxLineLength {
+			long = true
+		} else if !long {
+			text = append(text, chunk...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if len(chunk) > 0 || len(text) > 0 {
+			line++
+			s := strings.TrimSuffix(strings.TrimSuffix(string(text), "\n"), "\r")
+			if !long && re.MatchString(s) {
+				matches = append(matches, GrepMatch{Path: p, Line: line, Text: s})
+			}
+		}
+
+		text, long = text[:0], false
+
+		if err == io.EOF {
+			return matches, nil
+		}
+
+		if err != nil {
+			return matches, err
+		}
+	}
+}
+
+func depth(p string) int {
+	return strings.Count(path.Clean(p), "/")
+}
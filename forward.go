@@ -0,0 +1,229 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// LocalForward listens on localAddr and forwards its connections to remoteAddr
+// from the server, like ssh -L. Forwarding stops and the forwarded connections
+// are closed when ctx is done or the returned listener is closed.
+func (c Client) LocalForward(ctx context.Context, localAddr string, remoteAddr string) (net.Listener, error) {
+
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	go forward(ctx, ln, func(net.Conn) (net.Conn, error) {
+		return c.Dial("tcp", remoteAddr)
+	}, c.Config.logf)
+
+	return ln, nil
+}
+
+// DialContext connects to addr from the server like Dial, it returns the ctx
+// error when ctx is done before the channel is open.
+func (c Client) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := c.Dial(network, addr)
+		done <- dialResult{conn, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.conn, res.err
+	case <-ctx.Done():
+		// A channel opened too late is closed.
+		go func() {
+			if res := <-done; res.err == nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// ContextDialer returns a dialer of addr from the server for APIs taking a
+// func(ctx, addr) like grpc.WithContextDialer, so services on the server
+// network are called without a local listener. An addr starting with / is
+// a remote unix socket path.
+func (c Client) ContextDialer() func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+
+		if strings.HasPrefix(addr, "/") {
+			return c.DialContext(ctx, "unix", addr)
+		}
+
+		return c.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// ListenRemote asks the server to listen on addr and returns the listener
+// of the connections it forwards, for servers of this process like an
+// http.Server to serve the remote port. An addr starting with / is a remote
+// unix socket path, a port 0 lets the server pick the port returned by Addr.
+// Accept fails once the listener or the client is closed.
+func (c Client) ListenRemote(addr string) (net.Listener, error) {
+
+	if strings.HasPrefix(addr, "/") {
+		return c.ListenUnix(addr)
+	}
+
+	return c.Listen("tcp", addr)
+}
+
+// RemoteForward asks the server to listen on remoteBindAddr and forwards its
+// connections to localTargetAddr, like ssh -R. It blocks until ctx is done
+// or the server listener fails, the forwarded connections are then closed.
+// remoteBindAddr is parsed like the ListenRemote addr.
+func (c Client) RemoteForward(ctx context.Context, remoteBindAddr string, localTargetAddr string) error {
+
+	ln, err := c.ListenRemote(remoteBindAddr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	var dialer net.Dialer
+
+	err = forward(ctx, ln, func(net.Conn) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", localTargetAddr)
+	}, c.Config.logf)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// forward proxies the ln connections to the dial ones until ctx is done or
+// ln is closed, it returns the error that stopped accepting connections.
+// dial gets the accepted connection for protocols picking the target on it.
+func forward(ctx context.Context, ln net.Listener, dial func(net.Conn) (net.Conn, error), logf func(string, ...interface{})) error {
+
+	var acceptErr error
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stopped bool
+		conns   = make(map[net.Conn]struct{})
+		done    = make(chan struct{})
+	)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-done:
+		}
+	}()
+
+	// track registers conn to be closed on stop, it closes conn when stopped.
+	track := func(conn net.Conn) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			conn.Close()
+			return false
+		}
+		conns[conn] = struct{}{}
+		return true
+	}
+
+	untrack := func(closed ...net.Conn) {
+		mu.Lock()
+		for _, conn := range closed {
+			delete(conns, conn)
+		}
+		mu.Unlock()
+	}
+
+	for {
+
+		conn, err := ln.Accept()
+		if err != nil {
+			acceptErr = err
+			break
+		}
+
+		track(conn)
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			target, err := dial(conn)
+			if err != nil {
+				logf("forward %s: %s", conn.RemoteAddr(), err)
+				conn.Close()
+				untrack(conn)
+				return
+			}
+
+			if track(target) {
+				proxy(conn, target)
+			}
+
+			untrack(conn, target)
+		}()
+	}
+
+	close(done)
+
+	mu.Lock()
+	stopped = true
+	for conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+
+	wg.Wait()
+
+	return acceptErr
+}
+
+// proxy copies a and b to each other until both directions are done.
+func proxy(a net.Conn, b net.Conn) {
+
+	done := make(chan struct{}, 2)
+
+	cp := func(dst net.Conn, src net.Conn) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+
+	<-done
+	<-done
+
+	a.Close()
+	b.Close()
+}
@@ -6,4 +6,5 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/pkg/sftp v1.13.5
 	golang.org/x/crypto v0.6.0
+	golang.org/x/text v0.7.0
 )
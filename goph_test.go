@@ -1,13 +1,42 @@
 package goph_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/ahmet2mir/goph"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -57,6 +86,108 @@ func TestGoph(t *testing.T) {
 	t.Run("gophRunTest", gophRunTest)
 	t.Run("gophAuthTest", gophAuthTest)
 	t.Run("gophWrongPassTest", gophWrongPassTest)
+	t.Run("gophFailoverTest", gophFailoverTest)
+	t.Run("gophPoolTest", gophPoolTest)
+	t.Run("gophEditFileTest", gophEditFileTest)
+	t.Run("gophPresetTest", gophPresetTest)
+	t.Run("gophWeakAlgorithmTest", gophWeakAlgorithmTest)
+	t.Run("gophErrorsTest", gophErrorsTest)
+	t.Run("gophEnvFileTest", gophEnvFileTest)
+	t.Run("gophAgentTest", gophAgentTest)
+	t.Run("gophCloneTest", gophCloneTest)
+	t.Run("gophDisconnectTest", gophDisconnectTest)
+	t.Run("gophSecretTest", gophSecretTest)
+	t.Run("gophInstanceConnectTest", gophInstanceConnectTest)
+	t.Run("gophOSLoginTest", gophOSLoginTest)
+	t.Run("gophCertRenewTest", gophCertRenewTest)
+	t.Run("gophTimeoutTest", gophTimeoutTest)
+	t.Run("gophSSHConfigEnvTest", gophSSHConfigEnvTest)
+	t.Run("gophSftpContextTest", gophSftpContextTest)
+	t.Run("gophRunAllTest", gophRunAllTest)
+	t.Run("gophIdleTest", gophIdleTest)
+	t.Run("gophJumpTest", gophJumpTest)
+	t.Run("gophTerminalFilterTest", gophTerminalFilterTest)
+	t.Run("gophLocalForwardTest", gophLocalForwardTest)
+	t.Run("gophRemoteForwardTest", gophRemoteForwardTest)
+	t.Run("gophDirModeTest", gophDirModeTest)
+	t.Run("gophSocksProxyTest", gophSocksProxyTest)
+	t.Run("gophResolverTest", gophResolverTest)
+	t.Run("gophIOTimeoutTest", gophIOTimeoutTest)
+	t.Run("gophBreakerTest", gophBreakerTest)
+	t.Run("gophKnockTest", gophKnockTest)
+	t.Run("gophFindTest", gophFindTest)
+	t.Run("gophRenameTest", gophRenameTest)
+	t.Run("gophFsyncTest", gophFsyncTest)
+	t.Run("gophSftpLimitsTest", gophSftpLimitsTest)
+	t.Run("gophLegacyCompatTest", gophLegacyCompatTest)
+	t.Run("gophRecorderTest", gophRecorderTest)
+	t.Run("gophFaultsTest", gophFaultsTest)
+	t.Run("gophLocalTest", gophLocalTest)
+	t.Run("gophStdioTest", gophStdioTest)
+	t.Run("gophOpenChannelTest", gophOpenChannelTest)
+	t.Run("gophUploadFromURLTest", gophUploadFromURLTest)
+	t.Run("gophRunBinaryTest", gophRunBinaryTest)
+	t.Run("gophRunSelfTest", gophRunSelfTest)
+	t.Run("gophWatchTest", gophWatchTest)
+	t.Run("gophEncodingTest", gophEncodingTest)
+	t.Run("gophLineEndingTest", gophLineEndingTest)
+	t.Run("gophRemotePathTest", gophRemotePathTest)
+	t.Run("gophCmdTempDirTest", gophCmdTempDirTest)
+	t.Run("gophBareClientTest", gophBareClientTest)
+	t.Run("gophTrafficTest", gophTrafficTest)
+	t.Run("gophOnCommandTest", gophOnCommandTest)
+	t.Run("gophConfigEnvTest", gophConfigEnvTest)
+	t.Run("gophProbeTest", gophProbeTest)
+	t.Run("gophRedactorTest", gophRedactorTest)
+	t.Run("gophKeychainTest", gophKeychainTest)
+	t.Run("gophUploadDirSymlinkTest", gophUploadDirSymlinkTest)
+	t.Run("gophDirTransferTest", gophDirTransferTest)
+	t.Run("gophRunJSONTest", gophRunJSONTest)
+	t.Run("gophParallelDownloadTest", gophParallelDownloadTest)
+	t.Run("gophTransferContextTest", gophTransferContextTest)
+	t.Run("gophSCPTest", gophSCPTest)
+	t.Run("gophTransferQueueTest", gophTransferQueueTest)
+	t.Run("gophDiskUsageTest", gophDiskUsageTest)
+	t.Run("gophFromSSHConfigTest", gophFromSSHConfigTest)
+	t.Run("gophKeyboardInteractiveChallengeTest", gophKeyboardInteractiveChallengeTest)
+	t.Run("gophDiagnosticsTest", gophDiagnosticsTest)
+	t.Run("gophRunEscalateTest", gophRunEscalateTest)
+	t.Run("gophCertificateAuthTest", gophCertificateAuthTest)
+	t.Run("gophCertCheckerTest", gophCertCheckerTest)
+	t.Run("gophSudoConfigTest", gophSudoConfigTest)
+	t.Run("gophCrontabTest", gophCrontabTest)
+	t.Run("gophTOFUKnownHostsTest", gophTOFUKnownHostsTest)
+	t.Run("gophKeepAliveTest", gophKeepAliveTest)
+	t.Run("gophUserManagementTest", gophUserManagementTest)
+	t.Run("gophAuthorizedKeysTest", gophAuthorizedKeysTest)
+	t.Run("gophResilientClientTest", gophResilientClientTest)
+	t.Run("gophNewClientContextTest", gophNewClientContextTest)
+	t.Run("gophInstallPackagesTest", gophInstallPackagesTest)
+	t.Run("gophRebootTest", gophRebootTest)
+	t.Run("gophCmdStartWaitTest", gophCmdStartWaitTest)
+	t.Run("gophCmdDirTest", gophCmdDirTest)
+	t.Run("gophRemoteLockTest", gophRemoteLockTest)
+	t.Run("gophScanHostKeysTest", gophScanHostKeysTest)
+	t.Run("gophCmdQuotingTest", gophCmdQuotingTest)
+	t.Run("gophListenRemoteTest", gophListenRemoteTest)
+	t.Run("gophShellTest", gophShellTest)
+	t.Run("gophExpectTest", gophExpectTest)
+	t.Run("gophContextDialerTest", gophContextDialerTest)
+	t.Run("gophSessionsTest", gophSessionsTest)
+	t.Run("gophSudoTest", gophSudoTest)
+	t.Run("gophGroupTest", gophGroupTest)
+	t.Run("gophLeakDetectionTest", gophLeakDetectionTest)
+	t.Run("gophConfigValidateTest", gophConfigValidateTest)
+	t.Run("gophMaxConcurrentSessionsTest", gophMaxConcurrentSessionsTest)
+	t.Run("gophInventoryTest", gophInventoryTest)
+	t.Run("gophRunStreamTest", gophRunStreamTest)
+	t.Run("gophRunWithInputTest", gophRunWithInputTest)
+	t.Run("gophGroupStreamTest", gophGroupStreamTest)
+	t.Run("gophTempFileModeTest", gophTempFileModeTest)
+	t.Run("gophGlobalRequestsTest", gophGlobalRequestsTest)
+	t.Run("gophPoolHealthTimeoutTest", gophPoolHealthTimeoutTest)
+	t.Run("gophBreakerContextTest", gophBreakerContextTest)
+	t.Run("gophFindUnreadableTest", gophFindUnreadableTest)
 }
 
 func gophAuthTest(t *testing.T) {
@@ -78,41 +209,5148 @@ func gophRunTest(t *testing.T) {
 
 	newServer("2021")
 
-	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2020, goph.Password("123456"))
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2021, goph.Password("123456"))
+	if err != nil {
+		t.Error(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Errorf("connect error: %s", err)
+	}
+
+	_, err = client.Run("ls")
+
+	if err != nil {
+		t.Errorf("run error: %s", err)
+	}
+}
+
+func gophWrongPassTest(t *testing.T) {
+
+	newServer("2022")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2022, goph.Password("wrong"))
+	if err != nil {
+		t.Error(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	_, err = goph.NewClient(config)
+	if !errors.Is(err, goph.ErrAuthFailed) {
+		t.Errorf("it should return an auth error, got: %v", err)
+	}
+}
+
+func gophFailoverTest(t *testing.T) {
+
+	newServer("2023")
+
+	// Nothing listens on 127.0.10.11 so the client must fallback to Addrs.
+	config, err := goph.NewConfig("melbahja", "127.0.10.11", 2023, goph.Password("123456"))
+	if err != nil {
+		t.Error(err)
+	}
+	config.Addrs = []string{"127.0.10.10"}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("failover error: %s", err)
+	}
+	defer client.Close()
+
+	if client.RemoteAddr().String() != "127.0.10.10:2023" {
+		t.Errorf("connected to wrong address: %s", client.RemoteAddr())
+	}
+	// An auth failure is returned as is, without trying the next address.
+	config.Addr = "127.0.10.10"
+	config.Addrs = []string{"127.0.10.11"}
+	config.Auth = goph.Password("wrong")
+	config.ClientConfig.Auth = config.Auth
+
+	if _, err = goph.NewClient(config); !errors.Is(err, goph.ErrAuthFailed) {
+		t.Errorf("expected auth error of the primary address, got: %v", err)
+	}
+
+	config.Addr = "127.0.10.11"
+	config.Addrs = []string{"127.0.10.12"}
+
+	var failover *goph.FailoverError
+	if _, err = goph.NewClient(config); !errors.As(err, &failover) || len(failover.Errors) != 2 {
+		t.Errorf("expected errors of both addresses, got: %v", err)
+	}
+}
+
+func gophPoolTest(t *testing.T) {
+
+	newServer("2030")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2030, goph.Password("123456"))
+	if err != nil {
+		t.Error(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	pool := goph.NewPool(1, time.Minute)
+	defer pool.Close()
+
+	client, err := pool.Get(config)
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+
+	if _, err = pool.Get(config); err != goph.ErrPoolExhausted {
+		t.Errorf("expected pool exhausted error, got: %v", err)
+	}
+
+	pool.Put(client)
+
+	// The idle client is returned rather than a new connection.
+	reused, err := pool.Get(config)
+	if err != nil {
+		t.Fatalf("pool reuse error: %s", err)
+	}
+
+	if reused != client {
+		t.Error("pool did not reuse the idle client")
+	}
+
+	if stats := pool.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Open != 1 {
+		t.Errorf("unexpected pool stats: %+v", stats)
+	}
+}
+
+func gophEditFileTest(t *testing.T) {
+
+	client := newClient(t, "2031")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.conf")
+	if err = ioutil.WriteFile(file, []byte("port=80\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.EditFile(file, func(b []byte) ([]byte, error) {
+		return []byte(strings.Replace(string(b), "80", "8080", 1)), nil
+	}, goph.WithBackup(".bak"))
+	if err != nil {
+		t.Fatalf("edit file error: %s", err)
+	}
+
+	if b, _ := ioutil.ReadFile(file); string(b) != "port=8080\n" {
+		t.Errorf("unexpected edited content: %q", b)
+	}
+
+	if b, _ := ioutil.ReadFile(file + ".bak"); string(b) != "port=80\n" {
+		t.Errorf("unexpected backup content: %q", b)
+	}
+
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+		t.Errorf("file mode not preserved: %s", info.Mode())
+	}
+}
+
+func gophPresetTest(t *testing.T) {
+
+	newServer("2032")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2032, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.ApplyPreset(goph.FIPSPreset)
+
+	// The test server host key is a 3072 bits rsa key.
+	config.MinRSABits = 4096
+	if _, err = goph.NewClient(config); err == nil {
+		t.Fatal("small rsa host key should be rejected")
+	}
+
+	newServer("2033")
+	config.Port = 2033
+	config.MinRSABits = 3072
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with fips preset error: %s", err)
+	}
+	client.Close()
+
+	// The server only has a rsa host key.
+	newServer("2037")
+	config.Port = 2037
+	config.PinnedHostKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+
+	if _, err = goph.NewClient(config); err == nil {
+		t.Error("host key algorithm not pinned should be rejected")
+	}
+}
+
+func gophWeakAlgorithmTest(t *testing.T) {
+
+	newServer("2034")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2034, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.ClientConfig.Ciphers = []string{"aes128-ctr"}
+	config.ClientConfig.MACs = []string{"hmac-sha1"}
+	config.StrictAlgorithms = true
+
+	var reported []string
+	config.OnWeakAlgorithm = func(weak []string, negotiated goph.Algorithms) {
+		reported = weak
+	}
+
+	_, err = goph.NewClient(config)
+
+	var weakErr *goph.WeakAlgorithmError
+	if !errors.As(err, &weakErr) {
+		t.Fatalf("expected weak algorithm error, got: %v", err)
+	}
+
+	if len(reported) != 1 || reported[0] != "hmac-sha1" {
+		t.Errorf("unexpected weak algorithms: %v", reported)
+	}
+}
+
+func gophErrorsTest(t *testing.T) {
+
+	newServer("2035")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2035, goph.Password("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	if _, err = goph.NewClient(config); !errors.Is(err, goph.ErrAuthFailed) {
+		t.Errorf("expected auth failed error, got: %v", err)
+	}
+
+	client := newClient(t, "2036")
+	defer client.Close()
+
+	if err = client.Download("/goph/not/found", os.DevNull); !errors.Is(err, goph.ErrFileNotFound) {
+		t.Errorf("expected file not found error, got: %v", err)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file not found error should wrap os.ErrNotExist, got: %v", err)
+	}
+}
+
+func gophEnvFileTest(t *testing.T) {
+
+	b, err := goph.RenderEnvFile(map[string]string{
+		"DB_PASSWORD": `p"a$s`,
+		"APP_ENV":     "prod",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "APP_ENV=\"prod\"\nDB_PASSWORD=\"p\\\"a\\$s\"\n"; string(b) != want {
+		t.Errorf("unexpected env file: %q, want %q", b, want)
+	}
+
+	if _, err = goph.RenderEnvFile(map[string]string{"BAD NAME": "x"}); err == nil {
+		t.Error("invalid env var name should be rejected")
+	}
+
+	dir, err := ioutil.TempDir("", "goph-envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	value := "line one\nline \"two\" $HOME `x` \\"
+	if b, err = goph.RenderEnvFile(map[string]string{"MULTI": value}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "env")
+	ioutil.WriteFile(path, b, 0600)
+
+	out, err := exec.Command("sh", "-c", `. "$0" && printf %s "$MULTI"`, path).Output()
+	if err != nil || string(out) != value {
+		t.Errorf("unexpected sourced value: %q, %v", out, err)
+	}
+}
+
+func gophAgentTest(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "goph-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", sock)
+
+	a, err := goph.NewAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.AddKey(key, "deploy", 0); err != nil {
+		t.Fatalf("add key error: %s", err)
+	}
+
+	keys, err := a.Identities()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "deploy" {
+		t.Fatalf("unexpected identities: %v, %v", keys, err)
+	}
+
+	if err = a.RemoveKey(keys[0]); err != nil {
+		t.Fatalf("remove key error: %s", err)
+	}
+
+	if keys, _ = a.Identities(); len(keys) != 0 {
+		t.Errorf("key not removed: %v", keys)
+	}
+}
+
+func gophCloneTest(t *testing.T) {
+
+	client := newClient(t, "2038")
+	defer client.Close()
+
+	newServer("2039")
+
+	clone, err := client.Clone(goph.WithPort(2039))
+	if err != nil {
+		t.Fatalf("clone error: %s", err)
+	}
+	defer clone.Close()
+
+	if clone.Config.Port != 2039 || client.Config.Port != 2038 {
+		t.Errorf("unexpected ports: clone %d, client %d", clone.Config.Port, client.Config.Port)
+	}
+
+	if _, err = clone.Run("ls"); err != nil {
+		t.Errorf("run on clone error: %s", err)
+	}
+
+	clone.Close()
+	if err = clone.Wait(); err != nil {
+		t.Errorf("wait after close should return nil, got: %s", err)
+	}
+}
+
+func gophDisconnectTest(t *testing.T) {
+
+	newServer("2041")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2041, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.Faults = &goph.Faults{DisconnectAfter: 64 * 1024}
+
+	disconnected := make(chan *goph.DisconnectError, 1)
+	config.OnDisconnect = func(err *goph.DisconnectError) {
+		disconnected <- err
+	}
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	client.Run("head -c 131072 /dev/zero")
+
+	select {
+	case err := <-disconnected:
+		if err.Reason != goph.DisconnectNetworkReset || !errors.Is(err, goph.ErrInjectedDisconnect) {
+			t.Errorf("unexpected disconnect: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("OnDisconnect not called")
+	}
+}
+
+func gophSecretTest(t *testing.T) {
+
+	fetches := 0
+	store := goph.SecretStoreFunc(func(ctx context.Context, name string) ([]byte, error) {
+		fetches++
+		if name != "ssh/melbahja" {
+			return nil, errors.New("unknown secret")
+		}
+		return []byte("123456"), nil
+	})
+
+	secret := goph.NewSecret(store, "ssh/melbahja", 0)
+
+	newServer("2042")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2042, goph.SecretPassword(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with secret password error: %s", err)
+	}
+	client.Close()
+
+	secret.Get(context.Background())
+	if fetches != 1 {
+		t.Errorf("secret should be cached, fetched %d times", fetches)
+	}
+
+	secret.Invalidate()
+	secret.Get(context.Background())
+	if fetches != 2 {
+		t.Errorf("invalidated secret should be fetched again, fetched %d times", fetches)
+	}
+}
+
+func gophInstanceConnectTest(t *testing.T) {
+
+	pusher := goph.InstanceConnectFunc(func(ctx context.Context, instanceID string, osUser string, publicKey string) error {
+		if instanceID != "i-0123456789" || osUser != "melbahja" {
+			return fmt.Errorf("unexpected push for %s@%s", osUser, instanceID)
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		authorizedKey = key
+		return err
+	})
+	defer func() { authorizedKey = nil }()
+
+	newServer("2043")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2043, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.InstanceConnect(context.Background(), pusher, "i-0123456789", config)
+	if err != nil {
+		t.Fatalf("instance connect error: %s", err)
+	}
+	client.Close()
+
+	if config.Auth != nil {
+		t.Error("instance connect should not change the config auth")
+	}
+
+	// ctx also bounds the connection made with the pushed key.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceling := goph.InstanceConnectFunc(func(ctx context.Context, instanceID string, osUser string, publicKey string) error {
+		cancel()
+		return pusher(ctx, instanceID, osUser, publicKey)
+	})
+	if _, err = goph.InstanceConnect(ctx, canceling, "i-0123456789", config); err != context.Canceled {
+		t.Errorf("expected the ctx error, got %v", err)
+	}
+}
+
+func gophOSLoginTest(t *testing.T) {
+
+	registrar := goph.OSLoginFunc(func(ctx context.Context, publicKey string, ttl time.Duration) (string, error) {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		authorizedKey = key
+		return "melbahja_example_com", err
+	})
+	defer func() { authorizedKey = nil }()
+
+	newServer("2044")
+
+	config, err := goph.NewConfig("me@example.com", "127.0.10.10", 2044, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.OSLogin(context.Background(), registrar, 10*time.Minute, config)
+	if err != nil {
+		t.Fatalf("os login error: %s", err)
+	}
+	defer client.Close()
+
+	if user := client.Config.ClientConfig.User; user != "melbahja_example_com" {
+		t.Errorf("unexpected os login user: %s", user)
+	}
+	// ctx also bounds the connection made with the registered key.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceling := goph.OSLoginFunc(func(ctx context.Context, publicKey string, ttl time.Duration) (string, error) {
+		cancel()
+		return registrar(ctx, publicKey, ttl)
+	})
+	if _, err = goph.OSLogin(ctx, canceling, 10*time.Minute, config); err != context.Canceled {
+		t.Errorf("expected the ctx error, got %v", err)
+	}
+}
+
+func gophCertRenewTest(t *testing.T) {
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := 0
+	renewer := goph.NewCertRenewer(func(ctx context.Context) (ssh.Signer, error) {
+
+		signer, err := goph.EphemeralKey()
+		if err != nil {
+			return nil, err
+		}
+
+		cert := &ssh.Certificate{
+			Key:             signer.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"melbahja"},
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err = cert.SignCert(rand.Reader, ca); err != nil {
+			return nil, err
+		}
+
+		// The server only trusts the certificates issued after the first one.
+		if issued++; issued > 1 {
+			authorizedKey = cert
+		}
+
+		return ssh.NewCertSigner(cert, signer)
+	}, time.Minute)
+	defer func() { authorizedKey = nil }()
+
+	newServer("2045")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2045, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.CertRenewer = renewer
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with renewed certificate error: %s", err)
+	}
+	client.Close()
+
+	if client, err = goph.NewClient(config); err != nil {
+		t.Fatalf("reconnect error: %s", err)
+	}
+	client.Close()
+
+	if issued != 2 {
+		t.Errorf("unexpected issued certificates: %d, want 2", issued)
+	}
+}
+
+func gophTimeoutTest(t *testing.T) {
+
+	// A listener that never speaks ssh hangs the handshake.
+	ln, err := net.Listen("tcp", "127.0.10.10:2046")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2046, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.Timeout = 100 * time.Millisecond
+
+	if _, err = goph.NewClient(config); !errors.Is(err, goph.ErrConnTimeout) {
+		t.Errorf("expected ErrConnTimeout on handshake timeout, got: %v", err)
+	}
+}
+
+func gophSSHConfigEnvTest(t *testing.T) {
+
+	sshConfig, err := goph.ParseSSHConfig(strings.NewReader(`
+Host other
+  SetEnv GOPH_TEST=other
+
+Host 127.0.10.* !127.0.10.10
+  SetEnv GOPH_TEST=negated
+
+Host 127.0.10.*
+  SetEnv GOPH_TEST=ok "GOPH_QUOTED=a b"
+  SendEnv GOPH_SEND_* -GOPH_SEND_SECRET
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GOPH_SEND_VAR", "sent")
+	defer os.Unsetenv("GOPH_SEND_VAR")
+
+	client := newClient(t, "2047")
+	defer client.Close()
+
+	client.Config.ApplySSHConfig(sshConfig, "127.0.10.10")
+
+	if send := client.Config.SendEnv; len(send) != 2 || send[1] != "-GOPH_SEND_SECRET" {
+		t.Errorf("unexpected SendEnv: %v", send)
+	}
+
+	out, err := client.Run(`echo "$GOPH_TEST,$GOPH_QUOTED,$GOPH_SEND_VAR"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "ok,a b,sent\n"; string(out) != want {
+		t.Errorf("unexpected session env: %q, want %q", out, want)
+	}
+}
+
+func gophSftpContextTest(t *testing.T) {
+
+	client := newClient(t, "2048")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-find")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("x"), 0644)
+
+	results, err := client.FindContext(context.Background(), dir, "*.conf", goph.FindOptions{})
+	if err != nil || len(results) != 1 {
+		t.Errorf("unexpected find results: %v, %v", results, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = client.NewSftpContext(ctx); err != context.Canceled {
+		t.Errorf("expected canceled sftp setup, got: %v", err)
+	}
+
+	if _, err = client.FindContext(ctx, dir, "*.conf", goph.FindOptions{}); err != context.Canceled {
+		t.Errorf("expected canceled find, got: %v", err)
+	}
+}
+
+func gophRunAllTest(t *testing.T) {
+
+	client := newClient(t, "2049")
+	defer client.Close()
+
+	report := client.RunAll(context.Background(), []goph.Command{
+		{Name: "hello", Cmd: "echo hello"},
+		{Cmd: "exit 2"},
+		{Cmd: "exit 1", StopOnError: true},
+		{Cmd: "echo never"},
+	})
+
+	want := []goph.CommandStatus{goph.StatusOK, goph.StatusFailed, goph.StatusFailed, goph.StatusSkipped}
+	for i, res := range report.Results {
+		if res.Status != want[i] {
+			t.Errorf("command %d %s: status %s, want %s", i, res.Name, res.Status, want[i])
+		}
+	}
+
+	if report.OK() || string(report.Results[0].Output) != "hello\n" || report.Results[1].ExitCode != 2 {
+		t.Errorf("unexpected report: %+v", report.Results)
+	}
+}
+
+func gophIdleTest(t *testing.T) {
+
+	newServer("2050")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2050, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.IdleTimeout = 200 * time.Millisecond
+	config.IdleCloseConn = true
+
+	idle := make(chan string, 2)
+	config.OnIdle = func(c *goph.Client, s *goph.SessionInfo) bool {
+		if s == nil {
+			idle <- "connection"
+		} else {
+			idle <- s.Type
+		}
+		return true
+	}
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	if _, err = client.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"session", "connection"} {
+		select {
+		case got := <-idle:
+			if got != want {
+				t.Errorf("idle %s closed, want %s", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("idle %s not closed", want)
+		}
+	}
+
+	if err = client.Wait(); err != nil {
+		t.Errorf("idle close should end the connection without error, got: %s", err)
+	}
+}
+
+func gophJumpTest(t *testing.T) {
+
+	newServer("2051")
+	newServer("2052")
+
+	jump, err := goph.NewConfig("melbahja", "127.0.10.10", 2051, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2052, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.Jump = jump
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect through jump host error: %s", err)
+	}
+	defer client.Close()
+
+	if out, err := client.Run("echo jumped"); err != nil || string(out) != "jumped\n" {
+		t.Errorf("run through jump host: %q, %v", out, err)
+	}
+
+	// A target accepting tcp but never answering must time out.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(silent.Addr().String())
+	config.Addr, config.Timeout = "127.0.0.1", 200*time.Millisecond
+	fmt.Sscan(port, &config.Port)
+
+	start := time.Now()
+	if _, err = goph.NewClient(config); !errors.Is(err, goph.ErrConnTimeout) || time.Since(start) > 2*time.Second {
+		t.Errorf("expected handshake timeout through jump host, got %v after %s", err, time.Since(start))
+	}
+}
+
+func gophTerminalFilterTest(t *testing.T) {
+
+	var out bytes.Buffer
+	w := goph.NewTerminalFilter(&out)
+
+	// Written in two parts to split the sequences between writes.
+	w.Write([]byte("\x1b[1;31mred\x1b[0m \x1b]0;pwn"))
+	w.Write([]byte("ed\x07\x1b]52;c;ZWNobw==\x1b\\\x1b[?2004h\x1b[21t\x00ok\r\n"))
+
+	if want := "\x1b[1;31mred\x1b[0m \x1b[?2004hok\r\n"; out.String() != want {
+		t.Errorf("unexpected filtered output: %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+
+	// C1 controls as raw bytes or runes go, split runes and DEL too.
+	w.Write([]byte("\x9b31m\xc2\x9d52;x\x07 h\xc3"))
+	w.Write([]byte("\xa9llo \xe2\x82\xac\x7f!"))
+
+	if want := "31m52;x\x07 h\u00e9llo \u20ac!"; out.String() != want {
+		t.Errorf("unexpected C1 filtered output: %q, want %q", out.String(), want)
+	}
+
+	in := goph.NewPasteFilter(strings.NewReader("ls\x1b[A\x1b[200~echo \x03h\x1b[Ai\x1b[201~\r"))
+
+	b, _ := ioutil.ReadAll(in)
+	if want := "ls\x1b[A\x1b[200~echo h[Ai\x1b[201~\r"; string(b) != want {
+		t.Errorf("unexpected filtered input: %q, want %q", b, want)
+	}
+}
+
+func gophLocalForwardTest(t *testing.T) {
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+
+	client := newClient(t, "2053")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := client.LocalForward(ctx, "127.0.0.1:0", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err = io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+		t.Errorf("unexpected forwarded reply: %q, %v", b, err)
+	}
+
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Read(b); err == nil {
+		t.Error("forwarded connection should be closed with the context")
+	}
+}
+
+func gophRemoteForwardTest(t *testing.T) {
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+
+	client := newClient(t, "2054")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.RemoteForward(ctx, "127.0.10.10:2055", echo.Addr().String())
+	}()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", "127.0.10.10:2055"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("pong"))
+	b := make([]byte, 4)
+	if _, err = io.ReadFull(conn, b); err != nil || string(b) != "pong" {
+		t.Errorf("unexpected reverse forwarded reply: %q, %v", b, err)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Errorf("unexpected remote forward error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("remote forward not stopped with the context")
+	}
+}
+
+func gophDirModeTest(t *testing.T) {
+
+	client := newClient(t, "2056")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-dirmode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "src")
+	ioutil.WriteFile(local, []byte("x"), 0644)
+
+	remote := filepath.Join(dir, "a", "b", "file")
+	if err = client.Upload(local, remote, goph.WithUmask(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "b")} {
+		if info, err := os.Stat(d); err != nil || info.Mode().Perm() != 0777 {
+			t.Errorf("unexpected mode of %s: %v, %v", d, info, err)
+		}
+	}
+
+	if err = client.MkdirAll(filepath.Join(dir, "a", "c"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "a", "c")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("unexpected mkdir mode: %v, %v", info, err)
+	}
+
+	if err = client.MkdirAll(remote, 0750); err == nil {
+		t.Error("expected error creating a directory over a file")
+	}
+
+	// A full umask still creates the dirs, with no permission at all.
+	closed := filepath.Join(dir, "closed")
+	client.Upload(local, filepath.Join(closed, "file"), goph.WithUmask(0777))
+	info, err := os.Stat(closed)
+	if err != nil || info.Mode().Perm() != 0 {
+		t.Errorf("unexpected full umask dir: %v, %v", info, err)
+	}
+	os.Chmod(closed, 0755)
+}
+
+func gophSocksProxyTest(t *testing.T) {
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+
+	client := newClient(t, "2057")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.StartSocksProxy(ctx, "127.0.10.10:2058")
+	}()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", "127.0.10.10:2058"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr := echo.Addr().(*net.TCPAddr)
+	host := []byte(addr.IP.String())
+
+	conn.Write([]byte{5, 1, 0})
+	req := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+	conn.Write(append(req, byte(addr.Port>>8), byte(addr.Port)))
+
+	b := make([]byte, 12)
+	if _, err = io.ReadFull(conn, b); err != nil || b[1] != 0 || b[3] != 0 {
+		t.Fatalf("unexpected socks handshake: %v, %v", b, err)
+	}
+
+	conn.Write([]byte("ping"))
+	if _, err = io.ReadFull(conn, b[:4]); err != nil || string(b[:4]) != "ping" {
+		t.Errorf("unexpected proxied reply: %q, %v", b[:4], err)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Errorf("unexpected socks proxy error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("socks proxy not stopped with the context")
+	}
+}
+
+func gophResolverTest(t *testing.T) {
+
+	newServer("2059")
+
+	config, err := goph.NewConfig("melbahja", "goph-hosts.test", 2059, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.Hosts = map[string]string{"goph-hosts.test": "127.0.10.10"}
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("hosts override error: %s", err)
+	}
+	client.Close()
+
+	var lookups int32
+	config.Addr = "goph-resolver.test"
+	config.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&lookups, 1)
+			return nil, errors.New("no dns in tests")
+		},
+	}
+
+	if _, err = goph.NewClient(config); err == nil || atomic.LoadInt32(&lookups) == 0 {
+		t.Errorf("expected a lookup with the config resolver, got: %v", err)
+	}
+}
+
+func gophIOTimeoutTest(t *testing.T) {
+
+	client := newClient(t, "2060")
+	defer client.Close()
+
+	client.Config.IOTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	if _, err := client.Run("echo start; sleep 2"); err != goph.ErrIOTimeout {
+		t.Errorf("expected i/o timeout, got: %v", err)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stalled command aborted after %s", d)
+	}
+
+	// Timeouts shorter than the check interval must not panic the ticker.
+	client.Config.IOTimeout = time.Nanosecond
+	if _, err := client.Run("sleep 1"); err != goph.ErrIOTimeout {
+		t.Errorf("expected i/o timeout, got: %v", err)
+	}
+}
+
+func gophBreakerTest(t *testing.T) {
+
+	newServer("2061")
+
+	var transitions []string
+	breaker := goph.NewBreaker(2, 100*time.Millisecond)
+	breaker.OnStateChange = func(host string, from, to goph.BreakerState) {
+		transitions = append(transitions, host+" "+to.String())
+	}
+
+	// Nothing listens on 127.0.10.11.
+	config, err := goph.NewConfig("melbahja", "127.0.10.11", 2061, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	for i := 0; i < 2; i++ {
+		if _, err = breaker.NewClient(config); err == nil || errors.Is(err, goph.ErrCircuitOpen) {
+			t.Fatalf("expected a connection error, got: %v", err)
+		}
+	}
+
+	if _, err = breaker.NewClient(config); !errors.Is(err, goph.ErrCircuitOpen) {
+		t.Errorf("expected open circuit, got: %v", err)
+	}
+
+	if state := breaker.State("127.0.10.11:2062"); state != goph.BreakerClosed {
+		t.Errorf("another port of the host must have its own circuit, got: %s", state)
+	}
+
+	// Once cooled down the probe goes to the test server and closes the circuit.
+	time.Sleep(150 * time.Millisecond)
+	config.Hosts = map[string]string{"127.0.10.11": "127.0.10.10"}
+
+	client, err := breaker.NewClient(config)
+	if err != nil {
+		t.Fatalf("half-open probe error: %s", err)
+	}
+	client.Close()
+
+	want := "127.0.10.11:2061 open,127.0.10.11:2061 half-open,127.0.10.11:2061 closed"
+	if got := strings.Join(transitions, ","); got != want {
+		t.Errorf("unexpected transitions: %s", got)
+	}
+
+	// Auth failures don't count against the host.
+	config.Auth = goph.Password("wrong")
+	config.ClientConfig.Auth = config.Auth
+
+	for i := 0; i < 3; i++ {
+		if _, err = breaker.NewClient(config); !errors.Is(err, goph.ErrAuthFailed) {
+			t.Errorf("expected auth error, got: %v", err)
+		}
+	}
+
+	if state := breaker.State("127.0.10.11:2061"); state != goph.BreakerClosed {
+		t.Errorf("auth failures opened the circuit: %s", state)
+	}
+}
+
+func gophKnockTest(t *testing.T) {
+
+	newServer("2062")
+
+	tcp, err := net.Listen("tcp", "127.0.10.10:2063")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	udp, err := net.ListenPacket("udp", "127.0.10.10:2064")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	knocks := make(chan string, 2)
+	go func() {
+		if conn, err := tcp.Accept(); err == nil {
+			knocks <- "tcp"
+			conn.Close()
+		}
+	}()
+	go func() {
+		if _, _, err := udp.ReadFrom(make([]byte, 1)); err == nil {
+			knocks <- "udp"
+		}
+	}()
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2062, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.Knock = []goph.Knock{
+		{Port: 2063, Delay: 20 * time.Millisecond},
+		{Protocol: "udp", Port: 2064, Delay: 20 * time.Millisecond},
+	}
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect after knock error: %s", err)
+	}
+	client.Close()
+
+	for _, want := range []string{"tcp", "udp"} {
+		select {
+		case got := <-knocks:
+			if got != want {
+				t.Errorf("expected %s knock, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s knock not received", want)
+		}
+	}
+
+	config.Knock = []goph.Knock{{Protocol: "icmp", Port: 1}}
+	if _, err = goph.NewClient(config); err == nil {
+		t.Error("expected unknown knock protocol error")
+	}
+}
+
+func gophFindTest(t *testing.T) {
+
+	client := newClient(t, "2065")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-find")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "etc", "app.d"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "etc", "app.conf"), []byte("port=22\nuser=root\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "etc", "app.d", "extra.conf"), []byte("port=2222\n"), 0644)
+
+	results, err := client.Find(dir, "*.conf", goph.FindOptions{})
+	if err != nil || len(results) != 2 {
+		t.Errorf("unexpected find results: %v, %v", results, err)
+	}
+
+	results, err = client.Find(dir, "*.conf", goph.FindOptions{MaxDepth: 2})
+	if err != nil || len(results) != 1 || results[0].Path != filepath.Join(dir, "etc", "app.conf") {
+		t.Errorf("unexpected depth limited results: %v, %v", results, err)
+	}
+
+	results, err = client.Find(dir, "*", goph.FindOptions{DirsOnly: true})
+	if err != nil || len(results) != 3 {
+		t.Errorf("unexpected directories: %v, %v", results, err)
+	}
+
+	matches, err := client.Grep(dir, regexp.MustCompile(`^port=`))
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("unexpected grep matches: %v, %v", matches, err)
+	}
+
+	for _, m := range matches {
+		if m.Line != 1 || !strings.HasPrefix(m.Text, "port=") {
+			t.Errorf("unexpected grep match: %+v", m)
+		}
+	}
+
+	if _, err = client.Find(dir, "[", goph.FindOptions{}); err == nil {
+		t.Error("expected bad pattern error")
+	}
+}
+
+func gophRenameTest(t *testing.T) {
+
+	client := newClient(t, "2066")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "dst")
+
+	rename := func(content string) {
+
+		src := filepath.Join(dir, "src")
+		ioutil.WriteFile(src, []byte(content), 0644)
+		ioutil.WriteFile(dst, []byte("old"), 0644)
+
+		if err := client.Rename(src, dst); err != nil {
+			t.Fatalf("rename error: %s", err)
+		}
+
+		if b, _ := ioutil.ReadFile(dst); string(b) != content {
+			t.Errorf("rename did not replace the destination: %q", b)
+		}
+
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("source still exists: %v", err)
+		}
+	}
+
+	// The test server supports posix-rename@openssh.com.
+	rename("posix")
+
+	// Without the extension the destination is removed then renamed.
+	sftp.SetSFTPExtensions("hardlink@openssh.com", "statvfs@openssh.com")
+	defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+
+	rename("fallback")
+}
+
+func gophFsyncTest(t *testing.T) {
+
+	client := newClient(t, "2067")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-fsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "src")
+	ioutil.WriteFile(local, []byte("data"), 0644)
+
+	if err = client.Upload(local, filepath.Join(dir, "plain")); err != nil {
+		t.Fatalf("upload error: %s", err)
+	}
+
+	// The test server lacks fsync@openssh.com, the upload must not pretend it synced.
+	err = client.Upload(local, filepath.Join(dir, "synced"), goph.WithFsync())
+
+	var status *sftp.StatusError
+	if !errors.As(err, &status) || status.FxCode() != sftp.ErrSSHFxOpUnsupported {
+		t.Errorf("expected unsupported fsync error, got: %v", err)
+	}
+}
+
+func gophSftpLimitsTest(t *testing.T) {
+
+	client := newClient(t, "2068")
+	defer client.Close()
+
+	if limits, err := client.SftpLimits(); limits != nil || err != nil {
+		t.Errorf("expected no limits without the extension, got: %v, %v", limits, err)
+	}
+
+	// Unsupported limits are cached too, only the first NewSftp queries them.
+	client.Config.SftpAutoTune = true
+	sessions := atomic.LoadInt32(&sftpSessions)
+
+	for i := 0; i < 2; i++ {
+		ftp, err := client.NewSftp()
+		if err != nil {
+			t.Fatalf("sftp error: %s", err)
+		}
+		ftp.Close()
+	}
+
+	if n := atomic.LoadInt32(&sftpSessions) - sessions; n != 3 {
+		t.Errorf("expected a single limits query, got %d sftp sessions", n)
+	}
+
+	want := goph.SftpLimits{MaxPacketLength: 34000, MaxReadLength: 2048, MaxWriteLength: 1024, MaxOpenHandles: 8}
+	sftpLimits = &want
+	defer func() {
+		sftpLimits = nil
+	}()
+
+	tuned := newClient(t, "2069")
+	defer tuned.Close()
+	tuned.Config.SftpAutoTune = true
+
+	if limits, err := tuned.SftpLimits(); err != nil || limits == nil || *limits != want {
+		t.Fatalf("unexpected limits: %v, %v", limits, err)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("x"), 8*1024)
+	local := filepath.Join(dir, "src")
+	ioutil.WriteFile(local, data, 0644)
+
+	if err = tuned.Upload(local, filepath.Join(dir, "dst")); err != nil {
+		t.Fatalf("upload error: %s", err)
+	}
+
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "dst")); !bytes.Equal(b, data) {
+		t.Error("uploaded file differs")
+	}
+
+	// The packets are sized to the smallest of the read and write limits.
+	if n := atomic.LoadInt32(&sftpMaxWrite); n == 0 || n > 1024 {
+		t.Errorf("unexpected write packet data size: %d", n)
+	}
+}
+
+func gophLegacyCompatTest(t *testing.T) {
+
+	newServerConfig("2070", func(config *ssh.ServerConfig) {
+		config.KeyExchanges = []string{"diffie-hellman-group1-sha1"}
+		config.Ciphers = []string{"aes128-cbc"}
+		config.MACs = []string{"hmac-sha1"}
+	})
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2070, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	if _, err = goph.NewClient(config); err == nil {
+		t.Fatal("default algorithms should not reach a legacy server")
+	}
+
+	config.ApplyPreset(goph.LegacyCompat)
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with legacy preset error: %s", err)
+	}
+	client.Close()
+
+	algos := client.Algorithms()
+	if algos.KeyExchange != "diffie-hellman-group1-sha1" || algos.CipherClientServer != "aes128-cbc" {
+		t.Errorf("unexpected negotiated algorithms: %+v", algos)
+	}
+
+	if weak := algos.Weak(); len(weak) != 3 {
+		t.Errorf("legacy algorithms should be reported weak: %v", weak)
+	}
+}
+
+func gophRecorderTest(t *testing.T) {
+
+	client := newClient(t, "2071")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-vcr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cassette := filepath.Join(dir, "cassette.json")
+	recorder := goph.NewRecorder(client, cassette)
+
+	// Output that is not valid UTF-8 must survive the fixture file.
+	binary, err := recorder.Run(`printf '\377\376ok'`)
+	if err != nil || string(binary) != "\xff\xfeok" {
+		t.Fatalf("unexpected recorded output: %q, %v", binary, err)
+	}
+
+	if _, err = recorder.Run("echo failed; exit 3"); err == nil {
+		t.Fatal("expected exit error")
+	}
+
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := goph.NewReplayer(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := replayer.Run(`printf '\377\376ok'`)
+	if err != nil || !bytes.Equal(out, binary) {
+		t.Errorf("unexpected replayed output: %q, %v", out, err)
+	}
+
+	out, err = replayer.Run("echo failed; exit 3")
+
+	var exitErr goph.ExitStatusError
+	if string(out) != "failed\n" || !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("unexpected replayed error: %q, %v", out, err)
+	}
+
+	// Every interaction is replayed once.
+	if _, err = replayer.Run("echo failed; exit 3"); !errors.Is(err, goph.ErrNoInteraction) {
+		t.Errorf("expected no interaction error, got: %v", err)
+	}
+}
+
+func gophFaultsTest(t *testing.T) {
+
+	client := newClient(t, "2072")
+	defer client.Close()
+
+	injected := errors.New("injected")
+	var opened []string
+
+	client.Config.Faults = &goph.Faults{
+		ChannelOpen: func(channelType string) error {
+			opened = append(opened, channelType)
+			if channelType == "sftp" {
+				return injected
+			}
+			return nil
+		},
+	}
+
+	if _, err := client.Run("true"); err != nil {
+		t.Errorf("run error: %s", err)
+	}
+
+	if _, err := client.NewSftp(); err != injected {
+		t.Errorf("expected injected sftp error, got: %v", err)
+	}
+
+	if strings.Join(opened, ",") != "session,sftp" {
+		t.Errorf("unexpected channel opens: %v", opened)
+	}
+
+	// Latency and DisconnectAfter apply to the connection, they are set before dialing.
+	config := *client.Config
+	config.Faults = &goph.Faults{Latency: 5 * time.Millisecond, DisconnectAfter: 32 * 1024}
+
+	slow, err := goph.NewClient(&config)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer slow.Close()
+
+	start := time.Now()
+	if _, err = slow.Run("true"); err != nil {
+		t.Errorf("run error: %s", err)
+	}
+
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("latency not injected, run took %s", d)
+	}
+
+	if _, err = slow.Run("head -c 65536 /dev/zero"); err == nil {
+		t.Error("expected an injected disconnect")
+	}
+}
+
+func gophLocalTest(t *testing.T) {
+
+	var local goph.Local
+
+	if out, err := local.Run("echo local"); err != nil || string(out) != "local\n" {
+		t.Errorf("unexpected local run: %q, %v", out, err)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	ioutil.WriteFile(src, []byte("a\nb\n"), 0644)
+
+	// sha256 of the converted "a\r\nb\r\n".
+	sum := "58055bdcc73787eb88c78d36f0b4939e9c5dc1c3ad17e25cc85a6833cf1a0cab"
+	dst := filepath.Join(dir, "sub", "dst")
+
+	err = local.Upload(src, dst, goph.WithChecksum("sha256", sum), goph.WithLineEnding("\r\n"), goph.WithDirMode(0700))
+	if err != nil {
+		t.Fatalf("local upload error: %s", err)
+	}
+
+	if b, _ := ioutil.ReadFile(dst); string(b) != "a\r\nb\r\n" {
+		t.Errorf("line endings not converted: %q", b)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "sub")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("unexpected directory mode: %v, %v", info, err)
+	}
+
+	if err = local.Download(src, dst, goph.WithChecksum("sha256", strings.Repeat("0", 64))); !errors.Is(err, goph.ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got: %v", err)
+	}
+}
+
+func gophStdioTest(t *testing.T) {
+
+	client := newClient(t, "2073")
+	defer client.Close()
+
+	cmd, err := client.Command("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := cmd.Stdio()
+	if err != nil {
+		t.Fatalf("stdio error: %s", err)
+	}
+
+	if _, err = stream.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 5)
+	if _, err = io.ReadFull(stream, b); err != nil || string(b) != "hello" {
+		t.Errorf("unexpected stream echo: %q, %v", b, err)
+	}
+
+	// Closing stdin ends cat, Close waits for its exit status.
+	if err = stream.Close(); err != nil {
+		t.Errorf("close error: %s", err)
+	}
+
+	if err = stream.Close(); err != nil {
+		t.Errorf("second close error: %s", err)
+	}
+}
+
+func gophOpenChannelTest(t *testing.T) {
+
+	client := newClient(t, "2074")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	channel, reqs, err := client.OpenChannelContext(ctx, "session", nil)
+	if err != nil {
+		t.Fatalf("open channel error: %s", err)
+	}
+
+	// The channel is closed with the context.
+	cancel()
+	if _, err = channel.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected closed channel, got: %v", err)
+	}
+
+	select {
+	case _, ok := <-reqs:
+		if ok {
+			t.Error("unexpected request")
+		}
+	case <-time.After(time.Second):
+		t.Error("requests not closed with the channel")
+	}
+
+	// A channel closed before its context ends its requests too.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	if channel, reqs, err = client.OpenChannelContext(ctx, "session", nil); err != nil {
+		t.Fatalf("open channel error: %s", err)
+	}
+	channel.Close()
+
+	select {
+	case <-reqs:
+	case <-time.After(time.Second):
+		t.Error("requests not closed with the channel")
+	}
+
+	if _, _, err = client.OpenChannelContext(ctx, "goph-unknown", nil); err == nil {
+		t.Error("expected unknown channel type error")
+	}
+
+	cancel()
+	if _, _, err = client.OpenChannelContext(ctx, "session", nil); err != context.Canceled {
+		t.Errorf("expected canceled open, got: %v", err)
+	}
+}
+
+func gophUploadFromURLTest(t *testing.T) {
+
+	client := newClient(t, "2075")
+	defer client.Close()
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer web.Close()
+
+	dir, err := ioutil.TempDir("", "goph-url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "dst")
+	// sha256 of "content".
+	sum := "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+
+	var last goph.Progress
+	err = client.UploadFromURL(context.Background(), web.URL+"/file", dst, goph.WithChecksum("sha256", sum), goph.WithProgress(func(p goph.Progress) {
+		last = p
+	}))
+	if err != nil {
+		t.Fatalf("upload from url error: %s", err)
+	}
+
+	if b, _ := ioutil.ReadFile(dst); string(b) != "content" {
+		t.Errorf("unexpected uploaded content: %q", b)
+	}
+
+	if last.Transferred != 7 || last.Total != 7 {
+		t.Errorf("unexpected progress: %+v", last)
+	}
+
+	// A failed download never replaces the destination or leaves a temp file.
+	err = client.UploadFromURL(context.Background(), web.URL+"/file", dst, goph.WithChecksum("sha256", strings.Repeat("0", 64)))
+	if !errors.Is(err, goph.ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got: %v", err)
+	}
+
+	if err = client.UploadFromURL(context.Background(), web.URL+"/missing", dst); err == nil {
+		t.Error("expected not found error")
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("unexpected files left: %d", len(files))
+	}
+
+	if b, _ := ioutil.ReadFile(dst); string(b) != "content" {
+		t.Errorf("destination replaced: %q", b)
+	}
+}
+
+func gophRunBinaryTest(t *testing.T) {
+
+	client := newClient(t, "2076")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "args.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$0\"\nprintf '%s\\n' \"$@\"\n"), 0644)
+
+	out, err := client.RunBinary(script, "a b", "$(echo injected)", "it's")
+	if err != nil {
+		t.Fatalf("run binary error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 4 || lines[1] != "a b" || lines[2] != "$(echo injected)" || lines[3] != "it's" {
+		t.Fatalf("args not passed literally: %q", out)
+	}
+
+	// The binary and its temp dir are removed.
+	if _, err = os.Stat(filepath.Dir(lines[0])); !os.IsNotExist(err) {
+		t.Errorf("temp dir not removed: %v", err)
+	}
+	// Windows shells run the native form of the sftp path.
+	if p := goph.NativePath("/C:/Temp/goph/args.exe"); p != `C:\Temp\goph\args.exe` {
+		t.Errorf("unexpected native path: %s", p)
+	}
+}
+
+func gophRunSelfTest(t *testing.T) {
+
+	client := newClient(t, "2077")
+	defer client.Close()
+
+	platform, err := client.RemotePlatform()
+	if err != nil || platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Fatalf("unexpected remote platform: %s, %v", platform, err)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "self.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho self \"$1\"\n"), 0644)
+
+	out, err := client.RunSelf(map[string]string{platform: script, "plan9/386": os.DevNull}, "arg")
+	if err != nil || string(out) != "self arg\n" {
+		t.Errorf("unexpected run self output: %q, %v", out, err)
+	}
+}
+
+func gophWatchTest(t *testing.T) {
+
+	client := newClient(t, "2078")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err = client.Watch(ctx, dir, 0); err == nil {
+		t.Error("expected non-positive interval error")
+	}
+
+	events, err := client.Watch(ctx, dir, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(op goph.WatchOp, name string) {
+		select {
+		case e := <-events:
+			if e.Err != nil || e.Op != op || e.Path != filepath.Join(dir, name) {
+				t.Errorf("unexpected watch event: %s %s, %v", e.Op, e.Path, e.Err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s event not received", op)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644)
+	expect(goph.WatchCreate, "a")
+
+	ioutil.WriteFile(filepath.Join(dir, "a"), []byte("abc"), 0644)
+	expect(goph.WatchModify, "a")
+
+	os.Remove(filepath.Join(dir, "a"))
+	expect(goph.WatchDelete, "a")
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected closed channel after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("channel not closed after cancel")
+	}
+
+	if _, err = client.Watch(ctx, dir, time.Second); err != context.Canceled {
+		t.Errorf("expected canceled sftp setup, got %v", err)
+	}
+}
+
+func gophEncodingTest(t *testing.T) {
+
+	client := newClient(t, "2079")
+	defer client.Close()
+
+	cmd, err := client.Command(`printf '\150\000\151\000'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Encoding = "utf-16le"
+	if out, err := cmd.Output(); err != nil || string(out) != "hi" {
+		t.Errorf("unexpected transcoded output: %q, %v", out, err)
+	}
+
+	if cmd, err = client.Command("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Encoding = "no-such-charset"
+	if _, err = cmd.Output(); err == nil {
+		t.Error("expected unknown encoding error")
+	}
+}
+
+func gophLineEndingTest(t *testing.T) {
+
+	client := newClient(t, "2080")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-eol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "unix.txt")
+	remote := filepath.Join(dir, "remote.txt")
+	ioutil.WriteFile(local, []byte("a\nb\r\nc\n"), 0644)
+
+	if err = client.Upload(local, remote, goph.WithLineEnding("\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(remote); string(b) != "a\r\nb\r\nc\r\n" {
+		t.Errorf("unexpected uploaded content: %q", b)
+	}
+
+	back := filepath.Join(dir, "back.txt")
+	if err = client.Download(remote, back, goph.WithLineEnding("\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(back); string(b) != "a\nb\nc\n" {
+		t.Errorf("unexpected downloaded content: %q", b)
+	}
+}
+
+func gophRemotePathTest(t *testing.T) {
+
+	if p := goph.RemotePath(`C:\Users\goph`); p != "/C:/Users/goph" {
+		t.Errorf("unexpected remote path: %s", p)
+	}
+	if p := goph.RemotePath("/home/goph"); p != "/home/goph" {
+		t.Errorf("unexpected remote path: %s", p)
+	}
+
+	client := newClient(t, "2081")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-remotepath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Backslashes are plain name characters on a unix server.
+	if err = client.MkdirAll(dir+`/a\b`, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, `a\b`)); err != nil || !info.IsDir() {
+		t.Errorf("expected a literal a\\b dir: %v", err)
+	}
+
+	if err = client.Rename(dir+`/a\b`, dir+`/c\d`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, `c\d`)); err != nil {
+		t.Errorf("expected renamed c\\d dir: %v", err)
+	}
+}
+
+func gophCmdTempDirTest(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "goph-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake cmd logs its args, creates the temp dir and runs /C commands.
+	log := filepath.Join(dir, "cmd.log")
+	fake := "#!/bin/sh\necho \"$*\" >> " + log + "\nif [ \"$1\" = /V:ON ]; then mktemp -d; else shift; exec \"$@\"; fi\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "cmd"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	script := filepath.Join(dir, "bin.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho ran \"$1\"\n"), 0644)
+
+	client := newClient(t, "2082")
+	defer client.Close()
+	client.Config.RemoteShell = goph.ShellCmd
+
+	out, err := client.RunBinary(script, "arg")
+	if err != nil || string(out) != "ran arg\n" {
+		t.Errorf("unexpected run binary output: %q, %v", out, err)
+	}
+
+	// The server sh strips the cmd quotes before the fake cmd sees them.
+	b, _ := ioutil.ReadFile(log)
+	if !strings.HasPrefix(string(b), "/V:ON /S /C set d=") || !strings.Contains(string(b), "mkdir !d! && echo !d!") {
+		t.Errorf("temp dir not created with delayed expansion: %q", b)
+	}
+}
+
+func gophBareClientTest(t *testing.T) {
+
+	newServer("2083")
+
+	sshClient, err := ssh.Dial("tcp", "127.0.10.10:2083", &ssh.ClientConfig{
+		User:            "melbahja",
+		Auth:            []ssh.AuthMethod{ssh.Password("123456")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client built without NewClient has no internal state.
+	client := goph.Client{Client: sshClient}
+
+	if out, err := client.Run("echo hi"); err != nil || string(out) != "hi\n" {
+		t.Errorf("unexpected run output without config: %q, %v", out, err)
+	}
+
+	if client.BytesRead() != 0 || client.BytesWritten() != 0 || client.Traffic().BytesRead != 0 {
+		t.Errorf("unexpected traffic without counter: %+v", client.Traffic())
+	}
+
+	if err = client.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+
+	if err = client.Wait(); err == nil {
+		t.Error("expected disconnect error without close tracking")
+	}
+}
+
+func gophTrafficTest(t *testing.T) {
+
+	client := newClient(t, "2084")
+	defer client.Close()
+
+	if _, err := client.Run("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if traffic := client.Traffic(); traffic.BytesRead == 0 || traffic.BytesWritten != client.BytesWritten() {
+		t.Errorf("unexpected traffic counters: %+v", traffic)
+	}
+}
+
+func gophOnCommandTest(t *testing.T) {
+
+	client := newClient(t, "2085")
+	defer client.Close()
+
+	var codes []int
+	client.Config.OnCommand = func(label string, d time.Duration, code int) {
+		codes = append(codes, code)
+	}
+
+	client.Run("true")
+	client.Run("exit 3")
+
+	if len(codes) != 2 || codes[0] != 0 || codes[1] != 3 {
+		t.Errorf("unexpected OnCommand exit codes: %v", codes)
+	}
+}
+
+func gophConfigEnvTest(t *testing.T) {
+
+	client := newClient(t, "2086")
+	defer client.Close()
+
+	client.Config.Env = map[string]string{"GOPH_TEST": "env", "GOPH_LANG": "C"}
+
+	cmd, err := client.Command(`echo "$GOPH_TEST,$GOPH_LANG"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Env = []string{"GOPH_LANG=fr_FR"}
+
+	if out, err := cmd.Output(); err != nil || string(out) != "env,fr_FR\n" {
+		t.Errorf("unexpected merged env: %q, %v", out, err)
+	}
+}
+
+func gophProbeTest(t *testing.T) {
+
+	newServer("2087")
+
+	result, err := goph.Probe("127.0.10.10:2087", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(result.ServerVersion, "SSH-2.0-") || len(result.HostKeys) != 1 {
+		t.Errorf("unexpected probe result: %s, %v", result.ServerVersion, result.HostKeys)
+	}
+
+	if _, err = goph.Probe("127.0.10.10:1", time.Second); err == nil {
+		t.Error("expected probe error on a closed port")
+	}
+}
+
+func gophRedactorTest(t *testing.T) {
+
+	client := newClient(t, "2088")
+	defer client.Close()
+
+	var labels []string
+	client.Config.Redactor = goph.NewRedactor("s3cr3t")
+	client.Config.OnCommand = func(label string, d time.Duration, code int) {
+		labels = append(labels, label)
+	}
+
+	report := client.RunAll(context.Background(), []goph.Command{{Cmd: "TOKEN=s3cr3t true"}})
+
+	if name := report.Results[0].Name; name != "TOKEN=*** true" {
+		t.Errorf("secret not redacted in report: %s", name)
+	}
+
+	if len(labels) != 1 || strings.Contains(labels[0], "s3cr3t") {
+		t.Errorf("secret not redacted in labels: %v", labels)
+	}
+
+	var exitErr *ssh.ExitError
+	if _, err := client.Run("exit 3"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("redacted command error lost its type: %v", err)
+	}
+
+	config, err := goph.NewConfig("melbahja", "s3cr3t.invalid", 22, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Redactor = client.Config.Redactor
+
+	if _, err = goph.NewClient(config); err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("secret not redacted in dial error: %v", err)
+	}
+
+	breaker := goph.NewBreaker(0, 0)
+	if _, err = breaker.NewClient(config); err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("secret not redacted in breaker dial error: %v", err)
+	}
+
+	// A host rejecting the client isn't a failover error.
+	config.Hosts = map[string]string{"s3cr3t.invalid": "127.0.10.10"}
+	config.Port = 2088
+	config.Auth = goph.Password("wrong")
+	config.ClientConfig.Auth = config.Auth
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	if _, err = breaker.NewClient(config); !errors.Is(err, goph.ErrAuthFailed) || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("secret not redacted in breaker auth error: %v", err)
+	}
+}
+
+func gophKeychainTest(t *testing.T) {
+
+	if runtime.GOOS != "linux" {
+		t.Skip("fake secret-tool needs linux")
+	}
+
+	dir, err := ioutil.TempDir("", "goph-keychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake secret-tool knowing a single secret.
+	fake := "#!/bin/sh\nif [ \"$3\" = goph ] && [ \"$5\" = melbahja ]; then echo 123456; else exit 1; fi\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	if secret, err := goph.KeychainSecret("goph", "melbahja"); err != nil || secret != "123456" {
+		t.Errorf("unexpected keychain secret: %q, %v", secret, err)
+	}
+
+	if _, err = goph.KeychainSecret("goph", "other"); err != goph.ErrSecretNotFound {
+		t.Errorf("expected secret not found, got %v", err)
+	}
+
+	auth, err := goph.KeychainPassword("goph", "melbahja")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newServer("2089")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2089, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with keychain password: %v", err)
+	}
+	client.Close()
+}
+
+func gophUploadDirSymlinkTest(t *testing.T) {
+
+	client := newClient(t, "2090")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(dir, "shared"), 0755)
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "shared", "b.txt"), []byte("b"), 0644)
+	os.Symlink("a.txt", filepath.Join(src, "link"))
+	os.Symlink("../shared", filepath.Join(src, "shared"))
+
+	links := filepath.Join(dir, "links")
+	if err = client.UploadDir(src, links); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(links, "link")); err != nil || target != "a.txt" {
+		t.Errorf("expected a recreated symlink: %q, %v", target, err)
+	}
+
+	followed := filepath.Join(dir, "followed")
+	if err = client.UploadDir(src, followed, goph.WithFollowSymlinks()); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"link": "a", "shared/b.txt": "b"} {
+		path := filepath.Join(followed, name)
+		if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("expected a regular %s: %v, %v", name, info, err)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != want {
+			t.Errorf("unexpected %s content: %q", name, b)
+		}
+	}
+
+	os.Symlink("..", filepath.Join(dir, "shared", "loop"))
+	if err = client.UploadDir(src, filepath.Join(dir, "loop"), goph.WithFollowSymlinks()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected a symlink cycle error, got %v", err)
+	}
+}
+
+func gophDirTransferTest(t *testing.T) {
+
+	client := newClient(t, "2091")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	for _, d := range []string{"logs", "cache", "sub"} {
+		os.MkdirAll(filepath.Join(src, d), 0755)
+	}
+	for name, content := range map[string]string{"a.txt": "a", "logs/x.log": "x", "cache/c": "c", "sub/d.txt": "d", "sub/e.bin": "e"} {
+		ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0644)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chmod(filepath.Join(src, "a.txt"), 0600)
+	os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime)
+	os.Chtimes(filepath.Join(src, "sub"), mtime, mtime)
+
+	remote := filepath.Join(dir, "remote")
+	if err = client.UploadDir(src, remote, goph.WithExclude("*.log", "cache"), goph.WithPreserve()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"logs/x.log", "cache"} {
+		if _, err := os.Stat(filepath.Join(remote, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be excluded: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"a.txt", "sub"} {
+		info, err := os.Stat(filepath.Join(remote, name))
+		if err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("unexpected %s times: %v, %v", name, info, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(remote, "a.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("a.txt mode not preserved: %v, %v", info, err)
+	}
+
+	local := filepath.Join(dir, "local")
+	if err = client.DownloadDir(remote, local, goph.WithInclude("*.txt"), goph.WithPreserve()); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(local, "sub", "d.txt")); err != nil || string(b) != "d" {
+		t.Errorf("unexpected downloaded sub/d.txt: %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(local, "sub", "e.bin")); !os.IsNotExist(err) {
+		t.Errorf("e.bin should not be included: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(local, "a.txt")); err != nil || info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("a.txt not preserved on download: %v, %v", info, err)
+	}
+}
+
+func gophRunJSONTest(t *testing.T) {
+
+	client := newClient(t, "2092")
+	defer client.Close()
+
+	var v struct {
+		Name  string
+		Items []int
+	}
+
+	if err := client.RunJSON(context.Background(), `echo '{"name":"goph","items":[1,2]}'; echo noise >&2`, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "goph" || len(v.Items) != 2 {
+		t.Errorf("unexpected decoded value: %+v", v)
+	}
+
+	var jsonErr *goph.JSONError
+	err := client.RunJSON(context.Background(), "echo not json; echo bad flag >&2", &v)
+	if !errors.As(err, &jsonErr) || string(jsonErr.Stderr) != "bad flag\n" {
+		t.Errorf("expected json error with stderr, got %v", err)
+	}
+
+	var exitErr *ssh.ExitError
+	if err = client.RunJSON(context.Background(), "exit 2", &v); !errors.As(err, &exitErr) {
+		t.Errorf("expected command exit error, got %v", err)
+	}
+}
+
+func gophParallelDownloadTest(t *testing.T) {
+
+	client := newClient(t, "2093")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 1<<20+123)
+	rand.Read(data)
+	remote := filepath.Join(dir, "remote.bin")
+	ioutil.WriteFile(remote, data, 0644)
+
+	sum := sha256.Sum256(data)
+
+	var last goph.Progress
+	local := filepath.Join(dir, "local.bin")
+	err = client.Download(remote, local,
+		goph.WithParallel(4, 64<<10),
+		goph.WithChecksum("sha256", hex.EncodeToString(sum[:])),
+		goph.WithProgress(func(p goph.Progress) { last = p }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(local); !bytes.Equal(b, data) {
+		t.Error("parallel download content mismatch")
+	}
+	if last.Transferred != int64(len(data)) || last.Total != int64(len(data)) {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+
+	err = client.Download(remote, local, goph.WithParallel(4, 64<<10), goph.WithChecksum("sha256", "00"))
+	if !errors.Is(err, goph.ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func gophTransferContextTest(t *testing.T) {
+
+	client := newClient(t, "2094")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-transferctx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	big := filepath.Join(dir, "big.bin")
+	ioutil.WriteFile(big, make([]byte, 16<<20), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int
+	err = client.DownloadContext(ctx, big, filepath.Join(dir, "down.bin"), goph.WithProgress(func(p goph.Progress) {
+		if calls++; calls == 1 {
+			cancel()
+		}
+	}))
+	if err != context.Canceled {
+		t.Errorf("expected canceled download, got %v", err)
+	}
+
+	if err = client.UploadContext(ctx, big, filepath.Join(dir, "up.bin")); err != context.Canceled {
+		t.Errorf("expected canceled upload, got %v", err)
+	}
+
+	var last goph.Progress
+	err = client.UploadContext(context.Background(), big, filepath.Join(dir, "up.bin"), goph.WithProgress(func(p goph.Progress) {
+		last = p
+	}))
+	if err != nil || last.Transferred != 16<<20 || last.Total != 16<<20 {
+		t.Errorf("unexpected upload result: %+v, %v", last, err)
+	}
+}
+
+func gophSCPTest(t *testing.T) {
+
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not installed")
+	}
+
+	client := newClient(t, "2095")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-scp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "local file")
+	ioutil.WriteFile(local, []byte("scp\ncontent\n"), 0640)
+
+	remote := filepath.Join(dir, "remote file")
+	if err = client.UploadSCP(local, remote); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(remote); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("unexpected uploaded file: %v, %v", info, err)
+	}
+
+	back := filepath.Join(dir, "back")
+	if err = client.DownloadSCP(remote, back, goph.WithLineEnding("\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(back); string(b) != "scp\r\ncontent\r\n" {
+		t.Errorf("unexpected downloaded content: %q", b)
+	}
+
+	if err = client.DownloadSCP(filepath.Join(dir, "missing"), back); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+}
+
+func gophTransferQueueTest(t *testing.T) {
+
+	client := newClient(t, "2096")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	ioutil.WriteFile(src, []byte("queued"), 0644)
+
+	q := client.NewTransferQueue(1)
+	q.Pause()
+
+	var transfers []*goph.Transfer
+	for i, priority := range []int{1, 5, 3, 5} {
+		tr, err := q.Upload(src, filepath.Join(dir, fmt.Sprintf("dst%d", i)), priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transfers = append(transfers, tr)
+	}
+	failed, _ := q.Download(filepath.Join(dir, "missing"), filepath.Join(dir, "local"), 0)
+
+	for _, s := range q.Status() {
+		if s.State != goph.TransferQueued {
+			t.Errorf("transfer %d: expected queued while paused, got %s", s.ID, s.State)
+		}
+	}
+
+	q.Resume()
+	for _, tr := range transfers {
+		if err := tr.Wait(); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := failed.Wait(); err == nil || failed.Status().State != goph.TransferFailed {
+		t.Errorf("expected failed download, got %v", err)
+	}
+	q.Close()
+
+	// Highest priority first, in order within a priority.
+	order := []int{1, 3, 2, 0}
+	for i := 1; i < len(order); i++ {
+		prev, cur := transfers[order[i-1]].Status(), transfers[order[i]].Status()
+		if cur.Started.Before(prev.Finished) {
+			t.Errorf("transfer %d started before transfer %d finished", cur.ID, prev.ID)
+		}
+	}
+
+	for i := range transfers {
+		if b, _ := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("dst%d", i))); string(b) != "queued" {
+			t.Errorf("dst%d: unexpected content %q", i, b)
+		}
+	}
+
+	if _, err := q.Upload(src, filepath.Join(dir, "late"), 0); err != goph.ErrQueueClosed {
+		t.Errorf("expected ErrQueueClosed, got %v", err)
+	}
+}
+
+func gophDiskUsageTest(t *testing.T) {
+
+	client := newClient(t, "2097")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 23), 0644)
+	os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "link"))
+
+	size, err := client.DirSize(dir)
+	if err != nil || size != 123 {
+		t.Errorf("expected dir size 123, got %d, %v", size, err)
+	}
+
+	check := func(name string) {
+
+		usage, err := client.DiskUsage(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if usage.Total == 0 || usage.Used > usage.Total || usage.Available > usage.Total {
+			t.Errorf("%s: unexpected usage %+v", name, usage)
+		}
+	}
+
+	check("statvfs")
+
+	// Without the extension the usage comes from df.
+	sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com")
+	defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+
+	check("df")
+
+	if _, err = client.DiskUsage(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing path")
+	}
+}
+
+func gophFromSSHConfigTest(t *testing.T) {
+
+	home, err := ioutil.TempDir("", "goph-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	system := goph.SystemSSHConfigPath
+	defer func() { goph.SystemSSHConfigPath = system }()
+	goph.SystemSSHConfigPath = filepath.Join(home, "missing")
+
+	signer, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey = signer.PublicKey()
+	defer func() { authorizedKey = nil }()
+
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	ioutil.WriteFile(filepath.Join(home, ".ssh", "key"), privateBytes, 0600)
+	ioutil.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), nil, 0600)
+
+	pub := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	ioutil.WriteFile(filepath.Join(home, ".ssh", "hosts"), []byte("[127.0.10.10]:2098 "+pub+"[127.0.10.10]:2099 "+pub), 0600)
+
+	ioutil.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(`
+Host target
+	HostName 127.0.10.10
+	Port 2098
+	ConnectTimeout 5
+	ProxyJump bastion
+
+Host bastion
+	HostName 127.0.10.10
+	Port 2099
+
+Host loop
+	ProxyJump loop
+
+Host *
+	User melbahja
+	Port 2200
+	IdentityFile %d/.ssh/key
+	UserKnownHostsFile ~/.ssh/hosts
+`), 0600)
+
+	newServer("2098")
+	newServer("2099")
+
+	config, err := goph.FromSSHConfig("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Addr != "127.0.10.10" || config.Port != 2098 || config.ClientConfig.User != "melbahja" || config.Timeout != 5*time.Second {
+		t.Errorf("unexpected config: %s:%d user %s timeout %s", config.Addr, config.Port, config.ClientConfig.User, config.Timeout)
+	}
+	if config.Jump == nil || config.Jump.Port != 2099 || config.Jump.Timeout != goph.DefaultTimeout {
+		t.Fatalf("unexpected jump config: %+v", config.Jump)
+	}
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	if out, err := client.Run("echo config"); err != nil || string(out) != "config\n" {
+		t.Errorf("unexpected run output: %q, %v", out, err)
+	}
+
+	sshConfig, err := goph.ParseSSHConfig(strings.NewReader("Host jumps\n\tProxyJump admin@first:2222,[::1]:2223\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = sshConfig.Config("jumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j := config.Jump; j == nil || j.Addr != "::1" || j.Port != 2223 || j.Jump == nil || j.Jump.Addr != "first" || j.Jump.Port != 2222 || j.Jump.ClientConfig.User != "admin" {
+		t.Errorf("unexpected jump chain: %+v", config.Jump)
+	}
+
+	if _, err = goph.FromSSHConfig("loop"); err == nil || !strings.Contains(err.Error(), "too many jump hosts") {
+		t.Errorf("expected jump loop error, got %v", err)
+	}
+}
+
+func gophKeyboardInteractiveChallengeTest(t *testing.T) {
+
+	// The server only advertises keyboard interactive and asks a password
+	// then a one time code.
+	newServerConfig("2100", func(config *ssh.ServerConfig) {
+		config.PasswordCallback, config.PublicKeyCallback = nil, nil
+		config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("login", "2FA", []string{"Password: ", "Code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 2 || answers[0] != "123456" || answers[1] != "424242" {
+				return nil, fmt.Errorf("challenge rejected for %q", c.User())
+			}
+			return nil, nil
+		}
+	})
+
+	connect := func(auth goph.Auth) error {
+
+		config, err := goph.NewConfig("melbahja", "127.0.10.10", 2100, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+		client, err := goph.NewClient(config)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	if err := connect(goph.Password("123456")); err == nil {
+		t.Error("password auth should fail against a keyboard interactive only server")
+	}
+
+	var prompts []string
+	err := connect(goph.KeyboardInteractiveChallenge(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		prompts = append(prompts, name, instruction)
+		prompts = append(prompts, questions...)
+		return []string{"123456", "424242"}, nil
+	}))
+	if err != nil {
+		t.Fatalf("challenge auth error: %s", err)
+	}
+
+	if strings.Join(prompts, "|") != "login|2FA|Password: |Code: " {
+		t.Errorf("unexpected prompts: %q", prompts)
+	}
+}
+
+func gophDiagnosticsTest(t *testing.T) {
+
+	client := newClient(t, "2101")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-diag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(logFile, []byte("old line\nnew line\n"), 0644)
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	manifest, err := client.CollectDiagnostics(context.Background(), goph.DiagnosticsSpec{
+		Output:      bundle,
+		Files:       []string{logFile, filepath.Join(dir, "missing.log")},
+		Commands:    []goph.DiagnosticsCommand{{Name: "hello", Cmd: "echo hello"}, {Name: "fail", Cmd: "echo oops; exit 3"}},
+		SystemInfo:  true,
+		MaxFileSize: 9,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+
+	logName := path.Join("files", strings.TrimLeft(filepath.ToSlash(logFile), "/"))
+	if files[logName] != "new line\n" {
+		t.Errorf("expected the end of the log, got %q", files[logName])
+	}
+	if files["commands/hello.txt"] != "hello\n" || files["commands/fail.txt"] != "oops\n" {
+		t.Errorf("unexpected command outputs: %q, %q", files["commands/hello.txt"], files["commands/fail.txt"])
+	}
+	if files["system/uname.txt"] == "" {
+		t.Errorf("unexpected uname output: %q", files["system/uname.txt"])
+	}
+
+	var archived goph.DiagnosticsManifest
+	if err = json.Unmarshal([]byte(files["manifest.json"]), &archived); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(map[string]goph.DiagnosticsEntry)
+	for _, e := range archived.Entries {
+		entries[e.Name] = e
+	}
+	if len(archived.Entries) != len(manifest.Entries) || archived.Host != "127.0.10.10" {
+		t.Errorf("archived manifest differs: %+v", archived)
+	}
+	if e := entries[logName]; !e.Truncated || e.Size != 9 {
+		t.Errorf("unexpected log entry: %+v", e)
+	}
+	if e := entries[path.Join("files", strings.TrimLeft(filepath.ToSlash(filepath.Join(dir, "missing.log")), "/"))]; e.Error == "" {
+		t.Errorf("missing file should be recorded with an error: %+v", e)
+	}
+	if e := entries["commands/fail.txt"]; e.ExitCode != 3 || e.Error == "" {
+		t.Errorf("unexpected failed command entry: %+v", e)
+	}
+}
+
+func gophRunEscalateTest(t *testing.T) {
+
+	client := newClient(t, "2102")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-escalate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake sudo running its command as "root".
+	fake := "#!/bin/sh\n[ \"$1\" = -n ] && shift\nGOPH_ROOT=1 exec \"$@\"\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	privileged := `if [ -z "$GOPH_ROOT" ]; then echo "cat: /etc/shadow: Permission denied" >&2; exit 1; fi; echo root`
+
+	// Without Escalate the denied command isn't retried.
+	res, err := client.RunEscalate(context.Background(), privileged)
+	if err == nil || res.Escalated || !goph.IsPermissionError(res.Output, err) {
+		t.Errorf("expected a permission error without retry, got %+v, %v", res, err)
+	}
+
+	client.Config.Escalate = "sudo -n"
+	defer func() { client.Config.Escalate = "" }()
+
+	res, err = client.RunEscalate(context.Background(), privileged)
+	if err != nil || !res.Escalated || string(res.Output) != "root\n" || !strings.Contains(string(res.Denied), "Permission denied") {
+		t.Errorf("expected an escalated run, got %+v, %v", res, err)
+	}
+
+	// Other failures and successes run once.
+	res, err = client.RunEscalate(context.Background(), "echo broken; exit 2")
+	if err == nil || res.Escalated {
+		t.Errorf("expected a failure without retry, got %+v, %v", res, err)
+	}
+
+	res, err = client.RunEscalate(context.Background(), "echo user")
+	if err != nil || res.Escalated || string(res.Output) != "user\n" {
+		t.Errorf("unexpected unprivileged run: %+v, %v", res, err)
+	}
+}
+
+func gophCertificateAuthTest(t *testing.T) {
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signer, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"melbahja"},
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	key, certFile := filepath.Join(dir, "id"), filepath.Join(dir, "id-cert.pub")
+	ioutil.WriteFile(key, privateBytes, 0600)
+	ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "id.pub"), ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644)
+
+	// The server only trusts the certificate, not the plain key.
+	authorizedKey = cert
+	defer func() { authorizedKey = nil }()
+
+	newServer("2103")
+
+	auth, err := goph.Certificate(certFile, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2103, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("connect with certificate error: %s", err)
+	}
+	client.Close()
+
+	if _, err = goph.Certificate(filepath.Join(dir, "id.pub"), key, ""); err == nil || !strings.Contains(err.Error(), "not a certificate") {
+		t.Errorf("expected not a certificate error, got %v", err)
+	}
+
+	other, err := goph.EphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Key = other.PublicKey()
+	cert.SignCert(rand.Reader, ca)
+	ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644)
+
+	if _, err = goph.Certificate(certFile, key, ""); err == nil {
+		t.Error("expected an error for a certificate of another key")
+	}
+}
+
+func gophCertCheckerTest(t *testing.T) {
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             hostKey.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.10.10"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newServerConfig("2104", func(config *ssh.ServerConfig) {
+		config.AddHostKey(certSigner)
+	})
+	newServer("2105")
+
+	connect := func(port uint, callback ssh.HostKeyCallback) error {
+
+		config, err := goph.NewConfig("melbahja", "127.0.10.10", port, goph.Password("123456"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClientConfig.HostKeyCallback = callback
+
+		client, err := goph.NewClient(config)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ssh.NewSignerFromKey(otherKey)
+
+	if err = connect(2104, goph.CertCheckerCallback(other.PublicKey(), ca.PublicKey())); err != nil {
+		t.Errorf("host certificate should be trusted: %s", err)
+	}
+
+	if err = connect(2104, goph.CertCheckerCallback(other.PublicKey())); err == nil {
+		t.Error("host certificate of an unknown ca should be rejected")
+	}
+
+	if err = connect(2105, goph.CertCheckerCallback(ca.PublicKey())); err == nil {
+		t.Error("plain host key should be rejected")
+	}
+}
+
+func gophSudoConfigTest(t *testing.T) {
+
+	client := newClient(t, "2106")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-sudo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fake escalation tools running their command as GOPH_USER, sudo wants
+	// the password s3cret unless the nopasswd file exists.
+	nopasswd := filepath.Join(dir, "nopasswd")
+	fakes := map[string]string{
+		"sudo": `while [ $# -gt 0 ]; do case "$1" in -n) shift;; -S) s=1; shift;; -p|-u) [ "$1" = -u ] && u=$2; shift 2;; *) break;; esac; done
+if [ -n "$s" ]; then read -r p; [ "$p" = s3cret ] || { echo "Sorry, try again." >&2; exit 1; }
+elif [ ! -e ` + nopasswd + ` ]; then echo "sudo: a password is required" >&2; exit 1; fi
+GOPH_USER=${u:-root} exec "$@"`,
+		"doas": `while [ $# -gt 0 ]; do case "$1" in -n) shift;; -u) u=$2; shift 2;; *) break;; esac; done
+GOPH_USER=doas-${u:-root} exec "$@"`,
+		"su": `GOPH_USER=su-$1 exec sh -c "$3"`,
+	}
+	for name, fake := range fakes {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+fake+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	password := func(ctx context.Context) ([]byte, error) {
+		return []byte("s3cret"), nil
+	}
+
+	defer func() { client.Config.Sudo = nil }()
+
+	for _, tc := range []struct {
+		sudo *goph.SudoConfig
+		want string
+	}{
+		{&goph.SudoConfig{Password: password}, "root"},
+		{&goph.SudoConfig{Password: password, User: "postgres"}, "postgres"},
+		{&goph.SudoConfig{Method: goph.MethodDoas, NonInteractive: true}, "doas-root"},
+		{&goph.SudoConfig{Method: goph.MethodSu, User: "app"}, "su-app"},
+	} {
+		client.Config.Sudo = tc.sudo
+		if out, err := client.RunSudo(context.Background(), `echo "$GOPH_USER"`); err != nil || string(out) != tc.want+"\n" {
+			t.Errorf("%s: unexpected output %q, %v", tc.sudo.Method, out, err)
+		}
+	}
+
+	client.Config.Sudo = &goph.SudoConfig{Password: func(ctx context.Context) ([]byte, error) { return []byte("wrong"), nil }}
+	if _, err = client.RunSudo(context.Background(), "true"); err == nil {
+		t.Error("expected a wrong password error")
+	}
+
+	// RunEscalate retries with the sudo config.
+	client.Config.Sudo = &goph.SudoConfig{Password: password}
+	res, err := client.RunEscalate(context.Background(), `[ -n "$GOPH_USER" ] || { echo "Permission denied" >&2; exit 1; }; echo ok`)
+	if err != nil || !res.Escalated || string(res.Output) != "ok\n" {
+		t.Errorf("unexpected escalated run: %+v, %v", res, err)
+	}
+
+	// EditFile falls back to sudo when the sftp server denies the write,
+	// with a password then without once sudo no longer asks for it.
+	conf := filepath.Join(dir, "app.conf")
+	ioutil.WriteFile(conf, []byte("a=1\n"), 0640)
+
+	atomic.StoreInt32(&sftpReadOnly, 1)
+	defer atomic.StoreInt32(&sftpReadOnly, 0)
+
+	replace := func(old, new string) func([]byte) ([]byte, error) {
+		return func(b []byte) ([]byte, error) {
+			return bytes.Replace(b, []byte(old), []byte(new), 1), nil
+		}
+	}
+
+	if err = client.EditFile(conf, replace("a=1", "a=2"), goph.WithBackup(".bak")); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(nopasswd, nil, 0644)
+	if err = client.EditFile(conf, replace("a=2", "a=3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(conf); string(b) != "a=3\n" {
+		t.Errorf("unexpected edited file: %q", b)
+	}
+	if b, _ := ioutil.ReadFile(conf + ".bak"); string(b) != "a=1\n" {
+		t.Errorf("unexpected backup: %q", b)
+	}
+	if info, _ := os.Stat(conf); info == nil || info.Mode().Perm() != 0640 {
+		t.Errorf("edited file mode should be kept: %v", info)
+	}
+
+	// Without a sudo config the denial is returned.
+	client.Config.Sudo = nil
+	if err = client.EditFile(conf, replace("a=3", "a=4")); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
+
+func gophCrontabTest(t *testing.T) {
+
+	client := newClient(t, "2107")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-crontab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake crontab keeping a file per user.
+	fake := `#!/bin/sh
+u=me; [ "$1" = -u ] && { u=$2; shift 2; }
+f=` + dir + `/$u.cron
+case "$1" in
+-l) [ -e "$f" ] || { echo "no crontab for $u" >&2; exit 1; }; cat "$f";;
+-) cat > "$f";;
+esac
+`
+	if err = ioutil.WriteFile(filepath.Join(dir, "crontab"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	cron := client.Crontab()
+	if entries, err := cron.List(); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty crontab, got %v, %v", entries, err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "me.cron"), []byte("# backups\nMAILTO=ops@example.com\n0 3 * * * /usr/bin/backup --full\n"), 0644)
+
+	backup := goph.CronEntry{Schedule: "0 3 * * *", Command: "/usr/bin/backup --full"}
+	reboot := goph.CronEntry{Schedule: "@reboot", Command: "echo up  >> /tmp/boot.log"}
+
+	for _, e := range []goph.CronEntry{backup, reboot, reboot} {
+		if err = cron.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := cron.List()
+	if err != nil || len(entries) != 2 || entries[0] != backup || entries[1] != reboot {
+		t.Errorf("unexpected entries: %v, %v", entries, err)
+	}
+
+	if n, err := cron.Remove(backup.Command); n != 1 || err != nil {
+		t.Errorf("unexpected remove result: %d, %v", n, err)
+	}
+
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "me.cron")); string(b) != "# backups\nMAILTO=ops@example.com\n@reboot echo up  >> /tmp/boot.log\n" {
+		t.Errorf("unexpected crontab: %q", b)
+	}
+
+	for _, e := range []goph.CronEntry{{Schedule: "* * *", Command: "true"}, {Schedule: "@daily", Command: "a\nb"}, {Schedule: "@daily"}} {
+		if err = cron.Add(e); err == nil {
+			t.Errorf("expected invalid entry %q to be rejected", e)
+		}
+	}
+
+	other := client.Crontab()
+	other.User = "www-data"
+	if err = other.Add(backup); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "www-data.cron")); string(b) != backup.String()+"\n" {
+		t.Errorf("unexpected user crontab: %q", b)
+	}
+}
+
+func gophTOFUKnownHostsTest(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "goph-tofu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newServer("2108")
+
+	connect := func(callback ssh.HostKeyCallback) error {
+
+		config, err := goph.NewConfig("melbahja", "127.0.10.10", 2108, goph.Password("123456"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClientConfig.HostKeyCallback = callback
+
+		client, err := goph.NewClient(config)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	file := filepath.Join(dir, "ssh", "known_hosts")
+
+	var confirms int
+	callback := goph.TOFUKnownHostsConfirm(file, func(host string, remote net.Addr, key ssh.PublicKey) bool {
+		confirms++
+		return true
+	})
+
+	for i := 0; i < 2; i++ {
+		if err = connect(callback); err != nil {
+			t.Fatalf("connect %d: %s", i, err)
+		}
+	}
+	if confirms != 1 {
+		t.Errorf("the unknown key should be confirmed once, got %d", confirms)
+	}
+
+	if b, _ := ioutil.ReadFile(file); bytes.Count(b, []byte("\n")) != 1 || !bytes.HasPrefix(b, []byte("[127.0.10.10]:2108 ")) {
+		t.Errorf("unexpected known hosts: %q", b)
+	}
+
+	rejected := goph.TOFUKnownHostsConfirm(filepath.Join(dir, "rejected"), func(host string, remote net.Addr, key ssh.PublicKey) bool {
+		return false
+	})
+	if err = connect(rejected); !errors.Is(err, goph.ErrHostKeyRejected) {
+		t.Errorf("expected a rejected host key, got %v", err)
+	}
+
+	// Once known a changed key is refused.
+	other, err := goph.EphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := filepath.Join(dir, "changed")
+	ioutil.WriteFile(changed, []byte("[127.0.10.10]:2108 "+string(ssh.MarshalAuthorizedKey(other.PublicKey()))), 0600)
+
+	if err = connect(goph.TOFUKnownHosts(changed)); !errors.Is(err, goph.ErrHostKeyMismatch) {
+		t.Errorf("expected a host key mismatch, got %v", err)
+	}
+}
+
+func gophKeepAliveTest(t *testing.T) {
+
+	newServer("2109")
+
+	// A proxy that silently drops the traffic once blackhole is set, like a
+	// NAT forgetting the connection.
+	ln, err := net.Listen("tcp", "127.0.10.10:2110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var blackhole int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", "127.0.10.10:2109")
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			pipe := func(dst, src net.Conn) {
+				b := make([]byte, 32*1024)
+				for {
+					n, err := src.Read(b)
+					if err != nil {
+						return
+					}
+					if atomic.LoadInt32(&blackhole) == 0 {
+						dst.Write(b[:n])
+					}
+				}
+			}
+			go pipe(conn, upstream)
+			go pipe(upstream, conn)
+		}
+	}()
+
+	connect := func(port uint) *goph.Client {
+
+		config, err := goph.NewConfig("melbahja", "127.0.10.10", port, goph.Password("123456"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		config.KeepAliveInterval = 50 * time.Millisecond
+		config.KeepAliveCountMax = 2
+
+		client, err := goph.NewClient(config)
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		return client
+	}
+
+	// Answered keepalives keep the connection open.
+	healthy := connect(2109)
+	defer healthy.Close()
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err = healthy.Run("true"); err != nil {
+		t.Errorf("healthy connection should stay usable: %s", err)
+	}
+
+	dead := connect(2110)
+	defer dead.Close()
+
+	atomic.StoreInt32(&blackhole, 1)
+
+	waited := make(chan error, 1)
+	go func() { waited <- dead.Wait() }()
+
+	select {
+	case err = <-waited:
+		var disconnect *goph.DisconnectError
+		if !errors.As(err, &disconnect) || disconnect.Reason != goph.DisconnectKeepalive || !errors.Is(err, goph.ErrKeepAliveTimeout) {
+			t.Errorf("expected a keepalive disconnect, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead peer not detected")
+	}
+}
+
+func gophUserManagementTest(t *testing.T) {
+
+	client := newClient(t, "2111")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeAccountTools(t, dir)()
+
+	ctx := context.Background()
+
+	check := func(name string, changed bool, err error, want bool) {
+		t.Helper()
+		if err != nil || changed != want {
+			t.Errorf("%s: got %v, %v, want %v", name, changed, err, want)
+		}
+	}
+
+	changed, err := client.CreateGroup(ctx, "deploy")
+	check("create group", changed, err, true)
+	changed, err = client.CreateGroup(ctx, "deploy")
+	check("create existing group", changed, err, false)
+	changed, err = client.CreateGroup(ctx, "docker")
+	check("create docker group", changed, err, true)
+
+	alice := goph.UserSpec{Name: "alice", Shell: "/bin/bash", Groups: []string{"deploy"}}
+	changed, err = client.CreateUser(ctx, alice)
+	check("create user", changed, err, true)
+	changed, err = client.CreateUser(ctx, alice)
+	check("create existing user", changed, err, false)
+
+	if ok, err := client.InGroup(ctx, "alice", "deploy"); !ok || err != nil {
+		t.Errorf("alice should be in deploy: %v, %v", ok, err)
+	}
+
+	changed, err = client.AddToGroup(ctx, "alice", "docker")
+	check("add to group", changed, err, true)
+	changed, err = client.AddToGroup(ctx, "alice", "docker")
+	check("add to group again", changed, err, false)
+	changed, err = client.RemoveFromGroup(ctx, "alice", "deploy")
+	check("remove from group", changed, err, true)
+	changed, err = client.RemoveFromGroup(ctx, "alice", "deploy")
+	check("remove from group again", changed, err, false)
+
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "group")); string(b) != "root:x:0:\ndeploy:x:1000:\ndocker:x:1000:alice\n" {
+		t.Errorf("unexpected groups: %q", b)
+	}
+
+	if _, err = client.InGroup(ctx, "bob", "deploy"); err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("expected a no such user error, got %v", err)
+	}
+
+	signer, err := goph.EphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " alice@laptop"
+
+	changed, err = client.SetAuthorizedKeys(ctx, "alice", []string{key})
+	check("set authorized keys", changed, err, true)
+	changed, err = client.SetAuthorizedKeys(ctx, "alice", []string{key})
+	check("set same authorized keys", changed, err, false)
+
+	sshDir := filepath.Join(dir, "home", "alice", ".ssh")
+	if b, _ := ioutil.ReadFile(filepath.Join(sshDir, "authorized_keys")); string(b) != key+"\n" {
+		t.Errorf("unexpected authorized keys: %q", b)
+	}
+	if info, err := os.Stat(filepath.Join(sshDir, "authorized_keys")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected authorized keys mode: %v, %v", info, err)
+	}
+	if info, err := os.Stat(sshDir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("unexpected .ssh mode: %v, %v", info, err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "chown.log")); !strings.HasPrefix(string(b), "alice: "+sshDir+"\n") {
+		t.Errorf("unexpected chown calls: %q", b)
+	}
+
+	if _, err = client.SetAuthorizedKeys(ctx, "alice", []string{"not a key"}); err == nil {
+		t.Error("expected an invalid key error")
+	}
+}
+
+// fakeAccountTools puts on PATH fake account tools editing the passwd and
+// group files of dir, the returned func restores PATH.
+func fakeAccountTools(t *testing.T, dir string) func() {
+
+	// Fake account tools editing passwd and group files of dir.
+	fakes := map[string]string{
+		"id": `case "$1" in
+-u) grep "^$2:" D/passwd | cut -d: -f3 | grep . || exit 1;;
+-nG) grep -q "^$2:" D/passwd || { echo "id: '$2': no such user" >&2; exit 1; }
+	awk -F: -v u="$2" '{ n = split($4, m, ","); for (i = 1; i <= n; i++) if (m[i] == u) printf "%s ", $1 } END { print "" }' D/group;;
+esac`,
+		"getent": `grep "^$2:" D/$1 || exit 2`,
+		"useradd": `home= shell=/bin/sh groups=
+while [ $# -gt 1 ]; do case "$1" in -d) home=$2; shift;; -s) shell=$2; shift;; -G) groups=$2; shift;; -u) shift;; esac; shift; done
+echo "$1:x:1000:1000::${home:-D/home/$1}:$shell" >> D/passwd
+mkdir -p "${home:-D/home/$1}"
+for g in $(echo "$groups" | tr , ' '); do usermod -aG "$g" "$1"; done`,
+		"groupadd": `echo "$1:x:1000:" >> D/group`,
+		"usermod":  `sed -i -e "/^$2:/{s/:\$/:$3/;t" -e "s/\$/,$3/}" D/group`,
+		"gpasswd":  `sed -i -E "/^$3:/{s/([:,])$2(,|\$)/\1\2/;s/,\$//;s/:,/:/}" D/group`,
+		"chown":    `echo "$@" >> D/chown.log`,
+	}
+	for name, fake := range fakes {
+		fake = strings.Replace(fake, "D/", dir+"/", -1)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+fake+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "passwd"), []byte("root:x:0:0::/root:/bin/sh\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "group"), []byte("root:x:0:\n"), 0644)
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() { os.Setenv("PATH", path) }
+}
+
+func gophAuthorizedKeysTest(t *testing.T) {
+
+	client := newClient(t, "2112")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-authorized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeAccountTools(t, dir)()
+
+	home := filepath.Join(dir, "melbahja")
+	ioutil.WriteFile(filepath.Join(dir, "passwd"), []byte("melbahja:x:1000:1000::"+home+":/bin/sh\n"), 0644)
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0755)
+
+	file := filepath.Join(home, ".ssh", "authorized_keys")
+	ioutil.WriteFile(file, []byte("# managed by goph"), 0644)
+
+	var keys []ssh.PublicKey
+	for i := 0; i < 2; i++ {
+		signer, err := goph.EphemeralKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, signer.PublicKey())
+	}
+	line := func(i int) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(keys[i])))
+	}
+
+	ctx := context.Background()
+	authorized := client.AuthorizedKeys("")
+
+	for _, tc := range []struct {
+		line string
+		want bool
+	}{
+		{line(0) + " old@laptop", true},
+		{`from="10.0.0.0/8",no-pty ` + line(1) + " ci", true},
+		{line(0) + " other comment", false},
+	} {
+		if added, err := authorized.Add(ctx, tc.line); err != nil || added != tc.want {
+			t.Errorf("add %q: got %v, %v, want %v", tc.line, added, err, tc.want)
+		}
+	}
+
+	list, err := authorized.List(ctx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("unexpected keys: %v, %v", list, err)
+	}
+	if list[0].Comment != "old@laptop" || len(list[1].Options) != 2 || list[1].Comment != "ci" {
+		t.Errorf("unexpected parsed keys: %+v", list)
+	}
+
+	if removed, err := authorized.Remove(ctx, keys[0]); !removed || err != nil {
+		t.Errorf("unexpected remove result: %v, %v", removed, err)
+	}
+	if removed, err := authorized.Remove(ctx, keys[0]); removed || err != nil {
+		t.Errorf("unexpected second remove result: %v, %v", removed, err)
+	}
+
+	if b, _ := ioutil.ReadFile(file); string(b) != "# managed by goph\n"+`from="10.0.0.0/8",no-pty `+line(1)+" ci\n" {
+		t.Errorf("unexpected authorized keys: %q", b)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected authorized keys mode: %v, %v", info, err)
+	}
+
+	if _, err = authorized.Add(ctx, "ssh-rsa garbage"); err == nil {
+		t.Error("expected an invalid key error")
+	}
+}
+
+func gophResilientClientTest(t *testing.T) {
+
+	newServer("2113")
+
+	// A proxy whose connections can be dropped, and that can refuse new ones.
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+		down  bool
+	)
+	ln, err := net.Listen("tcp", "127.0.10.10:2114")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			refuse := down
+			mu.Unlock()
+			upstream, err := net.Dial("tcp", "127.0.10.10:2113")
+			if err != nil || refuse {
+				conn.Close()
+				continue
+			}
+			mu.Lock()
+			conns = append(conns, conn, upstream)
+			mu.Unlock()
+			go func() { io.Copy(upstream, conn); upstream.Close() }()
+			go func() { io.Copy(conn, upstream); conn.Close() }()
+		}
+	}()
+
+	drop := func(refuse bool) {
+		mu.Lock()
+		down = refuse
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+		mu.Unlock()
+	}
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2114, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+
+	reconnects := make(chan int, 10)
+	client, err := goph.NewResilientClient(config, goph.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		OnReconnect: func(c *goph.Client, attempts int) {
+			reconnects <- attempts
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if out, err := client.Run("echo one"); err != nil || string(out) != "one\n" {
+		t.Fatalf("unexpected run: %q, %v", out, err)
+	}
+
+	// A lost connection is dialed again transparently.
+	drop(false)
+	if out, err := client.Run("echo two"); err != nil || string(out) != "two\n" {
+		t.Fatalf("unexpected run after connection loss: %q, %v", out, err)
+	}
+
+	select {
+	case n := <-reconnects:
+		if n != 1 {
+			t.Errorf("expected a reconnection at the first attempt, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReconnect not called")
+	}
+
+	// Once the attempts are exhausted the error is returned.
+	drop(true)
+	time.Sleep(100 * time.Millisecond)
+	if _, err = client.Run("echo three"); err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected exhausted attempts, got %v", err)
+	}
+
+	client.Close()
+	if _, err = client.Client(); err != goph.ErrResilientClosed {
+		t.Errorf("expected ErrResilientClosed, got %v", err)
+	}
+}
+
+func gophNewClientContextTest(t *testing.T) {
+
+	// A listener that never speaks ssh hangs the handshake.
+	ln, err := net.Listen("tcp", "127.0.10.10:2115")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	newServer("2116")
+
+	config := func(port uint) *goph.Config {
+		config, err := goph.NewConfig("melbahja", "127.0.10.10", port, goph.Password("123456"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		config.Timeout, config.ClientConfig.Timeout = 0, 0
+		return config
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err = goph.NewClientContext(ctx, config(2115)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled handshake, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancel took %s", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = goph.NewClientContext(ctx, config(2115)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	// A canceled context doesn't fail over to the other addresses.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := config(2115)
+	c.Addrs = []string{"127.0.10.10:2116"}
+	if _, err = goph.NewClientContext(canceled, c); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled dial, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	client, err := goph.NewClientContext(ctx, config(2116))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The context only bounds the connection setup.
+	cancel()
+	if _, err = client.Run("true"); err != nil {
+		t.Errorf("connection should outlive its dial context: %s", err)
+	}
+}
+
+func gophInstallPackagesTest(t *testing.T) {
+
+	client := newClient(t, "2117")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fakes := map[string]string{
+		"apt-get":    `echo "$@" >> ` + dir + `/apt.log; echo "DEBIAN_FRONTEND=$DEBIAN_FRONTEND"`,
+		"dpkg-query": `[ "$3" = curl ] && echo "install ok installed" || exit 1`,
+	}
+	for name, fake := range fakes {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+fake+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	facts, err := client.Facts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts.OS != runtime.GOOS || facts.Arch != runtime.GOARCH || facts.PackageManager != "apt-get" {
+		t.Fatalf("unexpected facts: %+v", facts)
+	}
+
+	res, err := client.InstallPackages(context.Background(), "curl", "jq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Manager != "apt-get" || fmt.Sprint(res.Present) != "[curl]" || fmt.Sprint(res.Installed) != "[jq]" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if !strings.Contains(string(res.Output), "DEBIAN_FRONTEND=noninteractive") {
+		t.Fatalf("install is interactive: %s", res.Output)
+	}
+
+	log, _ := ioutil.ReadFile(filepath.Join(dir, "apt.log"))
+	if string(log) != "install -y -q jq\n" {
+		t.Fatalf("unexpected apt-get call: %q", log)
+	}
+
+	if res, err = client.InstallPackages(context.Background(), "curl"); err != nil || len(res.Installed) != 0 {
+		t.Fatalf("expected nothing to install, got %+v %v", res, err)
+	}
+	if _, err = client.InstallPackages(context.Background(), "-o=evil"); err == nil {
+		t.Fatal("expected an invalid package name error")
+	}
+}
+
+func gophRebootTest(t *testing.T) {
+
+	// The host is a proxy to a server with another host key after reboot.
+	other, err := goph.EphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer("2118")
+	newServerConfig("2119", func(config *ssh.ServerConfig) {
+		*config = ssh.ServerConfig{PasswordCallback: config.PasswordCallback}
+		config.AddHostKey(other)
+	})
+
+	dir, err := ioutil.TempDir("", "goph-reboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "rebooted")
+	ioutil.WriteFile(filepath.Join(dir, "reboot"), []byte("#!/bin/sh\ntouch "+marker+"\nsleep 5\n"), 0755)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	var (
+		mu      sync.Mutex
+		conns   []net.Conn
+		backend = "127.0.10.10:2118"
+	)
+	proxy := func(ln net.Listener) {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			upstream, err := net.Dial("tcp", backend)
+			conns = append(conns, conn)
+			mu.Unlock()
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() { io.Copy(upstream, conn); upstream.Close() }()
+			go func() { io.Copy(conn, upstream); conn.Close() }()
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.10.10:2120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy(ln)
+
+	// Going down closes the connections and refuses new ones for a while.
+	go func() {
+		for {
+			if _, err := os.Stat(marker); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		backend = "127.0.10.10:2119"
+		mu.Unlock()
+		time.Sleep(300 * time.Millisecond)
+		if ln, err := net.Listen("tcp", "127.0.10.10:2120"); err == nil {
+			go proxy(ln)
+			defer ln.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	defer func(interval time.Duration) { goph.RebootPollInterval = interval }(goph.RebootPollInterval)
+	goph.RebootPollInterval = 100 * time.Millisecond
+
+	private, _ := ssh.ParsePrivateKey(privateBytes)
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2120, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.FixedHostKey(private.PublicKey())
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	rebooted, err := client.Reboot(context.Background(), 3*time.Second, goph.WithHostKeyCallback(ssh.FixedHostKey(other.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rebooted.Close()
+
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("reconnected before the host was back: %s", d)
+	}
+	if out, err := rebooted.Run("echo up"); err != nil || string(out) != "up\n" {
+		t.Errorf("unexpected output of the rebooted host: %q %v", out, err)
+	}
+	if _, err = client.Run("echo up"); err == nil {
+		t.Error("expected the old client to be closed")
+	}
+}
+
+func gophCmdStartWaitTest(t *testing.T) {
+
+	client := newClient(t, "2121")
+	defer client.Close()
+
+	cmd, err := client.Command("tr", "a-z", "A-Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "streamed\n")
+	stdin.Close()
+	if out, _ := ioutil.ReadAll(stdout); string(out) != "STREAMED\n" {
+		t.Errorf("unexpected stdout: %q", out)
+	}
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("wait error: %v", err)
+	}
+
+	// Exit status and the start of stderr.
+	cmd, _ = client.Command("sh", "-c", "echo out; echo oops >&2; exit 4")
+	out, err := cmd.Output()
+	var exitErr *goph.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 4 || !exitErr.Exited() || string(exitErr.Stderr) != "oops\n" || string(out) != "out\n" {
+		t.Fatalf("unexpected output error: %q %#v", out, err)
+	}
+	var sshErr *ssh.ExitError
+	if !errors.As(err, &sshErr) || sshErr.ExitStatus() != 4 {
+		t.Errorf("expected the session exit error, got %v", err)
+	}
+
+	// A killed command reports its signal.
+	cmd, _ = client.Command("kill -KILL $$")
+	cmd.Start()
+	if err = cmd.Wait(); !errors.As(err, &exitErr) || exitErr.Signal != "KILL" || exitErr.Status != 137 || exitErr.Exited() {
+		t.Errorf("unexpected signal error: %#v", err)
+	}
+
+	// Canceling the context interrupts a started command.
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, _ = client.CommandContext(ctx, "sleep", "10")
+	cmd.Start()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err = cmd.Wait(); err != context.Canceled {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+}
+
+func gophCmdDirTest(t *testing.T) {
+
+	client := newClient(t, "2122")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A directory the shell would split or expand.
+	work := filepath.Join(dir, "it's a $dir")
+	if err = os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := client.Command("pwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Dir = work
+	cmd.Env = []string{"GOPH_DIR=env value"}
+	if out, err := cmd.Output(); err != nil || string(out) != work+"\n" {
+		t.Errorf("unexpected working directory: %q %v", out, err)
+	}
+
+	cmd, _ = client.Command(`echo "$GOPH_DIR"`)
+	cmd.Dir = work
+	cmd.Env = []string{"GOPH_DIR=env value"}
+	if out, err := cmd.Output(); err != nil || string(out) != "env value\n" {
+		t.Errorf("unexpected env: %q %v", out, err)
+	}
+	if cmd.String() != `echo "$GOPH_DIR"` {
+		t.Errorf("Dir changed the command string: %s", cmd.String())
+	}
+
+	// The command doesn't run in the default directory instead.
+	cmd, _ = client.Command("touch", "goph-dir-ran")
+	cmd.Dir = filepath.Join(dir, "missing")
+	if err = cmd.Run(); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+	if _, err = os.Stat("goph-dir-ran"); err == nil {
+		os.Remove("goph-dir-ran")
+		t.Error("the command ran without its directory")
+	}
+}
+
+func gophRemoteLockTest(t *testing.T) {
+
+	client := newClient(t, "2123")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock := filepath.Join(dir, "run.lock")
+
+	// Concurrent runs hold the lock one at a time.
+	var (
+		inside, max int32
+		wg          sync.WaitGroup
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.WithRemoteLock(lock, time.Second, func() error {
+				if n := atomic.AddInt32(&inside, 1); n > atomic.LoadInt32(&max) {
+					atomic.StoreInt32(&max, n)
+				}
+				time.Sleep(100 * time.Millisecond)
+				atomic.AddInt32(&inside, -1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if max != 1 {
+		t.Errorf("expected a single holder at a time, got %d", max)
+	}
+	if _, err = os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected the lockfile to be removed, got %v", err)
+	}
+
+	// A held lock times out the waiter.
+	ioutil.WriteFile(lock, []byte("crashed run\n"), 0644)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err = client.WithRemoteLockContext(ctx, lock, time.Second, func() error { return nil }); err != context.DeadlineExceeded {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+
+	// A lockfile left unchanged for ttl is broken.
+	start, ran := time.Now(), false
+	if err = client.WithRemoteLock(lock, 300*time.Millisecond, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("stale lock not broken: %v", err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("stale lock broken before ttl: %s", d)
+	}
+
+	// A live holder refreshes its lock longer than ttl.
+	held := make(chan struct{})
+	go func() {
+		client.WithRemoteLock(lock, 300*time.Millisecond, func() error {
+			close(held)
+			time.Sleep(time.Second)
+			return nil
+		})
+	}()
+	<-held
+	start = time.Now()
+	client.WithRemoteLock(lock, 300*time.Millisecond, func() error { return nil })
+	if d := time.Since(start); d < 800*time.Millisecond {
+		t.Errorf("live lock broken after %s", d)
+	}
+
+	err = client.WithRemoteLock(lock, 300*time.Millisecond, func() error {
+		os.Remove(lock)
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, goph.ErrLockLost) {
+		t.Errorf("expected a lost lock error, got %v", err)
+	}
+}
+
+func gophScanHostKeysTest(t *testing.T) {
+
+	other, err := goph.EphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer("2124")
+	newServerConfig("2125", func(config *ssh.ServerConfig) {
+		*config = ssh.ServerConfig{PasswordCallback: config.PasswordCallback}
+		config.AddHostKey(other)
+	})
+
+	results := goph.ScanHostKeys([]string{"127.0.10.10:2124", "127.0.10.10:2125", "127.0.10.10:1"})
+	if len(results) != 3 || results[0].Err != nil || results[1].Err != nil || results[2].Err == nil {
+		t.Fatalf("unexpected scan results: %+v", results)
+	}
+	if len(results[1].HostKeys) != 1 || results[1].HostKeys[0].Type != ssh.KeyAlgoED25519 {
+		t.Fatalf("unexpected host keys: %+v", results[1].HostKeys)
+	}
+	if lines := results[1].KnownHostsLines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "[127.0.10.10]:2125 ssh-ed25519 ") {
+		t.Errorf("unexpected known_hosts lines: %q", lines)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "known_hosts")
+
+	added, err := goph.WriteKnownHosts(file, results)
+	if err != nil || added != len(results[0].HostKeys)+1 {
+		t.Fatalf("unexpected write: %d %v", added, err)
+	}
+	if added, err = goph.WriteKnownHosts(file, results); err != nil || added != 0 {
+		t.Errorf("expected known keys to be skipped, got %d %v", added, err)
+	}
+
+	// Preseeded hosts connect with the known_hosts callback.
+	callback, err := goph.KnownHosts(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ := goph.NewConfig("melbahja", "127.0.10.10", 2125, goph.Password("123456"))
+	config.ClientConfig.HostKeyCallback = callback
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatalf("preseeded host rejected: %v", err)
+	}
+	client.Close()
+
+	// A host known with another key of the type isn't changed, a new key
+	// type is added.
+	changed, _ := goph.EphemeralKey()
+	key := goph.ProbeKey{Type: ssh.KeyAlgoED25519, Key: changed.PublicKey()}
+	added, err = goph.WriteKnownHosts(file, []goph.ScanResult{{Addr: "127.0.10.10:2125", HostKeys: []goph.ProbeKey{key}}})
+	if !errors.Is(err, goph.ErrHostKeyMismatch) || added != 0 {
+		t.Errorf("expected a host key mismatch, got %d %v", added, err)
+	}
+	if added, err = goph.WriteKnownHosts(file, []goph.ScanResult{{Addr: "127.0.10.10:2124", HostKeys: []goph.ProbeKey{key}}}); err != nil || added != 1 {
+		t.Errorf("expected the new key type to be added, got %d %v", added, err)
+	}
+}
+
+func gophCmdQuotingTest(t *testing.T) {
+
+	client := newClient(t, "2126")
+	defer client.Close()
+
+	args := []string{"two words", "it's", `"double"`, "$HOME", "`id`", "a;b", "*", ""}
+	cmd, err := client.Command("printf", append([]string{"[%s]"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[" + strings.Join(args, "][") + "]"; string(out) != want {
+		t.Errorf("args not passed literally: got %s, want %s", out, want)
+	}
+
+	cmd, _ = client.Command("echo", "$GOPH_RAW")
+	cmd.Raw = true
+	cmd.Env = []string{"GOPH_RAW=expanded"}
+	if out, err = cmd.Output(); err != nil || string(out) != "expanded\n" {
+		t.Errorf("unexpected raw output: %q %v", out, err)
+	}
+}
+
+func gophListenRemoteTest(t *testing.T) {
+
+	client := newClient(t, "2127")
+	defer client.Close()
+
+	ln, err := client.ListenRemote("127.0.10.10:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "served %s", r.URL.Path)
+	})}
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ln) }()
+
+	// Traffic arriving on the remote port is served by this process.
+	port := ln.Addr().(*net.TCPAddr).Port
+	if port == 0 {
+		t.Fatal("expected the server picked port")
+	}
+	resp, err := http.Get(fmt.Sprintf("http://127.0.10.10:%d/remote", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "served /remote" {
+		t.Errorf("unexpected response: %q", body)
+	}
+
+	server.Close()
+	if err = <-done; err != http.ErrServerClosed {
+		t.Errorf("unexpected serve error: %v", err)
+	}
+}
+
+func gophShellTest(t *testing.T) {
+
+	client := newClient(t, "2128")
+	defer client.Close()
+
+	var out bytes.Buffer
+	err := client.Shell(
+		goph.WithShellIO(strings.NewReader("hello\r"), &out, ioutil.Discard),
+		goph.WithPty(100, 40),
+		goph.WithTerm("vt100"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "> hello") {
+		t.Errorf("unexpected shell output: %q", out.String())
+	}
+
+	pty, _ := lastPty.Load().(ptyRequest)
+	if pty.Term != "vt100" || pty.Columns != 100 || pty.Rows != 40 {
+		t.Errorf("unexpected pty request: %+v", pty)
+	}
+}
+
+func gophExpectTest(t *testing.T) {
+
+	client := newClient(t, "2129")
+	defer client.Close()
+
+	// A prompt answered like a password prompt.
+	e, err := client.Interact(`printf "Password: "; read p; echo "got $p"; printf "Continue? [y/n] "; read a; exit 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if _, err = e.Expect(`Password: $`, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	e.Send("secret\n")
+	if out, err := e.Expect(`got (\w+)\r?\n`, time.Second); err != nil || !strings.HasSuffix(strings.TrimSpace(out), "got secret") {
+		t.Fatalf("unexpected answer output: %q %v", out, err)
+	}
+
+	// A timeout keeps the unmatched output.
+	if _, err = e.Expect("never", 100*time.Millisecond); !errors.Is(err, goph.ErrExpectTimeout) {
+		t.Errorf("expected an expect timeout, got %v", err)
+	}
+	if out, err := e.Expect(`\[y/n\] `, time.Second); err != nil || out != "Continue? [y/n] " {
+		t.Errorf("unexpected output after timeout: %q %v", out, err)
+	}
+
+	e.Send("y\n")
+	if _, err = e.Expect("never", time.Second); err != io.EOF {
+		t.Errorf("expected EOF once exited, got %v", err)
+	}
+	var exitErr *goph.ExitError
+	if err = e.Wait(); !errors.As(err, &exitErr) || exitErr.Status != 3 {
+		t.Errorf("unexpected exit: %v", err)
+	}
+
+	// A login shell on the PTY.
+	shell, err := client.Interact("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shell.Close()
+	if _, err = shell.Expect("> ", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	shell.Send("exit\r")
+	if err = shell.Wait(); err != nil {
+		t.Errorf("unexpected shell exit: %v", err)
+	}
+}
+
+func gophContextDialerTest(t *testing.T) {
+
+	client := newClient(t, "2130")
+	defer client.Close()
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+
+	dial := client.ContextDialer()
+	conn, err := dial(context.Background(), echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("unexpected echo: %q %v", buf, err)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = dial(ctx, echo.Addr().String()); err != context.Canceled {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+
+	// A http.Transport reaches the server network the same way.
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tunneled")
+	}))
+	defer web.Close()
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, addr)
+		},
+	}}
+	resp, err := httpClient.Get(web.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "tunneled" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func gophSessionsTest(t *testing.T) {
+
+	client := newClient(t, "2131")
+	defer client.Close()
+
+	if n := len(client.Sessions()); n != 0 {
+		t.Fatalf("expected no session, got %d", n)
+	}
+
+	idle, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := client.Command("sleep", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Start()
+	ftp, err := client.NewSftp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := newEchoServer(t)
+	defer echo.Close()
+	conn, err := client.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	for _, s := range client.Sessions() {
+		kinds = append(kinds, s.Kind+":"+s.Detail)
+		if s.Age() <= 0 || s.Age() > time.Minute {
+			t.Errorf("unexpected age: %s", s.Age())
+		}
+	}
+	if want := "[: exec:sleep 10 sftp: forward:" + echo.Addr().String() + "]"; fmt.Sprint(kinds) != want {
+		t.Errorf("unexpected sessions: %v, want %s", kinds, want)
+	}
+
+	idle.Close()
+	cmd.Session.Close()
+	ftp.Close()
+	conn.Close()
+
+	for i := 0; i < 100 && len(client.Sessions()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := client.Sessions(); len(s) != 0 {
+		t.Errorf("expected the closed sessions to be gone, got %+v", s)
+	}
+}
+
+func gophSudoTest(t *testing.T) {
+
+	client := newClient(t, "2132")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-sudo-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake sudo printing its prompt unless disabled, wanting s3cret and
+	// denying the commands with the word forbidden.
+	fake := `p="[sudo] password for melbahja: "; [ "$1" = -S ] || exit 2
+[ "$2" = -p ] && { p=$3; shift 3; }
+printf "%s" "$p" >&2; read -r pass
+[ "$pass" = s3cret ] || { echo "Sorry, try again." >&2; echo "sudo: 1 incorrect password attempt" >&2; exit 1; }
+case "$3" in *forbidden*) echo "Sorry, user melbahja is not allowed to execute '$3' as root." >&2; exit 1;; esac
+exec "$@"`
+	if err = ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte("#!/bin/sh\n"+fake+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	out, err := client.Sudo("echo root; cat", "s3cret")
+	if err != nil || string(out) != "root\n" {
+		t.Errorf("unexpected sudo output: %q %v", out, err)
+	}
+
+	_, err = client.Sudo("id", "wrong")
+	if !errors.Is(err, goph.ErrSudoPassword) || errors.Is(err, goph.ErrSudoDenied) || !strings.Contains(err.Error(), "incorrect password") {
+		t.Errorf("expected an incorrect password error, got %v", err)
+	}
+	var exitErr *goph.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 1 {
+		t.Errorf("expected the sudo exit error, got %v", err)
+	}
+
+	if _, err = client.Sudo("echo forbidden", "s3cret"); !errors.Is(err, goph.ErrSudoDenied) {
+		t.Errorf("expected a denied error, got %v", err)
+	}
+
+	// A failing command isn't a sudo error.
+	if _, err = client.Sudo("echo failed >&2; exit 3", "s3cret"); err == nil || errors.Is(err, goph.ErrSudoPassword) || errors.Is(err, goph.ErrSudoDenied) || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("unexpected command error: %v", err)
+	}
+}
+
+func gophGroupTest(t *testing.T) {
+
+	client := newClient(t, "2133")
+	defer client.Close()
+	other, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	unreachable := *client.Config
+	unreachable.Port = 1
+
+	group := goph.NewGroup(0)
+	group.Add("a", client)
+	group.Add("b", other)
+	group.AddConfig("down", &unreachable)
+	defer group.Close()
+
+	if hosts := group.Hosts(); fmt.Sprint(hosts) != "[a b down]" {
+		t.Fatalf("unexpected hosts: %v", hosts)
+	}
+
+	start := time.Now()
+	results := group.Run(context.Background(), "sleep 0.2; echo $GOPH_GROUP; exit 2")
+	if d := time.Since(start); d > 350*time.Millisecond {
+		t.Errorf("hosts didn't run concurrently: %s", d)
+	}
+	for _, name := range []string{"a", "b"} {
+		if res := results[name]; res.Host != name || res.ExitCode != 2 || res.Duration < 200*time.Millisecond || string(res.Output) != "\n" {
+			t.Errorf("unexpected %s result: %+v", name, res)
+		}
+	}
+	if res := results["down"]; res.Err == nil || res.ExitCode != -1 || !strings.Contains(res.Err.Error(), "connect") {
+		t.Errorf("unexpected unreachable host result: %+v", res)
+	}
+
+	// Workers bound the hosts handled at once.
+	group.Workers = 1
+	start = time.Now()
+	group.Run(context.Background(), "sleep 0.2")
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("workers not bounded: %s", d)
+	}
+
+	dir, err := ioutil.TempDir("", "goph-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	ioutil.WriteFile(local, []byte("fleet"), 0644)
+
+	results = group.Upload(context.Background(), local, filepath.Join(dir, "remote"))
+	if results["a"].Err != nil || results["b"].Err != nil || results["down"].Err == nil {
+		t.Errorf("unexpected upload results: %+v", results)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "remote")); string(data) != "fleet" {
+		t.Errorf("unexpected uploaded data: %q", data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := group.Run(ctx, "true")["b"]; res.Err != context.Canceled {
+		t.Errorf("expected canceled hosts, got %+v", res)
+	}
+}
+
+func gophLeakDetectionTest(t *testing.T) {
+
+	newServer("2134")
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2134, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	config.LeakDetection = true
+
+	leaks := make(chan goph.Leak, 10)
+	config.OnLeak = func(l goph.Leak) { leaks <- l }
+
+	client, err := goph.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// A finished command and a started one dropped without Close.
+	func() {
+		cmd, err := client.Command("echo", "ok")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cmd.Output(); err != nil {
+			t.Fatal(err)
+		}
+
+		if cmd, err = client.Command("sleep", "10"); err != nil {
+			t.Fatal(err)
+		}
+		if err = cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var leak goph.Leak
+	for i := 0; i < 200 && leak.Reason == ""; i++ {
+		runtime.GC()
+		select {
+		case leak = <-leaks:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if leak.Reason != goph.LeakCollected || leak.Kind != "exec" || leak.Detail != "sleep 10" {
+		t.Fatalf("unexpected leak: %+v", leak)
+	}
+	if !strings.Contains(leak.Stack, "gophLeakDetectionTest") {
+		t.Errorf("expected the opening stack, got %s", leak.Stack)
+	}
+
+	// A session left open and a closed sftp client, reported by Close.
+	if _, err = client.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	ftp, err := client.NewSftp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ftp.Close()
+
+	client.Close()
+	client.Close()
+	close(leaks)
+
+	var reported []string
+	for l := range leaks {
+		reported = append(reported, l.Type+":"+l.Kind+":"+l.Reason)
+	}
+	if want := "[session::" + goph.LeakOpenAtClose + "]"; fmt.Sprint(reported) != want {
+		t.Errorf("unexpected leaks: %v, want %s", reported, want)
+	}
+}
+
+func gophConfigValidateTest(t *testing.T) {
+
+	valid := func() *goph.Config {
+		return &goph.Config{
+			Addr:     "127.0.0.1",
+			Port:     goph.DefaultPort,
+			Protocol: "tcp",
+			ClientConfig: &ssh.ClientConfig{
+				User:            "melbahja",
+				Auth:            goph.Password("123456"),
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+		}
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]struct {
+		edit func(c *goph.Config)
+		want string
+	}{
+		"no auth":      {func(c *goph.Config) { c.ClientConfig.Auth = nil }, "no auth method"},
+		"nil method":   {func(c *goph.Config) { c.ClientConfig.Auth = append(c.ClientConfig.Auth, nil) }, "auth method 1 is nil"},
+		"zero port":    {func(c *goph.Config) { c.Port = 0 }, `address "127.0.0.1" has no port and Port is 0`},
+		"own port":     {func(c *goph.Config) { c.Port, c.Addr, c.Addrs = 0, "127.0.0.1:2222", []string{"[::1]:22"} }, ""},
+		"ipv6":         {func(c *goph.Config) { c.Addr = "::1" }, ""},
+		"bad port":     {func(c *goph.Config) { c.Addrs = []string{"backup:ssh"} }, `address "backup:ssh" has an invalid port`},
+		"bad address":  {func(c *goph.Config) { c.Addr = "a:b:c" }, `invalid address "a:b:c"`},
+		"no host":      {func(c *goph.Config) { c.Addr = ":22" }, `address ":22" has no host`},
+		"no address":   {func(c *goph.Config) { c.Addr = "" }, "no address to connect to"},
+		"protocol":     {func(c *goph.Config) { c.Protocol = "" }, `unsupported Protocol ""`},
+		"callback":     {func(c *goph.Config) { c.ClientConfig.HostKeyCallback = nil }, "HostKeyCallback is nil"},
+		"client":       {func(c *goph.Config) { c.ClientConfig = nil }, "ClientConfig is nil"},
+		"handler":      {func(c *goph.Config) { c.GlobalRequests = map[string]goph.GlobalRequestHandler{"x@goph": nil} }, `handler of "x@goph" is nil`},
+		"jump no auth": {func(c *goph.Config) { c.Jump = valid(); c.Jump.ClientConfig.Auth = nil }, "jump host 1: no auth method"},
+	}
+
+	for name, test := range tests {
+		config := valid()
+		test.edit(config)
+
+		err := config.Validate()
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, goph.ErrInvalidConfig) || !strings.Contains(fmt.Sprint(err), test.want) {
+			t.Errorf("%s: expected an ErrInvalidConfig with %q, got %v", name, test.want, err)
+		}
+	}
+
+	// NewClient fails before dialing.
+	config := valid()
+	config.ClientConfig.HostKeyCallback = nil
+	if _, err := goph.NewClient(config); !errors.Is(err, goph.ErrInvalidConfig) {
+		t.Errorf("expected NewClient to validate the config, got %v", err)
+	}
+
+	if err := (*goph.Config)(nil).Validate(); !errors.Is(err, goph.ErrInvalidConfig) {
+		t.Errorf("expected a nil config error, got %v", err)
+	}
+}
+
+func gophMaxConcurrentSessionsTest(t *testing.T) {
+
+	sessionLimits.Store("2135", int32(2))
+	client := newClient(t, "2135")
+	defer client.Close()
+
+	burst := func(client *goph.Client) (failed int, err error) {
+
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out, e := client.RunContext(context.Background(), "sleep 0.1; echo ok")
+				mu.Lock()
+				defer mu.Unlock()
+				if e != nil {
+					failed++
+					err = e
+				} else if string(out) != "ok\n" {
+					err = fmt.Errorf("unexpected output: %q", out)
+				}
+			}()
+		}
+		wg.Wait()
+
+		return failed, err
+	}
+
+	if failed, err := burst(client); failed == 0 || !errors.Is(err, goph.ErrSessionLimit) {
+		t.Errorf("expected the server to refuse sessions without a limit, got %d failed: %v", failed, err)
+	}
+
+	// Waiting for the client limit, and for the server one above it.
+	for _, max := range []int{2, 4} {
+
+		limited, err := client.Clone(func(c *goph.Config) { c.MaxConcurrentSessions = max })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failed, err := burst(limited); failed != 0 {
+			t.Errorf("max %d: expected every command to wait for a session, got %d failed: %v", max, failed, err)
+		}
+		limited.Close()
+	}
+
+	limited, err := client.Clone(func(c *goph.Config) { c.MaxConcurrentSessions = 1 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+
+	sess, err := limited.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = limited.CommandContext(ctx, "true"); err != context.DeadlineExceeded {
+		t.Errorf("expected the queued command to time out, got %v", err)
+	}
+
+	sess.Close()
+	if out, err := limited.Run("echo ok"); err != nil || string(out) != "ok\n" {
+		t.Errorf("expected the closed session to free its slot: %q %v", out, err)
+	}
+}
+
+func gophInventoryTest(t *testing.T) {
+
+	newServer("2136")
+
+	dir, err := ioutil.TempDir("", "goph-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signer, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := filepath.Join(dir, "known_hosts")
+	if err = ioutil.WriteFile(known, []byte("[127.0.10.10]:2136 "+string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := `{
+  "defaults": {"user": "melbahja", "password": "123456", "port": 2136, "known_hosts": "` + known + `", "vars": {"ip": "127.0.10.10"}},
+  "groups": {
+    "web": {
+      "defaults": {"addr": "${ip}", "env": {"GREETING": "hello ${host} in ${dc}"}, "vars": {"dc": "par1"}},
+      "hosts": {
+        "web1": {},
+        "web2": {"vars": {"dc": "ams1"}, "env": {"ROLE": "canary"}}
+      }
+    },
+    "infra": {
+      "hosts": {
+        "bastion": {"addr": "203.0.113.1", "port": 2222, "user": "jump"},
+        "db1": {"addr": "10.0.0.5", "jump": "bastion"},
+        "broken": {"addr": "${nope}"}
+      }
+    }
+  }
+}`
+	file := filepath.Join(dir, "inventory.json")
+	if err = ioutil.WriteFile(file, []byte(inventory), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := goph.LoadInventory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := inv.Host("web2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Addr != "127.0.10.10" || h.Port != 2136 || h.User != "melbahja" || h.Env["GREETING"] != "hello web2 in ams1" || h.Env["ROLE"] != "canary" {
+		t.Errorf("unexpected web2 settings: %+v", h)
+	}
+
+	config, err := inv.Config("db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Addr != "10.0.0.5" || config.Port != 2136 || config.Jump == nil || config.Jump.Addr != "203.0.113.1" || config.Jump.Port != 2222 || config.Jump.ClientConfig.User != "jump" {
+		t.Errorf("unexpected db1 config: %+v", config)
+	}
+
+	if _, err = inv.Host("broken"); err == nil || !strings.Contains(err.Error(), "undefined variable nope") {
+		t.Errorf("expected an undefined variable error, got %v", err)
+	}
+	if _, err = inv.Host("web3"); err == nil {
+		t.Error("expected an unknown host error")
+	}
+	if _, err = inv.Group("db"); err == nil {
+		t.Error("expected an unknown group error")
+	}
+
+	group, err := inv.Group("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Close()
+
+	results := group.Run(context.Background(), `echo "$GREETING $ROLE"`)
+	for host, want := range map[string]string{"web1": "hello web1 in par1 \n", "web2": "hello web2 in ams1 canary\n"} {
+		if res := results[host]; res.Err != nil || string(res.Output) != want {
+			t.Errorf("%s: unexpected result %q %v, want %q", host, res.Output, res.Err, want)
+		}
+	}
+}
+
+func gophRunStreamTest(t *testing.T) {
+
+	client := newClient(t, "2137")
+	defer client.Close()
+
+	var stdout, stderr bytes.Buffer
+	err := client.RunWriter("echo out; echo err >&2; exit 3", &stdout, &stderr)
+	var exitErr *goph.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 3 || stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("unexpected RunWriter result: %q %q %v", stdout.String(), stderr.String(), err)
+	}
+
+	lines, err := client.RunStream(context.Background(), `printf 'a\n\nb\r\n'; echo e >&2; printf tail; exit 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outs, errs []string
+	var last goph.Line
+	for l := range lines {
+		switch {
+		case l.Done:
+			last = l
+		case l.Stderr:
+			errs = append(errs, l.Text)
+		default:
+			outs = append(outs, l.Text)
+		}
+	}
+	if fmt.Sprintf("%q %q", outs, errs) != `["a" "" "b" "tail"] ["e"]` {
+		t.Errorf("unexpected lines: %q %q", outs, errs)
+	}
+	if !last.Done || !errors.As(last.Err, &exitErr) || exitErr.Status != 2 {
+		t.Errorf("expected a done line with exit status 2, got %+v", last)
+	}
+
+	// Canceling ctx closes the stream of a never ending command.
+	ctx, cancel := context.WithCancel(context.Background())
+	if lines, err = client.RunStream(ctx, "while true; do echo y; sleep 0.01; done"); err != nil {
+		t.Fatal(err)
+	}
+	if l := <-lines; l.Text != "y" {
+		t.Errorf("unexpected line: %+v", l)
+	}
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-lines:
+		case <-timeout:
+			t.Fatal("expected the stream to be closed")
+		}
+	}
+}
+
+func gophRunWithInputTest(t *testing.T) {
+
+	client := newClient(t, "2138")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "piped")
+	data := strings.Repeat("piped data\n", 10000)
+	out, err := client.RunWithInput("cat > "+file+" && wc -c < "+file, strings.NewReader(data))
+	if err != nil || strings.TrimSpace(string(out)) != fmt.Sprint(len(data)) {
+		t.Errorf("unexpected output: %q %v", out, err)
+	}
+	if b, _ := ioutil.ReadFile(file); string(b) != data {
+		t.Errorf("expected the piped file, got %d bytes", len(b))
+	}
+
+	out, err = client.RunWithInput("sh -s -- world", strings.NewReader("echo hello $1\necho oops >&2\nexit 4\n"))
+	var exitErr *goph.ExitError
+	if !strings.Contains(string(out), "hello world\n") || !strings.Contains(string(out), "oops\n") || !errors.As(err, &exitErr) || exitErr.Status != 4 {
+		t.Errorf("unexpected script result: %q %v", out, err)
+	}
+}
+
+func gophGroupStreamTest(t *testing.T) {
+
+	client := newClient(t, "2139")
+	defer client.Close()
+	other, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	unreachable := *client.Config
+	unreachable.Port = 1
+
+	group := goph.NewGroup(0)
+	group.Add("a", client)
+	group.Add("b", other)
+	group.AddConfig("down", &unreachable)
+	defer group.Close()
+
+	events := make(map[string][]string)
+	for e := range group.Stream(context.Background(), "echo one; echo warn >&2; sleep 0.1; echo two; exit 3") {
+		desc := e.Kind.String()
+		switch e.Kind {
+		case goph.HostStdout, goph.HostStderr:
+			desc += ":" + e.Text
+		case goph.HostFinished:
+			desc += fmt.Sprintf(":%d", e.ExitCode)
+			if e.Host == "down" && (e.Err == nil || !strings.Contains(e.Err.Error(), "connect")) {
+				t.Errorf("expected a connect error, got %v", e.Err)
+			}
+		}
+		events[e.Host] = append(events[e.Host], desc)
+	}
+
+	for _, host := range []string{"a", "b"} {
+		got := events[host]
+		if len(got) != 5 || got[0] != "connected" || got[4] != "finished:3" {
+			t.Errorf("%s: unexpected events %v", host, got)
+			continue
+		}
+		sort.Strings(got[1:4])
+		if fmt.Sprint(got[1:4]) != "[stderr:warn stdout:one stdout:two]" {
+			t.Errorf("%s: unexpected output events %v", host, got)
+		}
+	}
+	if fmt.Sprint(events["down"]) != "[finished:-1]" {
+		t.Errorf("unexpected unreachable host events: %v", events["down"])
+	}
+
+	// Canceling ctx ends the stream of never ending commands.
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := group.Stream(ctx, "while true; do echo y; sleep 0.01; done")
+	for e := range stream {
+		if e.Kind == goph.HostStdout {
+			break
+		}
+	}
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-stream:
+		case <-timeout:
+			t.Fatal("expected the stream to be closed")
+		}
+	}
+}
+
+func gophTempFileModeTest(t *testing.T) {
+
+	client := newClient(t, "2140")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-tempmode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Records the mode of the temp files when their data is written.
+	var (
+		mu    sync.Mutex
+		modes []os.FileMode
+	)
+	sftpOnWrite.Store(func() {
+		tmps, _ := filepath.Glob(filepath.Join(dir, ".*.goph-*"))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, tmp := range tmps {
+			if info, err := os.Stat(tmp); err == nil {
+				modes = append(modes, info.Mode().Perm())
+			}
+		}
+	})
+	defer sftpOnWrite.Store((func())(nil))
+
+	secret := filepath.Join(dir, "secret")
+	if err = ioutil.WriteFile(secret, []byte("token=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(secret, 0600)
+
+	err = client.EditFile(secret, func(b []byte) ([]byte, error) {
+		return []byte("token=2\n"), nil
+	}, goph.WithBackup(".bak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.WriteEnvFile(filepath.Join(dir, "app.env"), map[string]string{"API_TOKEN": "s3cret"}, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(modes) != "[-rw------- -rw------- -rw-r-----]" {
+		t.Errorf("expected the temp files mode set before writing, got %v", modes)
+	}
+
+	for file, want := range map[string]os.FileMode{secret: 0600, secret + ".bak": 0600, filepath.Join(dir, "app.env"): 0640} {
+		if info, err := os.Stat(file); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s: expected mode %v: %v %v", file, want, info, err)
+		}
+	}
+}
+
+func gophGlobalRequestsTest(t *testing.T) {
+
+	conns := make(chan *ssh.ServerConn, 2)
+	serverConnHooks.Store("2141", func(conn *ssh.ServerConn) { conns <- conn })
+	defer serverConnHooks.Delete("2141")
+
+	client := newClient(t, "2141")
+	defer client.Close()
+	server := <-conns
+
+	var got []string
+	client.HandleGlobalRequest("ping@goph", func(req *ssh.Request) (bool, []byte) {
+		got = append(got, string(req.Payload))
+		return true, []byte("pong " + string(req.Payload))
+	})
+
+	ok, payload, err := server.SendRequest("ping@goph", true, []byte("1"))
+	if err != nil || !ok || string(payload) != "pong 1" || fmt.Sprint(got) != "[1]" {
+		t.Errorf("unexpected handler reply: %v %q %v, handled %v", ok, payload, err, got)
+	}
+
+	if ok, _, err = server.SendRequest("unknown@goph", true, nil); err != nil || ok {
+		t.Errorf("expected unknown requests to be refused: %v %v", ok, err)
+	}
+
+	// A nil handler removes it.
+	client.HandleGlobalRequest("ping@goph", nil)
+	if ok, _, err = server.SendRequest("ping@goph", true, []byte("2")); err != nil || ok || len(got) != 1 {
+		t.Errorf("expected the removed handler to be refused: %v %v, handled %v", ok, err, got)
+	}
+
+	// Config.GlobalRequests handles requests right after the handshake.
+	other, err := client.Clone(func(c *goph.Config) {
+		c.GlobalRequests = map[string]goph.GlobalRequestHandler{
+			"hello@goph": func(req *ssh.Request) (bool, []byte) { return true, []byte("hi") },
+		}
+	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if ok, payload, err = (<-conns).SendRequest("hello@goph", true, nil); err != nil || !ok || string(payload) != "hi" {
+		t.Errorf("unexpected config handler reply: %v %q %v", ok, payload, err)
+	}
+
+	ok, payload, err = client.SendGlobalRequest("echo@goph", true, []byte("abc"))
+	if err != nil || !ok || string(payload) != "cba" {
+		t.Errorf("unexpected server reply: %v %q %v", ok, payload, err)
+	}
+	if ok, _, err = client.SendGlobalRequest("unknown@goph", true, nil); err != nil || ok {
+		t.Errorf("expected the server to refuse unknown requests: %v %v", ok, err)
+	}
+
+	// A Client not created by NewClient ignores handlers.
+	goph.Client{}.HandleGlobalRequest("ping@goph", func(*ssh.Request) (bool, []byte) { return true, nil })
+}
+
+func gophPoolHealthTimeoutTest(t *testing.T) {
+
+	silentGlobalRequests.Store("2142", true)
+	client := newClient(t, "2142")
+	defer client.Close()
+
+	pool := goph.NewPool(0, 0)
+	pool.HealthTimeout = 100 * time.Millisecond
+	defer pool.Close()
+
+	first, err := pool.Get(client.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(first)
+
+	start := time.Now()
+	second, err := pool.Get(client.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Discard(second)
+
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the health check to time out, Get took %s", d)
+	}
+	if second == first {
+		t.Error("expected the unanswering client to be replaced")
+	}
+	if stats := pool.Stats(); stats.HealthFailures != 1 || stats.Open != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if _, err = first.Run("true"); err == nil {
+		t.Error("expected the unanswering client to be closed")
+	}
+}
+
+func gophBreakerContextTest(t *testing.T) {
+
+	breaker := goph.NewBreaker(2, time.Minute)
+
+	// Nothing listens on 127.0.10.11.
+	config, err := goph.NewConfig("melbahja", "127.0.10.11", 2143, goph.Password("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid config isn't dialed nor counted against the host.
+	config.ClientConfig.HostKeyCallback = nil
+	for i := 0; i < 3; i++ {
+		if _, err = breaker.NewClient(config); !errors.Is(err, goph.ErrInvalidConfig) {
+			t.Fatalf("expected an invalid config error, got: %v", err)
+		}
 	}
 	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 
-	client, err := goph.NewClient(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if _, err = breaker.NewClientContext(ctx, config); err != context.Canceled {
+			t.Fatalf("expected the ctx error, got: %v", err)
+		}
+	}
+
+	if state := breaker.State("127.0.10.11:2143"); state != goph.BreakerClosed {
+		t.Errorf("expected the circuit to stay closed, got: %s", state)
+	}
+}
+
+func gophFindUnreadableTest(t *testing.T) {
+
+	client := newClient(t, "2144")
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "goph-find-unreadable")
 	if err != nil {
-		t.Errorf("connect error: %s", err)
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	_, err = client.Run("ls")
+	os.MkdirAll(filepath.Join(dir, "locked"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "locked", "hidden.conf"), []byte("port=1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.conf"), []byte("port=2\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.conf"), []byte("port=3\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "big.log"), []byte(strings.Repeat("x", 2<<20)+"\nport=4\r\n"), 0644)
+	os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "dangling.conf"))
+
+	// The sftp server denies the locked directory and the secret file, as
+	// permissions would for another user.
+	sftpDenied.Store(func(p string) bool {
+		return p == filepath.Join(dir, "locked") || p == filepath.Join(dir, "secret.conf")
+	})
+	defer sftpDenied.Store((func(string) bool)(nil))
+
+	results, err := client.Find(dir, "*.conf", goph.FindOptions{FilesOnly: true})
+	if err != nil || len(results) != 2 {
+		t.Errorf("expected the unreadable directory to be skipped, got %v, %v", results, err)
+	}
+
+	matches, err := client.Grep(dir, regexp.MustCompile(`^port=`))
+	var found []string
+	for _, m := range matches {
+		found = append(found, fmt.Sprintf("%s:%d:%s", filepath.Base(m.Path), m.Line, m.Text))
+	}
+	sort.Strings(found)
+	if err != nil || fmt.Sprint(found) != "[app.conf:1:port=3 big.log:2:port=4]" {
+		t.Errorf("unexpected grep matches: %v, %v", found, err)
+	}
+
+	// The root itself must be readable.
+	if _, err = client.Grep(filepath.Join(dir, "secret.conf"), regexp.MustCompile(`port`)); err == nil {
+		t.Error("expected an unreadable root error")
+	}
+	if _, err = client.Find(filepath.Join(dir, "nope"), "*", goph.FindOptions{}); err == nil {
+		t.Error("expected a missing root error")
+	}
+}
+
+// newEchoServer returns a local tcp listener echoing its connections.
+func newEchoServer(t *testing.T) net.Listener {
 
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		t.Errorf("run error: %s", err)
+		t.Fatal(err)
 	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return ln
 }
 
-func gophWrongPassTest(t *testing.T) {
+// newClient starts a test server on port and returns a connected client.
+func newClient(t *testing.T, port string) *goph.Client {
 
-	newServer("2022")
+	newServer(port)
 
-	config, err := goph.NewConfig("melbahja", "127.0.10.10", 2020, goph.Password("123456"))
+	var p uint
+	fmt.Sscan(port, &p)
+
+	config, err := goph.NewConfig("melbahja", "127.0.10.10", p, goph.Password("123456"))
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	config.ClientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 
-	_, err = goph.NewClient(config)
+	client, err := goph.NewClient(config)
 	if err != nil {
-		t.Error("it should return an error")
+		t.Fatalf("connect error: %s", err)
 	}
+
+	return client
 }
 
+// authorizedKey is the public key accepted by the test servers.
+var authorizedKey ssh.PublicKey
+
 func newServer(port string) {
+	newServerConfig(port, nil)
+}
+
+// newServerConfig is like newServer, configure customizes the server config.
+func newServerConfig(port string, configure func(config *ssh.ServerConfig)) {
 
 	config := &ssh.ServerConfig{
 		// Remove to disable password auth.
@@ -123,6 +5361,12 @@ func newServer(port string) {
 			}
 			return nil, fmt.Errorf("password rejected for %q", c.User())
 		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorizedKey != nil && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("public key rejected for %q", c.User())
+		},
 	}
 
 	private, err := ssh.ParsePrivateKey(privateBytes)
@@ -132,6 +5376,10 @@ func newServer(port string) {
 
 	config.AddHostKey(private)
 
+	if configure != nil {
+		configure(config)
+	}
+
 	// Once a ServerConfig has been configured, connections can be
 	// accepted.
 	listener, err := net.Listen("tcp", "127.0.10.10:"+port)
@@ -140,57 +5388,532 @@ func newServer(port string) {
 	}
 
 	go func() {
+		for {
+			nConn, err := listener.Accept()
+			if err != nil {
+				log.Fatal("failed to accept incoming connection: ", err)
+			}
+
+			go serveConn(nConn, config)
+		}
+	}()
+}
+
+func serveConn(nConn net.Conn, config *ssh.ServerConfig) {
+
+	// Before use, a handshake must be performed on the incoming
+	// net.Conn.
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		log.Print("failed to handshake: ", err)
+		return
+	}
 
-		nConn, err := listener.Accept()
+	// The incoming Request channel must be serviced.
+	go serveGlobalRequests(conn, reqs)
+
+	if _, port, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+		if hook, ok := serverConnHooks.Load(port); ok {
+			hook.(func(*ssh.ServerConn))(conn)
+		}
+	}
+
+	// Sessions beyond the sessionLimits of the port are refused like
+	// OpenSSH MaxSessions.
+	var limit, open int32 = -1, 0
+	if _, port, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+		if v, ok := sessionLimits.Load(port); ok {
+			limit = v.(int32)
+		}
+	}
+
+	// Service the incoming Channel channel.
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go serveDirectTCPIP(newChannel)
+			continue
+		}
+
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		if limit >= 0 && atomic.LoadInt32(&open) >= limit {
+			newChannel.Reject(ssh.Prohibited, "open failed")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			log.Fatal("failed to accept incoming connection: ", err)
+			log.Fatalf("Could not accept channel: %v", err)
+		}
+
+		atomic.AddInt32(&open, 1)
+		go func() {
+			serveRequests(channel, requests)
+			atomic.AddInt32(&open, -1)
+		}()
+	}
+}
+
+func serveRequests(channel ssh.Channel, in <-chan *ssh.Request) {
+
+	var env []string
+
+	for req := range in {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ssh.Unmarshal(req.Payload, &kv)
+			env = append(env, kv.Name+"="+kv.Value)
+			req.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			go serveExec(channel, payload.Command, env)
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(payload.Name == "sftp", nil)
+			if payload.Name == "sftp" {
+				go serveSftp(channel)
+			}
+		case "pty-req":
+			var pty ptyRequest
+			ssh.Unmarshal(req.Payload, &pty)
+			lastPty.Store(pty)
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			go serveTerminal(channel)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// serveGlobalRequests serves the tcpip-forward requests of conn with local
+// listeners and rejects the other global requests.
+func serveGlobalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	_, silent := silentGlobalRequests.Load(port)
+
+	for req := range reqs {
+
+		// Like a half open connection, the requests are never answered.
+		if silent {
+			continue
+		}
+
+		// echo@goph replies with its payload reversed.
+		if req.Type == "echo@goph" {
+			reply := make([]byte, len(req.Payload))
+			for i, b := range req.Payload {
+				reply[len(reply)-1-i] = b
+			}
+			req.Reply(true, reply)
+			continue
+		}
+
+		if req.Type != "tcpip-forward" {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+
+		var payload struct {
+			Addr string
+			Port uint32
 		}
+		ssh.Unmarshal(req.Payload, &payload)
 
-		// Before use, a handshake must be performed on the incoming
-		// net.Conn.
-		_, chans, reqs, err := ssh.NewServerConn(nConn, config)
+		ln, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, fmt.Sprint(payload.Port)))
 		if err != nil {
-			log.Fatal("failed to handshake: ", err)
+			req.Reply(false, nil)
+			continue
+		}
+
+		port := uint32(ln.Addr().(*net.TCPAddr).Port)
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			defer ln.Close()
+			go func() {
+				conn.Wait()
+				ln.Close()
+			}()
+
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+
+				origin := c.RemoteAddr().(*net.TCPAddr)
+				channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{payload.Addr, port, origin.IP.String(), uint32(origin.Port)}))
+				if err != nil {
+					c.Close()
+					continue
+				}
+				go ssh.DiscardRequests(requests)
+
+				go func() {
+					go func() {
+						io.Copy(channel, c)
+						channel.CloseWrite()
+					}()
+					io.Copy(c, channel)
+					c.Close()
+					channel.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	ssh.Unmarshal(newChannel.ExtraData(), &payload)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+}
+
+// serveExec runs the command with the local shell.
+func serveExec(channel ssh.Channel, command string, env []string) {
+
+	defer channel.Close()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	stdin, _ := cmd.StdinPipe()
+	go func() {
+		io.Copy(stdin, channel)
+		stdin.Close()
+	}()
+
+	status := 0
+	if err := cmd.Run(); err != nil {
+		status = 255
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+
+			// A killed command reports its signal like sshd.
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				name := map[syscall.Signal]string{syscall.SIGKILL: "KILL", syscall.SIGTERM: "TERM", syscall.SIGINT: "INT"}[ws.Signal()]
+				channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}{name, false, "", ""}))
+				return
+			}
+		}
+	}
+
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// sftpLimits makes the test servers answer limits@openssh.com when set,
+// sftpSessions counts the sftp sessions and sftpMaxWrite records the
+// largest write request data while sftpLimits is set. The sftp servers
+// deny writes while sftpReadOnly is 1, call the sftpOnWrite func() before
+// serving each write request and deny opening the paths sftpDenied
+// func(string) bool returns true for.
+var (
+	sftpLimits   *goph.SftpLimits
+	sftpSessions int32
+	sftpMaxWrite int32
+	sftpReadOnly int32
+	sftpOnWrite  atomic.Value
+	sftpDenied   atomic.Value
+)
+
+func serveSftp(channel ssh.Channel) {
+
+	defer channel.Close()
+
+	atomic.AddInt32(&sftpSessions, 1)
+
+	var rwc io.ReadWriteCloser = channel
+	if limits := sftpLimits; limits != nil {
+		rwc = serveSftpLimits(channel, *limits)
+	}
+
+	onWrite, _ := sftpOnWrite.Load().(func())
+	denied, _ := sftpDenied.Load().(func(string) bool)
+	if onWrite != nil || denied != nil {
+		rwc = &sftpHook{ReadWriteCloser: rwc, onWrite: onWrite, denied: denied}
+	}
+
+	var opts []sftp.ServerOption
+	if atomic.LoadInt32(&sftpReadOnly) == 1 {
+		opts = append(opts, sftp.ReadOnly())
+	}
+
+	server, err := sftp.NewServer(rwc, opts...)
+	if err != nil {
+		log.Fatal("failed to start sftp server: ", err)
+	}
+
+	server.Serve()
+	rwc.Close()
+}
+
+// sftpHook passes the sftp packets to the server one at a time, it calls
+// onWrite before passing a write request and answers the open requests of
+// the denied paths with a permission denied status.
+type sftpHook struct {
+	io.ReadWriteCloser
+	onWrite func()
+	denied  func(string) bool
+	buf     []byte
+
+	mu  sync.Mutex
+	out []byte
+}
+
+func (h *sftpHook) Read(p []byte) (int, error) {
+
+	for len(h.buf) == 0 {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(h.ReadWriteCloser, header); err != nil {
+			return 0, err
 		}
 
-		// The incoming Request channel must be serviced.
-		go ssh.DiscardRequests(reqs)
+		body := make([]byte, binary.BigEndian.Uint32(header)-1)
+		if _, err := io.ReadFull(h.ReadWriteCloser, body); err != nil {
+			return 0, err
+		}
 
-		// Service the incoming Channel channel.
-		for newChannel := range chans {
-			if newChannel.ChannelType() != "session" {
-				newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+		switch header[4] {
+		case 6: // SSH_FXP_WRITE
+			if h.onWrite != nil {
+				h.onWrite()
+			}
+		case 3, 11: // SSH_FXP_OPEN, SSH_FXP_OPENDIR
+			var req struct {
+				ID   uint32
+				Path string
+				Rest []byte `ssh:"rest"`
+			}
+			if h.denied != nil && ssh.Unmarshal(body, &req) == nil && h.denied(req.Path) {
+				status := ssh.Marshal(struct {
+					Type byte
+					ID   uint32
+					Code uint32
+					Msg  string
+					Lang string
+				}{101, req.ID, 3, "permission denied", ""})
+				h.Write(append(ssh.Marshal(struct{ Len uint32 }{uint32(len(status))}), status...))
 				continue
 			}
+		}
+
+		h.buf = append(header, body...)
+	}
+
+	n := copy(p, h.buf)
+	h.buf = h.buf[n:]
+
+	return n, nil
+}
+
+// Write sends the server packets whole, so the status replies of Read
+// aren't written in the middle of one.
+func (h *sftpHook) Write(p []byte) (int, error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.out = append(h.out, p...)
+	for len(h.out) >= 4 {
+		n := 4 + int(binary.BigEndian.Uint32(h.out))
+		if len(h.out) < n {
+			break
+		}
+
+		if _, err := h.ReadWriteCloser.Write(h.out[:n]); err != nil {
+			return 0, err
+		}
+		h.out = h.out[n:]
+	}
 
-			channel, requests, err := newChannel.Accept()
+	return len(p), nil
+}
+
+// serveSftpLimits sits between channel and the sftp server, it advertises
+// limits@openssh.com in place of the server version packet and answers it.
+func serveSftpLimits(channel ssh.Channel, limits goph.SftpLimits) io.ReadWriteCloser {
+
+	var (
+		mu              sync.Mutex
+		in, toServer    = io.Pipe()
+		fromServer, out = io.Pipe()
+	)
+
+	send := func(p []byte) {
+		mu.Lock()
+		channel.Write(p)
+		mu.Unlock()
+	}
+
+	go func() {
+		// Drop the server version, the init reply is sent below.
+		readSftpTestPacket(fromServer)
+		for {
+			p, err := readSftpTestPacket(fromServer)
 			if err != nil {
-				log.Fatalf("Could not accept channel: %v", err)
+				return
 			}
+			send(p)
+		}
+	}()
 
-			go func(in <-chan *ssh.Request) {
-				for req := range in {
-					switch req.Type {
-					case "exec":
-						// just return error 0 without exec.
-						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-					}
-					req.Reply(req.Type == "exec", nil)
-				}
-			}(requests)
-
-			term := terminal.NewTerminal(channel, "> ")
+	go func() {
+		defer toServer.Close()
+		for {
+			p, err := readSftpTestPacket(channel)
+			if err != nil {
+				return
+			}
 
-			go func() {
-				defer channel.Close()
-				for {
-					line, err := term.ReadLine()
-					if err != nil {
-						break
-					}
-					fmt.Println(line)
+			switch p[4] {
+			case 1: // init
+				send(sftpTestPacket(2, ssh.Marshal(struct {
+					Version uint32
+					Name    string
+					Data    string
+				}{3, "limits@openssh.com", "1"})))
+			case 200: // extended
+				var req struct {
+					ID   uint32
+					Name string
+					Rest []byte `ssh:"rest"`
 				}
-			}()
+				ssh.Unmarshal(p[5:], &req)
+				if req.Name == "limits@openssh.com" {
+					send(sftpTestPacket(201, ssh.Marshal(struct {
+						ID                                                         uint32
+						MaxPacketLength, MaxReadLength, MaxWriteLength, MaxHandles uint64
+					}{req.ID, limits.MaxPacketLength, limits.MaxReadLength, limits.MaxWriteLength, limits.MaxOpenHandles})))
+					continue
+				}
+			case 6: // write
+				var req struct {
+					ID     uint32
+					Handle string
+					Offset uint64
+					Data   string
+				}
+				ssh.Unmarshal(p[5:], &req)
+				if n := int32(len(req.Data)); n > atomic.LoadInt32(&sftpMaxWrite) {
+					atomic.StoreInt32(&sftpMaxWrite, n)
+				}
+			}
+
+			toServer.Write(p)
 		}
 	}()
+
+	return struct {
+		io.Reader
+		io.WriteCloser
+	}{in, out}
+}
+
+func sftpTestPacket(typ byte, payload []byte) []byte {
+	return append(ssh.Marshal(struct {
+		Length uint32
+		Type   byte
+	}{uint32(1 + len(payload)), typ}), payload...)
+}
+
+// readSftpTestPacket returns the next packet of r with its length prefix.
+func readSftpTestPacket(r io.Reader) ([]byte, error) {
+
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+
+	p := make([]byte, 4+binary.BigEndian.Uint32(size))
+	copy(p, size)
+
+	_, err := io.ReadFull(r, p[4:])
+	return p, err
+}
+
+func serveTerminal(channel ssh.Channel) {
+
+	defer channel.Close()
+
+	term := terminal.NewTerminal(channel, "> ")
+	for {
+		line, err := term.ReadLine()
+		if err != nil {
+			break
+		}
+		fmt.Println(line)
+	}
+
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+}
+
+// ptyRequest is a pty-req payload, lastPty holds the last one served.
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
 }
+
+var lastPty atomic.Value
+
+// sessionLimits are the max sessions per connection of the server ports.
+var sessionLimits sync.Map
+
+// silentGlobalRequests are the server ports never answering global requests.
+var silentGlobalRequests sync.Map
+
+// serverConnHooks are func(*ssh.ServerConn) called with each connection of
+// the server ports after the handshake.
+var serverConnHooks sync.Map
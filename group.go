@@ -0,0 +1,302 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HostResult is the result of a Group operation on a host.
+type HostResult struct {
+	Host     string
+	Output   []byte
+	ExitCode int
+	Err      error
+	Duration time.Duration
+}
+
+// Group runs commands and transfers on many hosts concurrently.
+type Group struct {
+
+	// Workers is the number of hosts handled at once, 0 means all.
+	Workers int
+
+	mu    sync.Mutex
+	hosts map[string]*groupHost
+}
+
+// groupHost is a host client, connected on first use when added by config.
+type groupHost struct {
+	mu     sync.Mutex
+	client *Client
+	config *Config
+}
+
+// NewGroup returns new group handling workers hosts at once.
+func NewGroup(workers int) *Group {
+	return &Group{Workers: workers}
+}
+
+// Add adds a connected client as host name, it's not closed by the group.
+func (g *Group) Add(name string, client *Client) {
+	g.add(name, &groupHost{client: client})
+}
+
+// AddConfig adds a host connected with config on first use, a failed
+// connection is retried by the next operation. Close closes it.
+func (g *Group) AddConfig(name string, config *Config) {
+	g.add(name, &groupHost{config: config})
+}
+
+func (g *Group) add(name string, h *groupHost) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.hosts == nil {
+		g.hosts = make(map[string]*groupHost)
+	}
+
+	g.hosts[name] = h
+}
+
+// Hosts returns the sorted host names.
+func (g *Group) Hosts() []string {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.hosts))
+	for name := range g.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Run runs cmd on every host and returns the results by host name, the
+// output is the combined one.
+func (g *Group) Run(ctx context.Context, cmd string) map[string]HostResult {
+	return g.Do(ctx, func(ctx context.Context, c *Client) ([]byte, error) {
+		return c.RunContext(ctx, cmd)
+	})
+}
+
+// Upload uploads the local file to remotePath on every host.
+func (g *Group) Upload(ctx context.Context, localPath string, remotePath string, opts ...TransferOption) map[string]HostResult {
+	return g.Do(ctx, func(ctx context.Context, c *Client) ([]byte, error) {
+		return nil, c.UploadContext(ctx, localPath, remotePath, opts...)
+	})
+}
+
+// HostEventKind is the kind of a HostEvent.
+type HostEventKind int
+
+const (
+	HostConnected HostEventKind = iota + 1
+	HostStdout
+	HostStderr
+	HostFinished
+)
+
+func (k HostEventKind) String() string {
+	switch k {
+	case HostConnected:
+		return "connected"
+	case HostStdout:
+		return "stdout"
+	case HostStderr:
+		return "stderr"
+	case HostFinished:
+		return "finished"
+	default:
+		return "unknown"
+	}
+}
+
+// HostEvent is a progress event of a host sent by Group.Stream. Text is the
+// output line, ExitCode, Err and Duration are set when finished.
+type HostEvent struct {
+	Host     string
+	Kind     HostEventKind
+	Text     string
+	ExitCode int
+	Err      error
+	Duration time.Duration
+}
+
+// Stream runs cmd on every host like Run, and sends the events of each host
+// as they come: connected, the output lines, then finished with the exit
+// code, a host failing to connect is only finished. The channel is closed
+// once every host finished. When ctx is done the commands are interrupted
+// and the events not read yet are dropped.
+func (g *Group) Stream(ctx context.Context, cmd string) <-chan HostEvent {
+
+	out := make(chan HostEvent)
+	send := func(e HostEvent) {
+		select {
+		case out <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(out)
+
+		g.do(ctx, func(ctx context.Context, host string, c *Client) ([]byte, error) {
+
+			send(HostEvent{Host: host, Kind: HostConnected})
+
+			lines, err := c.RunStream(ctx, cmd)
+			if err != nil {
+				return nil, err
+			}
+
+			for l := range lines {
+				switch {
+				case l.Done:
+					return nil, l.Err
+				case l.Stderr:
+					send(HostEvent{Host: host, Kind: HostStderr, Text: l.Text})
+				default:
+					send(HostEvent{Host: host, Kind: HostStdout, Text: l.Text})
+				}
+			}
+
+			return nil, ctx.Err()
+		}, func(res HostResult) {
+			send(HostEvent{Host: res.Host, Kind: HostFinished, ExitCode: res.ExitCode, Err: res.Err, Duration: res.Duration})
+		})
+	}()
+
+	return out
+}
+
+// Do calls fn with the client of every host, Workers at once, and returns
+// the results by host name. The hosts not started when ctx is done get the
+// ctx error.
+func (g *Group) Do(ctx context.Context, fn func(ctx context.Context, c *Client) ([]byte, error)) map[string]HostResult {
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]HostResult)
+	)
+
+	g.do(ctx, func(ctx context.Context, _ string, c *Client) ([]byte, error) {
+		return fn(ctx, c)
+	}, func(res HostResult) {
+		mu.Lock()
+		results[res.Host] = res
+		mu.Unlock()
+	})
+
+	return results
+}
+
+// do calls fn like Do, done is called with each host result as it ends.
+func (g *Group) do(ctx context.Context, fn func(ctx context.Context, host string, c *Client) ([]byte, error), done func(HostResult)) {
+
+	var (
+		wg    sync.WaitGroup
+		names = g.Hosts()
+		sem   = make(chan struct{}, g.workers(len(names)))
+	)
+
+	for _, name := range names {
+
+		started := false
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+				started = true
+			case <-ctx.Done():
+			}
+		}
+
+		if !started {
+			done(HostResult{Host: name, ExitCode: -1, Err: ctx.Err()})
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer func() { <-sem; wg.Done() }()
+
+			res := HostResult{Host: name}
+			start := time.Now()
+
+			client, err := g.client(ctx, name)
+			if err == nil {
+				res.Output, err = fn(ctx, name, client)
+			}
+
+			res.Err, res.ExitCode, res.Duration = err, exitCode(err), time.Since(start)
+
+			done(res)
+		}(name)
+	}
+
+	wg.Wait()
+}
+
+func (g *Group) workers(hosts int) int {
+	if g.Workers > 0 && g.Workers < hosts {
+		return g.Workers
+	}
+	if hosts == 0 {
+		return 1
+	}
+	return hosts
+}
+
+// client returns the host client, connecting it if needed.
+func (g *Group) client(ctx context.Context, name string) (*Client, error) {
+
+	g.mu.Lock()
+	h := g.hosts[name]
+	g.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client != nil {
+		return h.client, nil
+	}
+
+	client, err := NewClientContext(ctx, h.config)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	h.client = client
+
+	return client, nil
+}
+
+// Close closes the clients connected by the group.
+func (g *Group) Close() error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var first error
+	for _, h := range g.hosts {
+
+		h.mu.Lock()
+		if h.config != nil && h.client != nil {
+			if err := h.client.Close(); err != nil && first == nil {
+				first = err
+			}
+			h.client = nil
+		}
+		h.mu.Unlock()
+	}
+
+	return first
+}
@@ -4,10 +4,13 @@
 package goph
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -109,6 +112,65 @@ func AddKnownHost(host string, remote net.Addr, key ssh.PublicKey, knownFile str
 	return err
 }
 
+// ErrHostKeyRejected is returned by TOFUKnownHostsConfirm when the confirm
+// hook rejected an unknown host key.
+var ErrHostKeyRejected = errors.New("host key rejected")
+
+// TOFUKnownHosts returns host key callback trusting on first use: unknown
+// hosts keys are added to the known hosts file, created if missing, and
+// changed keys are rejected. An empty file means the default path.
+func TOFUKnownHosts(file string) ssh.HostKeyCallback {
+	return TOFUKnownHostsConfirm(file, nil)
+}
+
+// TOFUKnownHostsConfirm is like TOFUKnownHosts, unknown host keys are only
+// added when confirm, if not nil, returns true.
+func TOFUKnownHostsConfirm(file string, confirm func(host string, remote net.Addr, key ssh.PublicKey) bool) ssh.HostKeyCallback {
+
+	var mu sync.Mutex
+
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		path := file
+		if path == "" {
+			var err error
+			if path, err = DefaultKnownHostsPath(); err != nil {
+				return err
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return err
+		}
+		f.Close()
+
+		callback, err := KnownHosts(path)
+		if err != nil {
+			return err
+		}
+
+		// An unknown host is a KeyError without wanted keys.
+		var keyErr *knownhosts.KeyError
+		if err = callback(host, remote, key); !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		if confirm != nil && !confirm(host, remote, key) {
+			return fmt.Errorf("%w: %s %s", ErrHostKeyRejected, host, ssh.FingerprintSHA256(key))
+		}
+
+		return AddKnownHost(host, remote, key, path)
+	}
+}
+
 // DefaultKnownHostsPath returns default user knows hosts file.
 func DefaultKnownHostsPath() (string, error) {
 
@@ -119,3 +181,22 @@ func DefaultKnownHostsPath() (string, error) {
 
 	return fmt.Sprintf("%s/.ssh/known_hosts", home), err
 }
+
+// CertCheckerCallback returns host key callback accepting the host
+// certificates signed by one of the ca keys and valid for the host name,
+// plain host keys are rejected.
+func CertCheckerCallback(caPubKeys ...ssh.PublicKey) ssh.HostKeyCallback {
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, ca := range caPubKeys {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return checker.CheckHostKey
+}
@@ -0,0 +1,37 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"net"
+)
+
+// CommandRunner runs commands, it's implemented by Client, Local, Recorder and Replayer.
+type CommandRunner interface {
+	Run(cmd string) ([]byte, error)
+	RunContext(ctx context.Context, cmd string) ([]byte, error)
+}
+
+// FileTransferer copies files, it's implemented by Client and Local.
+type FileTransferer interface {
+	Upload(localPath string, remotePath string, opts ...TransferOption) error
+	Download(remotePath string, localPath string, opts ...TransferOption) error
+}
+
+// Tunneler opens network connections through the client, it's implemented by Client.
+type Tunneler interface {
+	Dial(network, addr string) (net.Conn, error)
+	Listen(network, addr string) (net.Listener, error)
+}
+
+var (
+	_ CommandRunner  = (*Client)(nil)
+	_ FileTransferer = (*Client)(nil)
+	_ Tunneler       = (*Client)(nil)
+	_ CommandRunner  = (*Local)(nil)
+	_ FileTransferer = (*Local)(nil)
+	_ CommandRunner  = (*Recorder)(nil)
+	_ CommandRunner  = (*Replayer)(nil)
+)
@@ -0,0 +1,74 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+// Package cli holds the authentication flags shared by the goph commands.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ahmet2mir/goph"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// AuthFlags selects the ssh authentication method.
+type AuthFlags struct {
+	Key        string
+	Pass       bool
+	Passphrase bool
+	Agent      bool
+}
+
+// Register defines the auth flags on fs.
+func (f *AuthFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Key, "key", filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"), "private key path.")
+	fs.BoolVar(&f.Pass, "pass", false, "ask for ssh password instead of private key.")
+	fs.BoolVar(&f.Passphrase, "passphrase", false, "ask for private key passphrase.")
+	fs.BoolVar(&f.Agent, "agent", false, "use ssh agent for authentication (unix systems only).")
+}
+
+// Auth returns the selected auth, the ssh agent is used when available
+// unless a password is asked.
+func (f *AuthFlags) Auth() (goph.Auth, error) {
+
+	if f.Agent || (!f.Pass && goph.HasAgent()) {
+		return goph.UseAgent()
+	}
+
+	if f.Pass {
+		pass, err := AskPass("Enter SSH Password: ")
+		if err != nil {
+			return nil, err
+		}
+		return goph.Password(pass), nil
+	}
+
+	phrase := ""
+	if f.Passphrase {
+		var err error
+		if phrase, err = AskPass("Enter Private Key Passphrase: "); err != nil {
+			return nil, err
+		}
+	}
+
+	return goph.Key(f.Key, phrase)
+}
+
+// AskPass prints msg on stderr and reads a password from the terminal.
+func AskPass(msg string) (string, error) {
+
+	fmt.Fprint(os.Stderr, msg)
+
+	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintln(os.Stderr)
+
+	return strings.TrimSpace(string(pass)), nil
+}
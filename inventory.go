@@ -0,0 +1,340 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// InventoryHost are the connection settings of a host, or the defaults of
+// a group. Empty fields are inherited from the group then the inventory
+// defaults, Env and Vars are merged by key.
+//
+// The string fields and Env values expand ${var} from Vars, and ${host} and
+// ${group} to the host alias and its group name.
+type InventoryHost struct {
+
+	// Addr is the host address, the alias when empty.
+	Addr string `json:"addr"`
+
+	// Port is the ssh port, DefaultPort when 0.
+	Port uint `json:"port"`
+
+	User string `json:"user"`
+
+	// Password, Key and Agent are the auth methods tried in this order.
+	Password   string `json:"password"`
+	Key        string `json:"key"`
+	Passphrase string `json:"passphrase"`
+	Agent      bool   `json:"agent"`
+
+	// KnownHosts is the known_hosts file, the default one when empty.
+	KnownHosts string `json:"known_hosts"`
+
+	// Jump is the alias of the inventory host to connect through.
+	Jump string `json:"jump"`
+
+	// Env vars set on every session, see Config.Env.
+	Env map[string]string `json:"env"`
+
+	// Vars are the expanded variables.
+	Vars map[string]string `json:"vars"`
+}
+
+// InventoryGroup is a group of hosts by alias sharing Defaults.
+type InventoryGroup struct {
+	Defaults InventoryHost            `json:"defaults"`
+	Hosts    map[string]InventoryHost `json:"hosts"`
+}
+
+// Inventory is a fleet of hosts in groups, see LoadInventory. A host alias
+// is in a single group.
+type Inventory struct {
+	Defaults InventoryHost             `json:"defaults"`
+	Groups   map[string]InventoryGroup `json:"groups"`
+}
+
+// LoadInventory reads a JSON inventory file, like:
+//
+//	{
+//	  "defaults": {"user": "deploy", "key": "~/.ssh/id_ed25519"},
+//	  "groups": {
+//	    "web": {
+//	      "defaults": {"jump": "bastion", "vars": {"dc": "par1"}},
+//	      "hosts": {"web1": {"addr": "${host}.${dc}.example.com"}}
+//	    },
+//	    "infra": {"hosts": {"bastion": {"addr": "203.0.113.1", "port": 2222}}}
+//	  }
+//	}
+func LoadInventory(file string) (*Inventory, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inv := &Inventory{}
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(inv); err != nil {
+		return nil, fmt.Errorf("inventory %s: %w", file, err)
+	}
+
+	return inv, nil
+}
+
+// Host returns the expanded settings of the host alias with the group and
+// inventory defaults applied.
+func (inv *Inventory) Host(alias string) (InventoryHost, error) {
+
+	group, err := inv.groupOf(alias)
+	if err != nil {
+		return InventoryHost{}, err
+	}
+
+	h := inv.Defaults.merge(inv.Groups[group].Defaults).merge(inv.Groups[group].Hosts[alias])
+
+	vars := map[string]string{"host": alias, "group": group}
+	for name, value := range h.Vars {
+		if name != "host" && name != "group" {
+			vars[name] = value
+		}
+	}
+
+	var missing []string
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			value, ok := vars[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+	}
+
+	for _, s := range []*string{&h.Addr, &h.User, &h.Password, &h.Key, &h.Passphrase, &h.KnownHosts, &h.Jump} {
+		*s = expand(*s)
+	}
+
+	env := make(map[string]string, len(h.Env))
+	for name, value := range h.Env {
+		env[name] = expand(value)
+	}
+	h.Env, h.Vars = env, vars
+
+	if len(missing) > 0 {
+		return InventoryHost{}, fmt.Errorf("inventory host %s: undefined variable %s", alias, missing[0])
+	}
+
+	if h.Addr == "" {
+		h.Addr = alias
+	}
+
+	if h.Port == 0 {
+		h.Port = DefaultPort
+	}
+
+	return h, nil
+}
+
+// Config returns the config of the host alias, jump hosts included.
+func (inv *Inventory) Config(alias string) (*Config, error) {
+	return inv.config(alias, 0)
+}
+
+func (inv *Inventory) config(alias string, jumps int) (*Config, error) {
+
+	if jumps > maxJumpHosts {
+		return nil, fmt.Errorf("inventory host %s: too many jump hosts", alias)
+	}
+
+	h, err := inv.Host(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth Auth
+	if h.Password != "" {
+		auth = append(auth, Password(h.Password)...)
+	}
+
+	if h.Key != "" {
+		key, err := Key(expandHome(h.Key), h.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("inventory host %s: %w", alias, err)
+		}
+		auth = append(auth, key...)
+	}
+
+	if h.Agent {
+		agent, err := UseAgent()
+		if err != nil {
+			return nil, fmt.Errorf("inventory host %s: %w", alias, err)
+		}
+		auth = append(auth, agent...)
+	}
+
+	var callback ssh.HostKeyCallback
+	if h.KnownHosts != "" {
+		callback, err = KnownHosts(expandHome(h.KnownHosts))
+	} else {
+		callback, err = DefaultKnownHosts()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("inventory host %s: %w", alias, err)
+	}
+
+	c := &Config{
+		Auth:     auth,
+		Addr:     h.Addr,
+		Port:     h.Port,
+		Protocol: "tcp",
+		Timeout:  DefaultTimeout,
+		ClientConfig: &ssh.ClientConfig{
+			User:            h.User,
+			Auth:            auth,
+			Timeout:         DefaultTimeout,
+			HostKeyCallback: callback,
+		},
+	}
+
+	if len(h.Env) > 0 {
+		c.Env = h.Env
+	}
+
+	if h.Jump != "" {
+		if c.Jump, err = inv.config(h.Jump, jumps+1); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Hosts returns the sorted host aliases of groups, of every group when none
+// is given.
+func (inv *Inventory) Hosts(groups ...string) ([]string, error) {
+
+	if len(groups) == 0 {
+		for name := range inv.Groups {
+			groups = append(groups, name)
+		}
+	}
+
+	var aliases []string
+	for _, name := range groups {
+
+		group, ok := inv.Groups[name]
+		if !ok {
+			return nil, fmt.Errorf("inventory group %s not found", name)
+		}
+
+		for alias := range group.Hosts {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+
+	return aliases, nil
+}
+
+// Group returns a Group of the hosts of groups, of every group when none is
+// given, connected on first use.
+func (inv *Inventory) Group(groups ...string) (*Group, error) {
+
+	aliases, err := inv.Hosts(groups...)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewGroup(0)
+	for _, alias := range aliases {
+
+		config, err := inv.Config(alias)
+		if err != nil {
+			return nil, err
+		}
+
+		g.AddConfig(alias, config)
+	}
+
+	return g, nil
+}
+
+// groupOf returns the group name of the host alias.
+func (inv *Inventory) groupOf(alias string) (string, error) {
+
+	var found []string
+	for name, group := range inv.Groups {
+		if _, ok := group.Hosts[alias]; ok {
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("inventory host %s not found", alias)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("inventory host %s is in groups %s", alias, strings.Join(found, ", "))
+	}
+}
+
+// merge returns h with the fields set in o overridden.
+func (h InventoryHost) merge(o InventoryHost) InventoryHost {
+
+	for _, f := range []struct{ dst, src *string }{
+		{&h.Addr, &o.Addr}, {&h.User, &o.User}, {&h.Password, &o.Password}, {&h.Key, &o.Key},
+		{&h.Passphrase, &o.Passphrase}, {&h.KnownHosts, &o.KnownHosts}, {&h.Jump, &o.Jump},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+
+	if o.Port != 0 {
+		h.Port = o.Port
+	}
+
+	h.Agent = h.Agent || o.Agent
+	h.Env = mergeStrings(h.Env, o.Env)
+	h.Vars = mergeStrings(h.Vars, o.Vars)
+
+	return h
+}
+
+func mergeStrings(a, b map[string]string) map[string]string {
+
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+
+	return m
+}
+
+// expandHome replaces a leading ~ with the user home directory.
+func expandHome(p string) string {
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = home + p[1:]
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// JSONError is returned by RunJSON when the command stdout is not valid JSON.
+type JSONError struct {
+	// Err is the decoding error.
+	Err error
+
+	// Stderr is the command stderr.
+	Stderr []byte
+}
+
+func (e *JSONError) Error() string {
+
+	msg := "decode json output: " + e.Err.Error()
+	if stderr := bytes.TrimSpace(e.Stderr); len(stderr) > 0 {
+		msg += ": " + string(stderr)
+	}
+
+	return msg
+}
+
+// Unwrap returns the decoding error.
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// RunJSON runs cmd and decodes its stdout into v, a command failure is
+// returned as is and an invalid output as a *JSONError with the stderr.
+func (c Client) RunJSON(ctx context.Context, cmd string, v interface{}) error {
+
+	command, err := c.CommandContext(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer command.Session.Close()
+
+	var stderr syncBuffer
+	command.Stderr = &stderr
+
+	out, err := command.Output()
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(out, v); err != nil {
+		return &JSONError{Err: err, Stderr: stderr.Bytes()}
+	}
+
+	return nil
+}
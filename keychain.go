@@ -0,0 +1,38 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import "errors"
+
+// ErrSecretNotFound is returned when the keychain has no secret for a service and account.
+var ErrSecretNotFound = errors.New("secret not found in keychain")
+
+// KeychainSecret returns the secret of service and account from the OS keychain:
+// the macOS Keychain, the Secret Service via secret-tool or the Windows Credential
+// Manager where the generic credential target is "service:account".
+func KeychainSecret(service string, account string) (string, error) {
+	return keychainSecret(service, account)
+}
+
+// KeychainPassword returns password auth method with the password from the keychain.
+func KeychainPassword(service string, account string) (Auth, error) {
+
+	pass, err := KeychainSecret(service, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return Password(pass), nil
+}
+
+// KeychainKey returns auth method from private key with its passphrase from the keychain.
+func KeychainKey(prvFile string, service string, account string) (Auth, error) {
+
+	passphrase, err := KeychainSecret(service, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return Key(prvFile, passphrase)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultKnockTimeout is the timeout of a single tcp knock.
+var DefaultKnockTimeout = 500 * time.Millisecond
+
+// Knock is a single port knock sent before dialing the ssh port.
+type Knock struct {
+
+	// Protocol is "tcp" or "udp", empty means tcp.
+	Protocol string
+
+	Port uint
+
+	// Delay to wait after the knock.
+	Delay time.Duration
+}
+
+// knock sends the sequence to host, closed ports are expected so knock errors are ignored.
+func knock(dialer *net.Dialer, host string, seq []Knock) error {
+
+	for _, k := range seq {
+
+		addr := net.JoinHostPort(host, fmt.Sprint(k.Port))
+
+		switch k.Protocol {
+		case "", "tcp":
+			d := *dialer
+			d.Timeout = DefaultKnockTimeout
+			if conn, err := d.Dial("tcp", addr); err == nil {
+				conn.Close()
+			}
+		case "udp":
+			conn, err := dialer.Dial("udp", addr)
+			if err != nil {
+				return err
+			}
+			conn.Write([]byte{0})
+			conn.Close()
+		default:
+			return fmt.Errorf("unknown knock protocol %q", k.Protocol)
+		}
+
+		time.Sleep(k.Delay)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// Leak reasons.
+const (
+	// LeakCollected is a Cmd garbage collected with its session still open.
+	LeakCollected = "collected without Close"
+
+	// LeakOpenAtClose is a channel still open when the client was closed.
+	LeakOpenAtClose = "open when the client was closed"
+)
+
+// Leak is a session, sftp client or forwarded connection found not closed
+// with Config.LeakDetection.
+type Leak struct {
+	SessionInfo
+
+	// Reason is LeakCollected or LeakOpenAtClose.
+	Reason string
+
+	// Stack is the stack trace of the goroutine that opened the channel.
+	Stack string
+}
+
+// watchLeak reports cmd if it's garbage collected with its session open.
+// Only a Cmd can be: ssh sessions and sftp clients are kept reachable by
+// their own goroutines until closed, they're reported by Close.
+func (c Client) watchLeak(cmd *Cmd) {
+
+	if c.tracker == nil || !c.tracker.stacks {
+		return
+	}
+
+	ch := c.tracker.channelOf(cmd.Session)
+	if ch == nil {
+		return
+	}
+
+	config := c.Config
+	runtime.SetFinalizer(cmd, func(*Cmd) {
+		if ch.leaked() {
+			config.leak(ch, LeakCollected)
+		}
+	})
+}
+
+// reportLeaks reports the channels still open, before closing the client.
+func (c Client) reportLeaks() {
+
+	if c.tracker == nil || !c.tracker.stacks {
+		return
+	}
+
+	for _, ch := range c.tracker.sessions() {
+		if ch.leaked() {
+			c.Config.leak(ch, LeakOpenAtClose)
+		}
+	}
+}
+
+// leak calls OnLeak, or logs the leak when it's nil, once per channel.
+func (c *Config) leak(ch *trackedChannel, reason string) {
+
+	if !atomic.CompareAndSwapInt32(&ch.reported, 0, 1) {
+		return
+	}
+
+	l := Leak{SessionInfo: ch.info(), Reason: reason, Stack: ch.stack}
+	l.Detail = c.redactor().Redact(l.Detail)
+
+	if c.OnLeak != nil {
+		c.OnLeak(l)
+		return
+	}
+
+	c.logf("leaked %s channel %s opened at %s: %s\n%s", l.Type, l.Detail, l.Opened, l.Reason, l.Stack)
+}
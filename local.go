@@ -0,0 +1,101 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// Local runs commands and copies files on the local host, it can replace a
+// Client where a CommandRunner or FileTransferer is expected.
+type Local struct {
+
+	// Shell used to run commands, empty means "sh".
+	Shell string
+}
+
+// Run runs cmd with the local shell and returns its combined output.
+func (l Local) Run(cmd string) ([]byte, error) {
+	return l.RunContext(context.Background(), cmd)
+}
+
+// RunContext runs cmd with the local shell, it's killed when ctx is done.
+func (l Local) RunContext(ctx context.Context, cmd string) ([]byte, error) {
+
+	shell := l.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+
+	return exec.CommandContext(ctx, shell, "-c", cmd).CombinedOutput()
+}
+
+// Upload copies localPath to remotePath on the local host, the transfer
+// options apply like with a Client.
+func (l Local) Upload(localPath string, remotePath string, opts ...TransferOption) error {
+	return copyLocalFile(localPath, remotePath, newTransferOptions(opts))
+}
+
+// Download copies remotePath to localPath on the local host, the transfer
+// options apply like with a Client.
+func (l Local) Download(remotePath string, localPath string, opts ...TransferOption) error {
+	return copyLocalFile(remotePath, localPath, newTransferOptions(opts))
+}
+
+// copyLocalFile copies src to dst, the file is always synced.
+func copyLocalFile(src string, dst string, o *transferOptions) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fileError(err)
+	}
+	defer in.Close()
+
+	if o.mkdirs {
+		if err = mkdirAllLocal(filepath.Dir(dst), o.dirMode); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = o.copy(out, in, fileSize(in)); err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
+
+// mkdirAllLocal is like mkdirAll on the local host.
+func mkdirAllLocal(dir string, mode os.FileMode) error {
+
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err = mkdirAllLocal(parent, mode); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Mkdir(dir, mode); err != nil {
+		return err
+	}
+
+	// Mkdir applies the process umask, set the mode explicitly.
+	return os.Chmod(dir, mode)
+}
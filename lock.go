@@ -0,0 +1,249 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrLockLost is returned by WithRemoteLock when the lock was broken or
+// removed while fn was running.
+var ErrLockLost = errors.New("remote lock lost")
+
+// remoteLock is an acquired lockfile, refreshed until released.
+type remoteLock struct {
+	ftp   *sftp.Client
+	path  string
+	token []byte
+	beats int
+	lost  bool
+	done  chan struct{}
+	ended chan struct{}
+}
+
+// WithRemoteLock runs fn holding the advisory lock path on the remote host,
+// it waits for the lock as long as it's held. See WithRemoteLockContext.
+func (c Client) WithRemoteLock(path string, ttl time.Duration, fn func() error) error {
+	return c.WithRemoteLockContext(context.Background(), path, ttl, fn)
+}
+
+// WithRemoteLockContext runs fn holding the advisory lock path on the remote
+// host, waiting for the lock until ctx is done. The lockfile is created
+// atomically and rewritten every ttl/3 while fn runs, a lockfile left
+// unchanged for ttl is stale and broken. Clocks aren't compared, so holders
+// on other machines don't need synchronized clocks.
+func (c Client) WithRemoteLockContext(ctx context.Context, path string, ttl time.Duration, fn func() error) error {
+
+	if ttl <= 0 {
+		return errors.New("lock ttl must be positive")
+	}
+
+	ftp, err := c.NewSftpContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	lock, err := acquireLock(ctx, ftp, c.remotePath(ftp, path), ttl)
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	if lost := lock.release(); err == nil && lost {
+		err = fmt.Errorf("%w: %s", ErrLockLost, path)
+	}
+
+	return err
+}
+
+// acquireLock creates the lockfile, breaking it once stale.
+func acquireLock(ctx context.Context, ftp *sftp.Client, path string, ttl time.Duration) (*remoteLock, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	lock := &remoteLock{
+		ftp:   ftp,
+		path:  path,
+		token: []byte(fmt.Sprintf("%s %s %d\n", hex.EncodeToString(b), host, os.Getpid())),
+		done:  make(chan struct{}),
+		ended: make(chan struct{}),
+	}
+
+	interval := ttl / 10
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	} else if interval > time.Second {
+		interval = time.Second
+	}
+
+	var (
+		seen  []byte
+		since time.Time
+	)
+
+	for {
+		ok, err := lock.create()
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			go lock.refresh(ttl / 3)
+			return lock, nil
+		}
+
+		// The lock is stale when its content didn't change for ttl,
+		// measured by the local clock.
+		content, err := readLock(ftp, path)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return nil, err
+		case seen == nil || !bytes.Equal(content, seen):
+			seen, since = content, time.Now()
+		case time.Since(since) >= ttl:
+			if err = lock.breakStale(seen); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// create creates the lockfile, it returns false when it already exists.
+func (l *remoteLock) create() (bool, error) {
+
+	f, err := l.ftp.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		if _, serr := l.ftp.Lstat(l.path); serr == nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("create lock %s: %w", l.path, err)
+	}
+
+	_, err = f.Write(l.content())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		l.ftp.Remove(l.path)
+		return false, fmt.Errorf("write lock %s: %w", l.path, err)
+	}
+
+	return true, nil
+}
+
+// content returns the lockfile content, the token and the refresh count.
+func (l *remoteLock) content() []byte {
+	return append(append([]byte{}, l.token...), fmt.Sprintf("%d\n", l.beats)...)
+}
+
+// owned reports whether the lockfile is still ours.
+func (l *remoteLock) owned() bool {
+	content, err := readLock(l.ftp, l.path)
+	return err == nil && bytes.HasPrefix(content, l.token)
+}
+
+func readLock(ftp *sftp.Client, path string) ([]byte, error) {
+
+	f, err := ftp.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// breakStale removes the stale lockfile, it's renamed first so only one
+// waiter breaks it, and put back when another waiter got it meanwhile.
+func (l *remoteLock) breakStale(stale []byte) error {
+
+	name, err := tempName(l.path)
+	if err != nil {
+		return err
+	}
+
+	if err = l.ftp.Rename(l.path, name); err != nil {
+		return nil
+	}
+
+	if content, err := readLock(l.ftp, name); err == nil && !bytes.Equal(content, stale) {
+		l.ftp.Rename(name, l.path)
+		return nil
+	}
+
+	return l.ftp.Remove(name)
+}
+
+// refresh rewrites the lockfile with the next count until released, the
+// lock is lost when the lockfile isn't ours anymore.
+func (l *remoteLock) refresh(interval time.Duration) {
+
+	defer close(l.ended)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.owned() {
+			l.lost = true
+			return
+		}
+
+		l.beats++
+		if f, err := l.ftp.OpenFile(l.path, os.O_WRONLY|os.O_TRUNC); err == nil {
+			f.Write(l.content())
+			f.Close()
+		}
+	}
+}
+
+// release stops refreshing and removes the lockfile if it's still ours,
+// it reports whether the lock was lost.
+func (l *remoteLock) release() bool {
+
+	close(l.done)
+	<-l.ended
+
+	if l.lost {
+		return true
+	}
+
+	if !l.owned() {
+		return true
+	}
+
+	l.ftp.Remove(l.path)
+	return false
+}
@@ -0,0 +1,21 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import "fmt"
+
+// Logger logs goph operations, it's implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf logs with the config logger if any, secrets of the Redactor are masked.
+func (c *Config) logf(format string, v ...interface{}) {
+
+	if c == nil || c.Logger == nil {
+		return
+	}
+
+	c.Logger.Printf("%s", c.Redactor.Redact(fmt.Sprintf(format, v...)))
+}
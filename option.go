@@ -0,0 +1,62 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import "golang.org/x/crypto/ssh"
+
+// Option overrides a config field of a derived connection.
+type Option func(*Config)
+
+// WithAddr sets the address to connect to.
+func WithAddr(addr string) Option {
+	return func(c *Config) {
+		c.Addr = addr
+		c.Addrs = nil
+	}
+}
+
+// WithPort sets the port to connect to.
+func WithPort(port uint) Option {
+	return func(c *Config) {
+		c.Port = port
+	}
+}
+
+// WithUser sets the user to connect as.
+func WithUser(user string) Option {
+	return func(c *Config) {
+		c.ClientConfig.User = user
+	}
+}
+
+// WithAuth sets the auth methods.
+func WithAuth(auth Auth) Option {
+	return func(c *Config) {
+		c.Auth = auth
+		c.ClientConfig.Auth = auth
+	}
+}
+
+// clone returns a copy of the config with the options applied, the
+// ClientConfig is copied so options never change the original config.
+func (c *Config) clone(opts []Option) *Config {
+
+	config := *c
+	clientConfig := *c.ClientConfig
+	config.ClientConfig = &clientConfig
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return &config
+}
+
+// WithHostKeyCallback sets the host key callback, like to accept the new
+// key of a reprovisioned host.
+func WithHostKeyCallback(callback ssh.HostKeyCallback) Option {
+	return func(c *Config) {
+		c.ClientConfig.HostKeyCallback = callback
+	}
+}
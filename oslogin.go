@@ -0,0 +1,86 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OSLoginRegistrar imports a public key to the OS Login profile of the
+// current GCP account and returns the profile POSIX username.
+type OSLoginRegistrar interface {
+	ImportSSHPublicKey(ctx context.Context, publicKey string, ttl time.Duration) (username string, err error)
+}
+
+// OSLoginFunc adapts a function to an OSLoginRegistrar.
+type OSLoginFunc func(ctx context.Context, publicKey string, ttl time.Duration) (string, error)
+
+// ImportSSHPublicKey calls f.
+func (f OSLoginFunc) ImportSSHPublicKey(ctx context.Context, publicKey string, ttl time.Duration) (string, error) {
+	return f(ctx, publicKey, ttl)
+}
+
+// GCPOSLogin imports keys with the gcloud cli of the active account.
+var GCPOSLogin OSLoginRegistrar = OSLoginFunc(func(ctx context.Context, publicKey string, ttl time.Duration) (string, error) {
+
+	if _, err := gcloud(ctx, "compute", "os-login", "ssh-keys", "add", "--key="+publicKey, fmt.Sprintf("--ttl=%ds", int(ttl.Seconds()))); err != nil {
+		return "", err
+	}
+
+	out, err := gcloud(ctx, "compute", "os-login", "describe-profile", "--format=value(posixAccounts[0].username)")
+	if err != nil {
+		return "", err
+	}
+
+	if out == "" {
+		return "", errors.New("gcloud: os login profile has no posix account")
+	}
+
+	return out, nil
+})
+
+func gcloud(ctx context.Context, args ...string) (string, error) {
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "gcloud", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New("gcloud: " + msg)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// OSLogin imports an ephemeral key valid for ttl to OS Login and connects
+// with it as the profile username, the config itself is left untouched.
+func OSLogin(ctx context.Context, registrar OSLoginRegistrar, ttl time.Duration, config *Config) (*Client, error) {
+
+	signer, err := EphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	username, err := registrar.ImportSSHPublicKey(ctx, publicKey, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClientContext(ctx, config.clone([]Option{WithUser(username), WithAuth(Auth{ssh.PublicKeys(signer)})}))
+}
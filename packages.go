@@ -0,0 +1,90 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoPackageManager is returned by InstallPackages on hosts without a
+// supported package manager.
+var ErrNoPackageManager = errors.New("no supported package manager")
+
+// packageManagers are the non interactive install and installed check
+// commands of the supported package managers.
+var packageManagers = map[string]struct {
+	install   string
+	installed string
+}{
+	"apt-get": {"DEBIAN_FRONTEND=noninteractive apt-get install -y -q", "dpkg-query -W -f='${Status}\\n' %s 2>/dev/null | grep -q 'ok installed'"},
+	"dnf":     {"dnf install -y -q", "rpm -q %s"},
+	"yum":     {"yum install -y -q", "rpm -q %s"},
+	"apk":     {"apk add --no-progress -q", "apk info -e %s"},
+	"zypper":  {"zypper --non-interactive --quiet install", "rpm -q %s"},
+}
+
+// PackagesResult is the result of InstallPackages.
+type PackagesResult struct {
+
+	// Manager is the package manager used.
+	Manager string
+
+	// Installed are the packages installed by the call.
+	Installed []string
+
+	// Present are the packages that were already installed.
+	Present []string
+
+	// Output is the install command output.
+	Output []byte
+}
+
+// InstallPackages installs the missing packages with the package manager
+// detected by Facts, the install runs with Config.Sudo when set. Nothing
+// is run when all the packages are already installed.
+func (c Client) InstallPackages(ctx context.Context, names ...string) (*PackagesResult, error) {
+
+	for _, name := range names {
+		if name == "" || strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("invalid package name %q", name)
+		}
+	}
+
+	facts, err := c.Facts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pm, ok := packageManagers[facts.PackageManager]
+	if !ok {
+		return nil, ErrNoPackageManager
+	}
+
+	res := &PackagesResult{Manager: facts.PackageManager}
+
+	var quoted []string
+	for _, name := range names {
+		if _, err := c.RunContext(ctx, ShellSh.Wrap(fmt.Sprintf(pm.installed, ShellSh.Quote(name)))); err == nil {
+			res.Present = append(res.Present, name)
+			continue
+		}
+		res.Installed = append(res.Installed, name)
+		quoted = append(quoted, ShellSh.Quote(name))
+	}
+
+	if len(quoted) == 0 {
+		return res, nil
+	}
+
+	res.Output, err = c.privileged(ctx, pm.install+" "+strings.Join(quoted, " "), nil)
+	if err != nil {
+		res.Installed = nil
+		return res, fmt.Errorf("%s: %w", facts.PackageManager, err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// defaultChunkSize is the parallel download chunk size when none is given.
+const defaultChunkSize = 4 << 20
+
+// WithParallel makes Download fetch files larger than chunkSize with workers
+// concurrent ranged reads, each worker opening its own remote handle. It
+// speeds up large downloads over high latency links. A chunkSize of 0 means
+// 4 MiB, line ending conversion always downloads sequentially.
+func WithParallel(workers int, chunkSize int64) TransferOption {
+	return func(o *transferOptions) {
+		o.workers, o.chunkSize = workers, chunkSize
+		if o.chunkSize <= 0 {
+			o.chunkSize = defaultChunkSize
+		}
+	}
+}
+
+// parallel reports whether a file of size is downloaded in chunks.
+func (o *transferOptions) parallel(size int64) bool {
+	return o.workers > 1 && o.eol == "" && size > o.chunkSize
+}
+
+// parallelDownload reads the size bytes of remotePath in chunks written at
+// their offset in local, then verifies the checksum option.
+func (o *transferOptions) parallelDownload(ftp *sftp.Client, remotePath string, local *os.File, size int64) error {
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		mu       sync.Mutex
+		firstErr error
+		done     int64
+		start    = time.Now()
+		stop     = make(chan struct{})
+		chunks   = make(chan int64)
+	)
+
+	fail := func(e error) {
+		once.Do(func() {
+			firstErr = e
+			close(stop)
+		})
+	}
+
+	go func() {
+		defer close(chunks)
+		for off := int64(0); off < size; off += o.chunkSize {
+			select {
+			case chunks <- off:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+
+			defer wg.Done()
+
+			remote, err := ftp.Open(remotePath)
+			if err != nil {
+				fail(fileError(err))
+				return
+			}
+			defer remote.Close()
+
+			buf := make([]byte, o.chunkSize)
+			for off := range chunks {
+
+				chunk := buf
+				if size-off < int64(len(chunk)) {
+					chunk = chunk[:size-off]
+				}
+
+				n, err := remote.ReadAt(chunk, off)
+				if err == io.EOF && n == len(chunk) {
+					err = nil
+				}
+				if err == nil {
+					_, err = local.WriteAt(chunk, off)
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				if o.progress != nil {
+					mu.Lock()
+					done += int64(n)
+					o.progress(Progress{Transferred: done, Total: size, Rate: float64(done) / time.Since(start).Seconds()})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	h, err := o.newHash()
+	if err != nil || h == nil {
+		return err
+	}
+
+	if _, err = io.Copy(h, io.NewSectionReader(local, 0, size)); err != nil {
+		return err
+	}
+
+	return o.verify(h)
+}
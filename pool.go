@@ -0,0 +1,351 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolExhausted is returned when the pool reached MaxSize and has no idle client to evict.
+	ErrPoolExhausted = errors.New("pool exhausted")
+
+	// ErrPoolClosed is returned when getting a client from a closed pool.
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// Pool keeps connected clients per host for reuse.
+type Pool struct {
+
+	// Max number of open clients, idle and in use, 0 means unlimited.
+	MaxSize int
+
+	// Idle clients are closed after IdleTimeout, 0 means never.
+	IdleTimeout time.Duration
+
+	// HealthCheck validates an idle client on checkout, nil means a keepalive request.
+	HealthCheck func(*Client) error
+
+	// HealthTimeout bounds HealthCheck, a client not answering in time is
+	// closed. 0 means DefaultTimeout.
+	HealthTimeout time.Duration
+
+	// Breaker optionally guards new connections.
+	Breaker *Breaker
+
+	mu     sync.Mutex
+	idle   map[string][]*idleClient
+	active map[*Client]string
+	stats  PoolStats
+	closed bool
+	done   chan struct{}
+}
+
+// PoolStats are pool counters.
+type PoolStats struct {
+	Hits           uint64
+	Misses         uint64
+	Evictions      uint64
+	HealthFailures uint64
+	Open           int
+	Idle           int
+}
+
+type idleClient struct {
+	client *Client
+	since  time.Time
+}
+
+// NewPool returns new pool.
+func NewPool(maxSize int, idleTimeout time.Duration) *Pool {
+	return &Pool{
+		MaxSize:     maxSize,
+		IdleTimeout: idleTimeout,
+	}
+}
+
+// Get returns an idle client of the config host or connects a new one.
+func (p *Pool) Get(c *Config) (*Client, error) {
+
+	key := poolKey(c)
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		p.init()
+
+		ic := p.pop(key)
+		p.mu.Unlock()
+
+		if ic == nil {
+			break
+		}
+
+		if err := p.check(ic.client); err != nil {
+			ic.client.Close()
+			p.mu.Lock()
+			p.stats.HealthFailures++
+			p.stats.Open--
+			p.mu.Unlock()
+			continue
+		}
+
+		p.mu.Lock()
+		p.active[ic.client] = key
+		p.stats.Hits++
+		p.mu.Unlock()
+		return ic.client, nil
+	}
+
+	p.mu.Lock()
+	if p.MaxSize > 0 && p.stats.Open >= p.MaxSize && !p.evictOldest() {
+		p.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	p.stats.Open++
+	p.stats.Misses++
+	p.mu.Unlock()
+
+	var (
+		err    error
+		client *Client
+	)
+
+	if p.Breaker != nil {
+		client, err = p.Breaker.NewClient(c)
+	} else {
+		client, err = NewClient(c)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.stats.Open--
+		return nil, err
+	}
+
+	p.active[client] = key
+	return client, nil
+}
+
+// Put returns client to the pool.
+func (p *Pool) Put(client *Client) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.active[client]
+	if !ok {
+		return
+	}
+	delete(p.active, client)
+
+	if p.closed {
+		p.stats.Open--
+		client.Close()
+		return
+	}
+
+	p.idle[key] = append(p.idle[key], &idleClient{client: client, since: time.Now()})
+	p.stats.Idle++
+}
+
+// Discard closes a broken client instead of returning it to the pool.
+func (p *Pool) Discard(client *Client) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.active[client]; ok {
+		delete(p.active, client)
+		p.stats.Open--
+	}
+
+	client.Close()
+}
+
+// Stats returns pool counters.
+func (p *Pool) Stats() PoolStats {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// Close closes idle clients, clients in use are closed when put back.
+func (p *Pool) Close() error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if p.done != nil {
+		close(p.done)
+	}
+
+	for key, list := range p.idle {
+		for _, ic := range list {
+			ic.client.Close()
+			p.stats.Open--
+		}
+		delete(p.idle, key)
+	}
+	p.stats.Idle = 0
+
+	return nil
+}
+
+// init lazily allocates the pool state and starts the idle eviction loop.
+func (p *Pool) init() {
+
+	if p.idle != nil {
+		return
+	}
+
+	p.idle = make(map[string][]*idleClient)
+	p.active = make(map[*Client]string)
+
+	if p.IdleTimeout > 0 {
+		p.done = make(chan struct{})
+		go p.evictLoop()
+	}
+}
+
+func (p *Pool) evictLoop() {
+
+	ticker := time.NewTicker(checkInterval(p.IdleTimeout, 2))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for key, list := range p.idle {
+				kept := list[:0]
+				for _, ic := range list {
+					if now.Sub(ic.since) < p.IdleTimeout {
+						kept = append(kept, ic)
+						continue
+					}
+					ic.client.Close()
+					p.stats.Evictions++
+					p.stats.Open--
+					p.stats.Idle--
+				}
+				if len(kept) == 0 {
+					delete(p.idle, key)
+				} else {
+					p.idle[key] = kept
+				}
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// pop returns the most recently used idle client of key, or nil.
+func (p *Pool) pop(key string) *idleClient {
+
+	list := p.idle[key]
+	if len(list) == 0 {
+		return nil
+	}
+
+	ic := list[len(list)-1]
+	if len(list) == 1 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = list[:len(list)-1]
+	}
+	p.stats.Idle--
+
+	return ic
+}
+
+// evictOldest closes the idle client unused for the longest time.
+func (p *Pool) evictOldest() bool {
+
+	var (
+		oldKey string
+		oldIdx = -1
+		oldest time.Time
+	)
+
+	for key, list := range p.idle {
+		for i, ic := range list {
+			if oldIdx == -1 || ic.since.Before(oldest) {
+				oldKey, oldIdx, oldest = key, i, ic.since
+			}
+		}
+	}
+
+	if oldIdx == -1 {
+		return false
+	}
+
+	list := p.idle[oldKey]
+	list[oldIdx].client.Close()
+	list = append(list[:oldIdx], list[oldIdx+1:]...)
+	if len(list) == 0 {
+		delete(p.idle, oldKey)
+	} else {
+		p.idle[oldKey] = list
+	}
+
+	p.stats.Evictions++
+	p.stats.Open--
+	p.stats.Idle--
+	return true
+}
+
+// check runs the health check of client within HealthTimeout, a half open
+// connection never answers the keepalive.
+func (p *Pool) check(client *Client) error {
+
+	check := p.HealthCheck
+	if check == nil {
+		check = func(c *Client) error {
+			_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+			return err
+		}
+	}
+
+	timeout := p.HealthTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(client)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return wrapError(ErrConnTimeout, fmt.Errorf("health check timed out after %s", timeout))
+	}
+}
+
+func poolKey(c *Config) string {
+	return fmt.Sprintf("%s@%s:%d", c.ClientConfig.User, c.Addr, c.Port)
+}
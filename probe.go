@@ -0,0 +1,113 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ProbeHostKeyAlgorithms are the host key algorithms requested by Probe.
+var ProbeHostKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+}
+
+// errProbed aborts the handshake once the host key is received.
+var errProbed = errors.New("probed")
+
+// ProbeResult is the server information collected without authentication.
+type ProbeResult struct {
+	Addr          string
+	ServerVersion string
+	HostKeys      []ProbeKey
+}
+
+// ProbeKey is a server host key.
+type ProbeKey struct {
+	Type        string
+	Fingerprint string
+	Key         ssh.PublicKey
+}
+
+// Probe connects to addr, records the server version and host keys then
+// disconnects without authenticating. The port defaults to DefaultPort. Each host key
+// algorithm costs a TCP connection, timeout bounds each of them.
+func Probe(addr string, timeout time.Duration) (*ProbeResult, error) {
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(DefaultPort))
+	}
+
+	result := &ProbeResult{Addr: addr}
+
+	var lastErr error
+
+	for _, algo := range ProbeHostKeyAlgorithms {
+
+		version, key, err := probeKey(addr, algo, timeout)
+		if result.ServerVersion == "" {
+			result.ServerVersion = version
+		}
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		result.HostKeys = append(result.HostKeys, ProbeKey{
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Key:         key,
+		})
+	}
+
+	if len(result.HostKeys) == 0 && result.ServerVersion == "" {
+		return nil, lastErr
+	}
+
+	return result, nil
+}
+
+// probeKey handshakes with a single host key algorithm.
+func probeKey(addr string, algo string, timeout time.Duration) (version string, key ssh.PublicKey, err error) {
+
+	nconn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conn := &recordConn{Conn: nconn}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:              "probe",
+		Timeout:           timeout,
+		HostKeyAlgorithms: []string{algo},
+		HostKeyCallback: func(host string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errProbed
+		},
+	}
+
+	_, _, _, err = ssh.NewClientConn(conn, addr, config)
+	version, _ = serverVersion(conn.stop())
+
+	if key != nil {
+		return version, key, nil
+	}
+
+	return version, nil, err
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ShellOption configures Client.Shell.
+type ShellOption func(*shellOptions)
+
+type shellOptions struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	term   string
+	modes  ssh.TerminalModes
+	width  int
+	height int
+	filter bool
+}
+
+// WithShellIO sets the shell stdin, stdout and stderr, os.Stdin, os.Stdout
+// and os.Stderr by default.
+func WithShellIO(stdin io.Reader, stdout io.Writer, stderr io.Writer) ShellOption {
+	return func(o *shellOptions) {
+		o.stdin, o.stdout, o.stderr = stdin, stdout, stderr
+	}
+}
+
+// WithTerm sets the PTY terminal type, $TERM or xterm by default.
+func WithTerm(term string) ShellOption {
+	return func(o *shellOptions) {
+		o.term = term
+	}
+}
+
+// WithTerminalModes sets the PTY modes, echo on by default.
+func WithTerminalModes(modes ssh.TerminalModes) ShellOption {
+	return func(o *shellOptions) {
+		o.modes = modes
+	}
+}
+
+// WithPty requests a PTY of width columns and height rows even when stdin
+// isn't a terminal, the terminal size takes precedence otherwise.
+func WithPty(width int, height int) ShellOption {
+	return func(o *shellOptions) {
+		o.width, o.height = width, height
+	}
+}
+
+// WithTerminalFilter protects the local terminal, the shell output goes
+// through NewTerminalFilter and the input through NewPasteFilter.
+func WithTerminalFilter() ShellOption {
+	return func(o *shellOptions) {
+		o.filter = true
+	}
+}
+
+// Shell runs an interactive login shell until it exits, like ssh without a
+// command. When stdin is a terminal it's put in raw mode and restored after,
+// a PTY of its size is requested and its size changes are sent to the server.
+// A shell exiting with a non zero status returns an *ExitError.
+func (c Client) Shell(opts ...ShellOption) error {
+
+	o := &shellOptions{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		term:   os.Getenv("TERM"),
+		modes:  ssh.TerminalModes{ssh.ECHO: 1},
+	}
+
+	if o.term == "" {
+		o.term = "xterm"
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	sess.Stdin, sess.Stdout, sess.Stderr = o.stdin, o.stdout, o.stderr
+	if o.filter {
+		sess.Stdin = NewPasteFilter(o.stdin)
+		sess.Stdout = NewTerminalFilter(o.stdout)
+		sess.Stderr = NewTerminalFilter(o.stderr)
+	}
+
+	f, tty := o.stdin.(*os.File)
+	tty = tty && terminal.IsTerminal(int(f.Fd()))
+
+	width, height := o.width, o.height
+	if tty {
+		fd := int(f.Fd())
+
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, state)
+
+		if w, h, err := terminal.GetSize(fd); err == nil {
+			width, height = w, h
+		} else if width <= 0 {
+			width, height = 80, 24
+		}
+
+		defer watchResize(fd, sess)()
+	}
+
+	if width > 0 {
+		if err = sess.RequestPty(o.term, height, width, o.modes); err != nil {
+			return err
+		}
+	}
+
+	if err = sess.Shell(); err != nil {
+		return err
+	}
+
+	return exitError(sess.Wait(), nil)
+}
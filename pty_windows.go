@@ -0,0 +1,44 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+//go:build windows
+// +build windows
+
+package goph
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// resizeInterval is the console size polling interval, Windows has no SIGWINCH.
+const resizeInterval = 250 * time.Millisecond
+
+// watchResize sends the console size changes to sess until stopped.
+func watchResize(fd int, sess *ssh.Session) (stop func()) {
+
+	done := make(chan struct{})
+	width, height, _ := terminal.GetSize(fd)
+
+	go func() {
+		ticker := time.NewTicker(resizeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w, h, err := terminal.GetSize(fd)
+				if err == nil && (w != width || h != height) {
+					width, height = w, h
+					sess.WindowChange(h, w)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed is returned when adding a transfer to a closed queue.
+var ErrQueueClosed = errors.New("transfer queue closed")
+
+// TransferState is the state of a queued transfer.
+type TransferState int
+
+// Transfer states.
+const (
+	TransferQueued TransferState = iota
+	TransferRunning
+	TransferDone
+	TransferFailed
+)
+
+func (s TransferState) String() string {
+	switch s {
+	case TransferQueued:
+		return "queued"
+	case TransferRunning:
+		return "running"
+	case TransferDone:
+		return "done"
+	case TransferFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// TransferStatus is a snapshot of a queued transfer.
+type TransferStatus struct {
+	ID       int
+	Upload   bool
+	Local    string
+	Remote   string
+	Priority int
+	State    TransferState
+	Err      error
+	Started  time.Time
+	Finished time.Time
+}
+
+// Transfer is an upload or download added to a TransferQueue.
+type Transfer struct {
+	q      *TransferQueue
+	status TransferStatus
+	opts   []TransferOption
+	done   chan struct{}
+}
+
+// Status returns the transfer state.
+func (t *Transfer) Status() TransferStatus {
+
+	t.q.mu.Lock()
+	defer t.q.mu.Unlock()
+
+	return t.status
+}
+
+// Wait blocks until the transfer finished and returns its error.
+func (t *Transfer) Wait() error {
+
+	<-t.done
+
+	return t.Status().Err
+}
+
+// TransferQueue runs uploads and downloads of a client with a concurrency
+// limit, higher priorities first and in order within a priority.
+type TransferQueue struct {
+	client  Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	cond    *sync.Cond
+	wg      sync.WaitGroup
+	pending []*Transfer
+	all     []*Transfer
+	paused  bool
+	closed  bool
+}
+
+// NewTransferQueue starts a queue running up to workers transfers at once,
+// at least one.
+func (c Client) NewTransferQueue(workers int) *TransferQueue {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	q := &TransferQueue{client: c}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.cond = sync.NewCond(&q.mu)
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Upload queues the upload of localPath to remotePath.
+func (q *TransferQueue) Upload(localPath string, remotePath string, priority int, opts ...TransferOption) (*Transfer, error) {
+	return q.add(true, localPath, remotePath, priority, opts)
+}
+
+// Download queues the download of remotePath to localPath.
+func (q *TransferQueue) Download(remotePath string, localPath string, priority int, opts ...TransferOption) (*Transfer, error) {
+	return q.add(false, localPath, remotePath, priority, opts)
+}
+
+func (q *TransferQueue) add(upload bool, local, remote string, priority int, opts []TransferOption) (*Transfer, error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+
+	t := &Transfer{
+		q:    q,
+		opts: opts,
+		done: make(chan struct{}),
+		status: TransferStatus{
+			ID:       len(q.all) + 1,
+			Upload:   upload,
+			Local:    local,
+			Remote:   remote,
+			Priority: priority,
+		},
+	}
+
+	// Insert after the transfers of the same or a higher priority.
+	i := len(q.pending)
+	for i > 0 && q.pending[i-1].status.Priority < priority {
+		i--
+	}
+	q.pending = append(q.pending, nil)
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = t
+
+	q.all = append(q.all, t)
+	q.cond.Signal()
+
+	return t, nil
+}
+
+// Pause stops starting queued transfers, running ones complete.
+func (q *TransferQueue) Pause() {
+
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
+}
+
+// Resume starts the queued transfers again.
+func (q *TransferQueue) Resume() {
+
+	q.mu.Lock()
+	q.paused = false
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// Status returns the state of every transfer added to the queue.
+func (q *TransferQueue) Status() []TransferStatus {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := make([]TransferStatus, len(q.all))
+	for i, t := range q.all {
+		s[i] = t.status
+	}
+
+	return s
+}
+
+// Close runs the queued transfers, even when paused, and waits for them.
+func (q *TransferQueue) Close() {
+
+	q.mu.Lock()
+	q.closed, q.paused = true, false
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+	q.wg.Wait()
+	q.cancel()
+}
+
+// Cancel stops the running transfers, fails the queued ones with
+// context.Canceled and waits for the workers.
+func (q *TransferQueue) Cancel() {
+
+	q.cancel()
+
+	q.mu.Lock()
+	q.closed, q.paused = true, false
+	for _, t := range q.pending {
+		q.finish(t, context.Canceled)
+	}
+	q.pending = nil
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+	q.wg.Wait()
+}
+
+func (q *TransferQueue) work() {
+
+	defer q.wg.Done()
+
+	for {
+		q.mu.Lock()
+		for !q.closed && (q.paused || len(q.pending) == 0) {
+			q.cond.Wait()
+		}
+
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			return
+		}
+
+		t := q.pending[0]
+		q.pending = q.pending[1:]
+		t.status.State, t.status.Started = TransferRunning, time.Now()
+		q.mu.Unlock()
+
+		var err error
+		if t.status.Upload {
+			err = q.client.UploadContext(q.ctx, t.status.Local, t.status.Remote, t.opts...)
+		} else {
+			err = q.client.DownloadContext(q.ctx, t.status.Remote, t.status.Local, t.opts...)
+		}
+
+		q.mu.Lock()
+		q.finish(t, err)
+		q.mu.Unlock()
+	}
+}
+
+// finish records the transfer result, q.mu must be held.
+func (q *TransferQueue) finish(t *Transfer, err error) {
+
+	t.status.State, t.status.Err, t.status.Finished = TransferDone, err, time.Now()
+	if err != nil {
+		t.status.State = TransferFailed
+	}
+
+	close(t.done)
+}
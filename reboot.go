@@ -0,0 +1,88 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRebootTimeout is returned by Reboot when the host isn't back in time.
+var ErrRebootTimeout = errors.New("reboot timeout")
+
+// RebootPollInterval is the delay between Reboot connection attempts.
+var RebootPollInterval = 2 * time.Second
+
+// Reboot reboots the host, with Config.Sudo when set, then waits up to
+// waitTimeout for ssh to be back and returns the new connection client.
+// The overrides apply to the new connection, like WithHostKeyCallback when
+// the host key is expected to change. The client is closed.
+func (c Client) Reboot(ctx context.Context, waitTimeout time.Duration, overrides ...Option) (*Client, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+
+	dropped := make(chan struct{})
+	go func() {
+		c.Client.Wait()
+		close(dropped)
+	}()
+
+	// The connection usually drops before reboot returns, an error of a
+	// connection still alive is a failure to reboot.
+	if _, err := c.privileged(ctx, "reboot", nil); err != nil && c.ping(RebootPollInterval) {
+		return nil, fmt.Errorf("reboot: %w", err)
+	}
+
+	c.Config.logf("reboot: waiting for the connection to drop")
+	if err := c.waitDrop(ctx, dropped); err != nil {
+		return nil, err
+	}
+	c.Close()
+
+	config := c.Config.clone(overrides)
+	for {
+		client, err := NewClientContext(ctx, config)
+		if err == nil {
+			return client, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, wrapError(ErrRebootTimeout, err)
+		}
+
+		if !isNetworkError(err) {
+			return nil, fmt.Errorf("reboot: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, wrapError(ErrRebootTimeout, err)
+		case <-time.After(RebootPollInterval):
+		}
+	}
+}
+
+// waitDrop waits for the connection to close, a host going down without
+// closing it is detected when it stops answering pings.
+func (c Client) waitDrop(ctx context.Context, dropped chan struct{}) error {
+
+	ticker := time.NewTicker(RebootPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dropped:
+			return nil
+		case <-ctx.Done():
+			return wrapError(ErrRebootTimeout, errors.New("connection still up"))
+		case <-ticker.C:
+			if !c.ping(RebootPollInterval) {
+				return nil
+			}
+		}
+	}
+}
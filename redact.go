@@ -0,0 +1,118 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces secrets in redacted strings.
+const redacted = "***"
+
+// Redactor masks registered secrets, a nil Redactor masks nothing.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns a redactor of secrets.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add registers secrets, empty strings are ignored.
+func (r *Redactor) Add(secrets ...string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	// Longest secrets first so a secret containing another is fully masked.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+
+	pairs := make([]string, 0, 2*len(r.secrets))
+	for _, secret := range r.secrets {
+		pairs = append(pairs, secret, redacted)
+	}
+
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with the secrets masked.
+func (r *Redactor) Redact(s string) string {
+
+	if r == nil {
+		return s
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.replacer == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// RedactError returns err with the secrets masked in its message, the
+// original error is kept for errors.Is and errors.As.
+func (r *Redactor) RedactError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	if msg := r.Redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+
+	return err
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactor returns the config redactor, nil safe.
+func (c *Config) redactor() *Redactor {
+	if c == nil {
+		return nil
+	}
+	return c.Redactor
+}
+
+// onCommand returns OnCommand with redacted labels.
+func (c *Config) onCommand() func(label string, duration time.Duration, exitCode int) {
+
+	if c.OnCommand == nil || c.Redactor == nil {
+		return c.OnCommand
+	}
+
+	return func(label string, duration time.Duration, exitCode int) {
+		c.OnCommand(c.Redactor.Redact(label), duration, exitCode)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CertRenewer holds a short lived certificate signer, the certificate is
+// renewed with Provider when it expires within Margin.
+type CertRenewer struct {
+
+	// Provider returns a fresh certificate signer, see ssh.NewCertSigner.
+	Provider func(ctx context.Context) (ssh.Signer, error)
+
+	// Margin renews certificates this long before they expire.
+	Margin time.Duration
+
+	mu     sync.Mutex
+	signer ssh.Signer
+}
+
+// NewCertRenewer returns a renewer of provider certificates.
+func NewCertRenewer(provider func(ctx context.Context) (ssh.Signer, error), margin time.Duration) *CertRenewer {
+	return &CertRenewer{Provider: provider, Margin: margin}
+}
+
+// Signer returns the current certificate signer, renewed if it expires soon.
+func (r *CertRenewer) Signer() (ssh.Signer, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.signer != nil && !r.expires(r.signer) {
+		return r.signer, nil
+	}
+
+	return r.renew()
+}
+
+// Renew replaces the current certificate even if it's still valid.
+func (r *CertRenewer) Renew() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.renew()
+	return err
+}
+
+func (r *CertRenewer) renew() (ssh.Signer, error) {
+
+	signer, err := r.Provider(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	r.signer = signer
+
+	return signer, nil
+}
+
+func (r *CertRenewer) expires(signer ssh.Signer) bool {
+
+	cert, ok := signer.PublicKey().(*ssh.Certificate)
+	if !ok || cert.ValidBefore == ssh.CertTimeInfinity {
+		return false
+	}
+
+	return time.Now().Add(r.Margin).Unix() >= int64(cert.ValidBefore)
+}
+
+// Auth returns auth method using the current certificate.
+func (r *CertRenewer) Auth() Auth {
+	return Auth{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+
+			signer, err := r.Signer()
+			if err != nil {
+				return nil, err
+			}
+
+			return []ssh.Signer{signer}, nil
+		}),
+	}
+}
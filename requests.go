@@ -0,0 +1,79 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// GlobalRequestHandler handles a global request sent by the server, ok and
+// payload are the reply sent back when the server wants one. Handlers run
+// one at a time on the connection request loop, as replies must be sent in
+// the order of the requests: a slow handler delays every later request,
+// including keepalives, so long work must be done in another goroutine.
+type GlobalRequestHandler func(req *ssh.Request) (ok bool, payload []byte)
+
+// requestRegistry dispatches incoming global requests by name.
+type requestRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]GlobalRequestHandler
+}
+
+func newRequestRegistry(handlers map[string]GlobalRequestHandler) *requestRegistry {
+
+	r := &requestRegistry{handlers: make(map[string]GlobalRequestHandler)}
+	for name, h := range handlers {
+		r.handlers[name] = h
+	}
+
+	return r
+}
+
+// serve handles reqs until the connection closes, unknown requests are refused.
+func (r *requestRegistry) serve(reqs <-chan *ssh.Request) {
+
+	for req := range reqs {
+
+		r.mu.RLock()
+		h, ok := r.handlers[req.Type]
+		r.mu.RUnlock()
+
+		if !ok {
+			req.Reply(false, nil)
+			continue
+		}
+
+		ok, payload := h(req)
+		req.Reply(ok, payload)
+	}
+}
+
+// HandleGlobalRequest registers h for the server global requests of name,
+// a nil handler removes it. Use Config.GlobalRequests for requests sent
+// right after the handshake. It does nothing on a Client not created by
+// NewClient, its requests are not served.
+func (c Client) HandleGlobalRequest(name string, h GlobalRequestHandler) {
+
+	if c.requests == nil {
+		return
+	}
+
+	c.requests.mu.Lock()
+	defer c.requests.mu.Unlock()
+
+	if h == nil {
+		delete(c.requests.handlers, name)
+		return
+	}
+
+	c.requests.handlers[name] = h
+}
+
+// SendGlobalRequest sends a global request to the server, it returns the
+// server reply when wantReply is true.
+func (c Client) SendGlobalRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return c.Client.SendRequest(name, wantReply, payload)
+}
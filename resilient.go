@@ -0,0 +1,246 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrResilientClosed is returned by a closed ResilientClient.
+var ErrResilientClosed = errors.New("resilient client closed")
+
+// RetryPolicy sets how a ResilientClient reconnects.
+type RetryPolicy struct {
+
+	// MaxAttempts per reconnection, 0 means unlimited.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the second attempt, 0 means 1s.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts, 0 means 30s.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after each attempt, 0 means 2.
+	Multiplier float64
+
+	// OnReconnect is called with the new client after a connection loss.
+	OnReconnect func(c *Client, attempts int)
+}
+
+// backoff returns the delay after attempt n, starting at 1.
+func (p RetryPolicy) backoff(n int) time.Duration {
+
+	delay, max, mult := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if delay <= 0 {
+		delay = time.Second
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if mult <= 0 {
+		mult = 2
+	}
+
+	for i := 1; i < n && delay < max; i++ {
+		delay = time.Duration(float64(delay) * mult)
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// ResilientClient keeps a client connected, it dials again with the retry
+// policy when the connection is lost.
+type ResilientClient struct {
+	config *Config
+	policy RetryPolicy
+
+	mu      sync.Mutex
+	current *liveClient
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+type liveClient struct {
+	*Client
+	lost chan struct{}
+}
+
+// NewResilientClient connects with the retry policy and reconnects in the
+// background when the connection is lost. Only network errors are retried,
+// like NewClient fails over.
+func NewResilientClient(c *Config, policy RetryPolicy) (*ResilientClient, error) {
+
+	r := &ResilientClient{config: c, policy: policy, done: make(chan struct{})}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.connect(false); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Client returns the connected client, it reconnects first when the
+// connection is lost. The client must not be closed by the caller.
+func (r *ResilientClient) Client() (*Client, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, ErrResilientClosed
+	}
+
+	if r.current != nil {
+		select {
+		case <-r.current.lost:
+		default:
+			return r.current.Client, nil
+		}
+	}
+
+	return r.connect(true)
+}
+
+// Do calls fn with the connected client, when fn fails because the
+// connection was lost it's called again once reconnected. fn must be safe
+// to repeat.
+func (r *ResilientClient) Do(fn func(c *Client) error) error {
+
+	c, err := r.Client()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(c); err == nil || !r.lost(c) {
+		return err
+	}
+
+	if c, err = r.Client(); err != nil {
+		return err
+	}
+
+	return fn(c)
+}
+
+// Run runs cmd with Do and returns its combined output.
+func (r *ResilientClient) Run(cmd string) (out []byte, err error) {
+
+	err = r.Do(func(c *Client) error {
+		out, err = c.Run(cmd)
+		return err
+	})
+
+	return out, err
+}
+
+// Close closes the client and stops reconnecting.
+func (r *ResilientClient) Close() error {
+
+	// Stops a pending reconnection holding the lock.
+	r.once.Do(func() { close(r.done) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	if r.current != nil {
+		return r.current.Close()
+	}
+
+	return nil
+}
+
+// lost reports whether the connection of c is lost, a keepalive tells when
+// the connection end isn't noticed yet. A lost client is dropped so that
+// the next Client call dials again.
+func (r *ResilientClient) lost(c *Client) bool {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current == nil || r.current.Client != c {
+		return true
+	}
+
+	select {
+	case <-r.current.lost:
+	default:
+		if c.ping(time.Second) {
+			return false
+		}
+		c.Close()
+	}
+
+	r.current = nil
+
+	return true
+}
+
+// connect dials with the retry policy, r.mu must be held.
+func (r *ResilientClient) connect(reconnect bool) (*Client, error) {
+
+	for attempt := 1; ; attempt++ {
+
+		client, err := NewClient(r.config)
+		if err == nil {
+			live := &liveClient{Client: client, lost: make(chan struct{})}
+			r.current = live
+			go r.watch(live)
+
+			if reconnect && r.policy.OnReconnect != nil {
+				r.policy.OnReconnect(client, attempt)
+			}
+			return client, nil
+		}
+
+		if !isNetworkError(err) {
+			return nil, err
+		}
+
+		if r.policy.MaxAttempts > 0 && attempt >= r.policy.MaxAttempts {
+			return nil, fmt.Errorf("connect failed after %d attempts: %w", attempt, err)
+		}
+
+		r.config.logf("connect attempt %d failed: %s", attempt, err)
+
+		timer := time.NewTimer(r.policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-r.done:
+			timer.Stop()
+			return nil, ErrResilientClosed
+		}
+	}
+}
+
+// watch reconnects in the background once the connection of live is lost.
+func (r *ResilientClient) watch(live *liveClient) {
+
+	err := live.Wait()
+	close(live.lost)
+
+	if err == nil {
+		// Closed by Close or by a caller.
+		return
+	}
+
+	r.Client()
+}
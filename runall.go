@@ -0,0 +1,113 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"time"
+)
+
+// Command is a command of RunAll.
+type Command struct {
+
+	// Name labels the command in the report, empty means Cmd.
+	Name string
+
+	// Cmd is the command line.
+	Cmd string
+
+	// StopOnError skips the next commands when this one fails.
+	StopOnError bool
+}
+
+// CommandStatus is the status of a RunAll command.
+type CommandStatus string
+
+// Statuses of RunAll commands.
+const (
+	StatusOK      CommandStatus = "ok"
+	StatusFailed  CommandStatus = "failed"
+	StatusSkipped CommandStatus = "skipped"
+)
+
+// CommandResult is the result of a RunAll command.
+type CommandResult struct {
+	Name     string
+	Status   CommandStatus
+	Output   []byte
+	ExitCode int
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Report is the ordered results of RunAll.
+type Report struct {
+	Results  []CommandResult
+	Duration time.Duration
+}
+
+// OK reports whether all the commands succeeded.
+func (r *Report) OK() bool {
+
+	for _, res := range r.Results {
+		if res.Status != StatusOK {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RunAll runs cmds in order and returns a report with every command result,
+// the commands after a failed StopOnError command or a done ctx are skipped.
+func (c Client) RunAll(ctx context.Context, cmds []Command) *Report {
+
+	var (
+		report = &Report{Results: make([]CommandResult, 0, len(cmds))}
+		start  = time.Now()
+		stop   bool
+	)
+
+	for _, cmd := range cmds {
+
+		res := CommandResult{Name: cmd.Name, Status: StatusSkipped, ExitCode: -1}
+		if res.Name == "" {
+			res.Name = c.Config.redactor().Redact(cmd.Cmd)
+		}
+
+		if !stop && ctx.Err() == nil {
+
+			res.Start = time.Now()
+			res.Output, res.Err = c.runLabeled(ctx, res.Name, cmd.Cmd)
+			res.Duration = time.Since(res.Start)
+			res.ExitCode = exitCode(res.Err)
+
+			res.Status = StatusOK
+			if res.Err != nil {
+				res.Status = StatusFailed
+				stop = cmd.StopOnError
+			}
+		}
+
+		report.Results = append(report.Results, res)
+	}
+
+	report.Duration = time.Since(start)
+
+	return report
+}
+
+func (c Client) runLabeled(ctx context.Context, label string, cmdline string) ([]byte, error) {
+
+	cmd, err := c.CommandContext(ctx, cmdline)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Session.Close()
+
+	cmd.Label = label
+
+	return cmd.CombinedOutput()
+}
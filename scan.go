@@ -0,0 +1,181 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// ScanTimeout bounds each connection of ScanHostKeys.
+	ScanTimeout = 5 * time.Second
+
+	// ScanWorkers is the number of hosts ScanHostKeys probes at once.
+	ScanWorkers = 16
+)
+
+// ScanResult is the host keys of a scanned address.
+type ScanResult struct {
+
+	// Addr is the host:port scanned, the port defaults to DefaultPort.
+	Addr string
+
+	HostKeys []ProbeKey
+
+	// Err is the scan error, nil when at least a key was received.
+	Err error
+}
+
+// KnownHostsLines returns the known_hosts lines of the result keys.
+func (r ScanResult) KnownHostsLines() []string {
+
+	lines := make([]string, len(r.HostKeys))
+	for i, k := range r.HostKeys {
+		lines[i] = knownhosts.Line([]string{knownhosts.Normalize(r.Addr)}, k.Key)
+	}
+
+	return lines
+}
+
+// ScanHostKeys probes the host keys of addrs in parallel like ssh-keyscan,
+// without authenticating. Results are in addrs order, see Probe.
+func ScanHostKeys(addrs []string) []ScanResult {
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]ScanResult, len(addrs))
+		sem     = make(chan struct{}, scanWorkers())
+	)
+
+	for i, addr := range addrs {
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, addr string) {
+			defer func() { <-sem; wg.Done() }()
+
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, strconv.Itoa(DefaultPort))
+			}
+
+			results[i].Addr = addr
+
+			probe, err := Probe(addr, ScanTimeout)
+			switch {
+			case err != nil:
+				results[i].Err = err
+			case len(probe.HostKeys) == 0:
+				results[i].Err = fmt.Errorf("%s: no host key received", addr)
+			default:
+				results[i].HostKeys = probe.HostKeys
+			}
+		}(i, addr)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+func scanWorkers() int {
+	if ScanWorkers > 0 {
+		return ScanWorkers
+	}
+	return 1
+}
+
+// WriteKnownHosts appends the scanned keys missing from the known hosts
+// file, created if needed, and returns the number of keys added. An empty
+// file means the default path. Results with an error are skipped and a host
+// known with another key of the same type isn't changed, the error then
+// wraps ErrHostKeyMismatch.
+func WriteKnownHosts(file string, results []ScanResult) (int, error) {
+
+	if file == "" {
+		path, err := DefaultKnownHostsPath()
+		if err != nil {
+			return 0, err
+		}
+		file = path
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		added      int
+		mismatches []string
+		lines      strings.Builder
+		seen       = make(map[string]bool)
+	)
+
+	for _, r := range results {
+		for i, k := range r.HostKeys {
+
+			known, err := scannedKnown(callback, r.Addr, k.Key)
+			if err != nil {
+				mismatches = append(mismatches, r.Addr+" "+k.Type)
+				continue
+			}
+
+			if line := r.KnownHostsLines()[i]; !known && !seen[line] {
+				seen[line] = true
+				lines.WriteString(line + "\n")
+				added++
+			}
+		}
+	}
+
+	if _, err = f.WriteString(lines.String()); err != nil {
+		return 0, err
+	}
+
+	if len(mismatches) > 0 {
+		return added, wrapError(ErrHostKeyMismatch, errors.New(strings.Join(mismatches, ", ")))
+	}
+
+	return added, nil
+}
+
+// scannedKnown reports whether the key of addr is known, a new key type of
+// a known host isn't a mismatch unlike for knownhosts callbacks.
+func scannedKnown(callback ssh.HostKeyCallback, addr string, key ssh.PublicKey) (bool, error) {
+
+	err := callback(addr, &net.TCPAddr{IP: net.IPv4zero}, key)
+	if err == nil {
+		return true, nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return false, err
+	}
+
+	for _, want := range keyErr.Want {
+		if want.Key.Type() == key.Type() {
+			return false, keyErr
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// UploadSCP uploads a local file with the legacy scp protocol over an exec
+// channel, for servers without the sftp subsystem. The remote host needs
+// the scp command. Progress and checksum options apply, line endings can't
+// be converted as the size is sent first.
+func (c Client) UploadSCP(localPath string, remotePath string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
+	if o.eol != "" {
+		return errors.New("scp: line ending conversion not supported on upload")
+	}
+
+	local, err := os.Open(localPath)
+	if err != nil {
+		return fileError(err)
+	}
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	return c.scp("-t", remotePath, func(w io.Writer, r *bufio.Reader) error {
+
+		if err := scpAck(r); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(remotePath))
+		if err := scpAck(r); err != nil {
+			return err
+		}
+
+		if _, err := o.copy(w, io.LimitReader(local, info.Size()), info.Size()); err != nil {
+			return err
+		}
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+
+		return scpAck(r)
+	})
+}
+
+// DownloadSCP downloads a remote file with the legacy scp protocol, see
+// UploadSCP. All the transfer options but fsync apply.
+func (c Client) DownloadSCP(remotePath string, localPath string, opts ...TransferOption) error {
+
+	o := newTransferOptions(opts)
+
+	return c.scp("-f", remotePath, func(w io.Writer, r *bufio.Reader) error {
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+
+		line, err := scpLine(r)
+		if err != nil {
+			return err
+		}
+
+		var (
+			mode os.FileMode
+			size int64
+			name string
+		)
+		if _, err = fmt.Sscanf(line, "C%o %d %s", &mode, &size, &name); err != nil {
+			return fmt.Errorf("scp: unexpected header %q", line)
+		}
+
+		local, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+
+		if _, err = w.Write([]byte{0}); err != nil {
+			return err
+		}
+
+		if _, err = o.copy(local, io.LimitReader(r, size), size); err != nil {
+			return err
+		}
+
+		if err = scpAck(r); err != nil {
+			return err
+		}
+
+		if _, err = w.Write([]byte{0}); err != nil {
+			return err
+		}
+
+		return local.Sync()
+	})
+}
+
+// scp runs the scp command in mode -t (sink) or -f (source) for remotePath
+// and speaks the protocol with fn.
+func (c Client) scp(mode string, remotePath string, fn func(w io.Writer, r *bufio.Reader) error) error {
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr syncBuffer
+	sess.Stderr = &stderr
+
+	if err = sess.Start("scp " + mode + " " + c.Config.shell().Quote(remotePath)); err != nil {
+		return err
+	}
+
+	if err = fn(stdin, bufio.NewReader(stdout)); err != nil {
+		stdin.Close()
+		sess.Wait()
+		if msg := strings.TrimSpace(string(stderr.Bytes())); msg != "" && !strings.HasPrefix(err.Error(), "scp:") {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+
+	stdin.Close()
+
+	return sess.Wait()
+}
+
+// scpAck reads a protocol status, 1 and 2 are followed by an error message.
+func scpAck(r *bufio.Reader) error {
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if b == 0 {
+		return nil
+	}
+
+	msg, _ := r.ReadString('\n')
+
+	return errors.New("scp: " + strings.TrimSpace(msg))
+}
+
+// scpLine reads a protocol line, an error status is returned as an error.
+func scpLine(r *bufio.Reader) (string, error) {
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	if b == 1 || b == 2 {
+		msg, _ := r.ReadString('\n')
+		return "", errors.New("scp: " + strings.TrimSpace(msg))
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return string(b) + strings.TrimRight(line, "\n"), nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SecretStore fetches secrets by name from a secret manager.
+type SecretStore interface {
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretStoreFunc adapts a function to a SecretStore.
+type SecretStoreFunc func(ctx context.Context, name string) ([]byte, error)
+
+// GetSecret calls f.
+func (f SecretStoreFunc) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+var (
+	// AWSSecretsManager fetches secret strings by ARN or name with the aws cli.
+	AWSSecretsManager SecretStore = cliStore{"aws", "secretsmanager", "get-secret-value", "--query", "SecretString", "--output", "text", "--secret-id"}
+
+	// AWSParameterStore fetches decrypted SSM parameters by name with the aws cli.
+	AWSParameterStore SecretStore = cliStore{"aws", "ssm", "get-parameter", "--with-decryption", "--query", "Parameter.Value", "--output", "text", "--name"}
+
+	// GCPSecretManager fetches secret versions by resource name, like
+	// projects/p/secrets/s/versions/latest, with the gcloud cli.
+	GCPSecretManager SecretStore = cliStore{"gcloud", "secrets", "versions", "access"}
+)
+
+// cliStore runs a cli command with the secret name as last argument.
+type cliStore []string
+
+func (s cliStore) GetSecret(ctx context.Context, name string) ([]byte, error) {
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s[0], append(s[1:], name)...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(s[0] + ": " + msg)
+		}
+		return nil, err
+	}
+
+	return bytes.TrimRight(out, "\r\n"), nil
+}
+
+// Secret is a secret of a store cached for TTL, a zero TTL caches it until
+// Invalidate is called, for example after the secret was rotated.
+type Secret struct {
+	Store SecretStore
+	Name  string
+	TTL   time.Duration
+
+	mu      sync.Mutex
+	value   []byte
+	fetched time.Time
+}
+
+// NewSecret returns the secret name of store cached for ttl.
+func NewSecret(store SecretStore, name string, ttl time.Duration) *Secret {
+	return &Secret{Store: store, Name: name, TTL: ttl}
+}
+
+// Get returns the cached secret or fetches it from the store.
+func (s *Secret) Get(ctx context.Context) ([]byte, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.value != nil && (s.TTL == 0 || time.Since(s.fetched) < s.TTL) {
+		return s.value, nil
+	}
+
+	value, err := s.Store.GetSecret(ctx, s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.value, s.fetched = value, time.Now()
+
+	return value, nil
+}
+
+// Invalidate drops the cached secret, the next Get fetches it again.
+func (s *Secret) Invalidate() {
+	s.mu.Lock()
+	s.value = nil
+	s.mu.Unlock()
+}
+
+// SecretPassword returns password auth method with the password fetched
+// from the secret at every connection.
+func SecretPassword(s *Secret) Auth {
+	return Auth{
+		ssh.PasswordCallback(func() (string, error) {
+			pass, err := s.Get(context.Background())
+			return string(pass), err
+		}),
+	}
+}
+
+// SecretKey returns auth method with the private key fetched from the
+// secret at every connection.
+func SecretKey(s *Secret, passphrase string) Auth {
+	return Auth{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+
+			key, err := s.Get(context.Background())
+			if err != nil {
+				return nil, err
+			}
+
+			signer, err := GetSignerForRawKey(key, passphrase)
+			if err != nil {
+				return nil, err
+			}
+
+			return []ssh.Signer{signer}, nil
+		}),
+	}
+}
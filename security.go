@@ -0,0 +1,144 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SecurityPreset is a set of allowed algorithms applied to a config.
+type SecurityPreset struct {
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+
+	// Min size of RSA host keys, 0 means no check.
+	MinRSABits int
+}
+
+// FIPSPreset restricts algorithms to the FIPS 140-2 approved ones.
+var FIPSPreset = SecurityPreset{
+	KeyExchanges: []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+	},
+	Ciphers: []string{
+		"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
+	},
+	HostKeyAlgorithms: []string{
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	},
+	MinRSABits: 2048,
+}
+
+// ModernPreset only allows modern algorithms, without sha1 or cbc modes.
+var ModernPreset = SecurityPreset{
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+	},
+	Ciphers: []string{
+		"chacha20-poly1305@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
+	},
+	HostKeyAlgorithms: []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	},
+	MinRSABits: 3072,
+}
+
+// LegacyCompat extends the default algorithms with the legacy ones old
+// switches and appliances still require, like diffie-hellman-group1-sha1,
+// cbc ciphers and ssh-rsa signatures. Only use it to reach such devices,
+// the negotiated algorithms are reported as weak, see WeakAlgorithms.
+var LegacyCompat = SecurityPreset{
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		"diffie-hellman-group1-sha1",
+	},
+	Ciphers: []string{
+		"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	},
+	HostKeyAlgorithms: append([]string(nil), defaultHostKeyAlgorithms...),
+}
+
+// ApplyPreset sets the config algorithms to the preset ones.
+func (c *Config) ApplyPreset(p SecurityPreset) {
+
+	c.ClientConfig.KeyExchanges = p.KeyExchanges
+	c.ClientConfig.Ciphers = p.Ciphers
+	c.ClientConfig.MACs = p.MACs
+	c.ClientConfig.HostKeyAlgorithms = p.HostKeyAlgorithms
+	c.MinRSABits = p.MinRSABits
+}
+
+// checkHostKey returns an error if key violates the config policy.
+func (c *Config) checkHostKey(key ssh.PublicKey) error {
+
+	if len(c.PinnedHostKeyAlgorithms) > 0 {
+		pinned := false
+		for _, algo := range c.PinnedHostKeyAlgorithms {
+			if keyFormat(algo) == key.Type() {
+				pinned = true
+				break
+			}
+		}
+
+		if !pinned {
+			return wrapError(ErrHostKeyMismatch, fmt.Errorf("host key type %s is not pinned", key.Type()))
+		}
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	if c.MinRSABits > 0 && key.Type() == ssh.KeyAlgoRSA {
+		if pub, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := pub.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < c.MinRSABits {
+				return fmt.Errorf("rsa host key size %d is lower than %d bits", rsaKey.N.BitLen(), c.MinRSABits)
+			}
+		}
+	}
+
+	return nil
+}
+
+// policyCallback wraps callback with the config host key policy checks.
+func (c *Config) policyCallback(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+
+		if err := c.checkHostKey(key); err != nil {
+			return err
+		}
+
+		return callback(host, remote, key)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionRetryInterval is the wait before retrying a session refused by the
+// server with MaxConcurrentSessions while no other session is open, the
+// server may not have freed a session closed just before.
+var SessionRetryInterval = 100 * time.Millisecond
+
+// openSession opens a session channel, with MaxConcurrentSessions it waits
+// for a free slot and retries the sessions refused by the server once another
+// one is closed.
+func (c Client) openSession(ctx context.Context) (*ssh.Session, error) {
+
+	t := c.tracker
+	if t == nil || t.slots == nil {
+		sess, err := c.Client.NewSession()
+		if err != nil {
+			return nil, channelError(err)
+		}
+		return sess, nil
+	}
+
+	retried := false
+
+	for {
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		closes := t.closing()
+
+		sess, err := c.Client.NewSession()
+		if err == nil {
+			t.onClose(t.channelOf(sess), t.release)
+			return sess, nil
+		}
+
+		t.release()
+
+		if err = channelError(err); !errors.Is(err, ErrSessionLimit) {
+			return nil, err
+		}
+
+		// Wait for one of the open sessions, or a while when there's none.
+		var wait <-chan time.Time
+		if len(t.slots) == 0 {
+			if retried {
+				return nil, err
+			}
+			retried = true
+			wait = time.After(SessionRetryInterval)
+		}
+
+		select {
+		case <-closes:
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release frees a MaxConcurrentSessions slot.
+func (t *trackedConn) release() {
+	<-t.slots
+}
+
+// closing returns a channel closed once a channel of t is closed.
+func (t *trackedConn) closing() <-chan struct{} {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.closes
+}
+
+// onClose calls fn once ch is closed, right away if it's already closed or
+// untracked.
+func (t *trackedConn) onClose(ch *trackedChannel, fn func()) {
+
+	if ch == nil {
+		fn()
+		return
+	}
+
+	t.mu.Lock()
+	if !ch.gone {
+		ch.release = fn
+		fn = nil
+	}
+	t.mu.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftp packet types used to query the server limits.
+const (
+	sshFxpInit          = 1
+	sshFxpVersion       = 2
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+)
+
+// SftpLimits are the server limits advertised with the limits@openssh.com extension.
+type SftpLimits struct {
+	MaxPacketLength uint64
+	MaxReadLength   uint64
+	MaxWriteLength  uint64
+	MaxOpenHandles  uint64
+}
+
+// limitsCache queries the server limits once per client.
+type limitsCache struct {
+	once   sync.Once
+	limits *SftpLimits
+}
+
+// NewSftp returns new sftp client and error if any. The packet size is sized
+// to the server limits when it supports limits@openssh.com, opts are applied
+// after so they take precedence.
+func (c Client) NewSftp(opts ...sftp.ClientOption) (*sftp.Client, error) {
+	return c.NewSftpContext(context.Background(), opts...)
+}
+
+// NewSftpContext is like NewSftp but ctx bounds the sftp session setup.
+func (c Client) NewSftpContext(ctx context.Context, opts ...sftp.ClientOption) (*sftp.Client, error) {
+
+	if err := c.faults().channelOpen("sftp"); err != nil {
+		return nil, err
+	}
+
+	if limits := c.cachedSftpLimits(); limits != nil {
+		size := limits.MaxReadLength
+		if limits.MaxWriteLength < size {
+			size = limits.MaxWriteLength
+		}
+
+		if size > 0 {
+			opts = append([]sftp.ClientOption{sftp.MaxPacketUnchecked(int(size))}, opts...)
+		}
+	}
+
+	type result struct {
+		ftp *sftp.Client
+		err error
+	}
+
+	// Mirrors sftp.NewClient with a session closed when ctx is done.
+	sess, err := c.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan result, 1)
+	go func() {
+
+		if err := sess.RequestSubsystem("sftp"); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		pw, err := sess.StdinPipe()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		pr, err := sess.StdoutPipe()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		ftp, err := sftp.NewClientPipe(pr, sftpStdin{WriteCloser: pw, sess: sess}, opts...)
+		done <- result{ftp: ftp, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		sess.Close()
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			sess.Close()
+		}
+		return r.ftp, r.err
+	}
+}
+
+// sftpStdin closes the session with the sftp stdin, so closing the sftp
+// client closes its channel.
+type sftpStdin struct {
+	io.WriteCloser
+	sess *ssh.Session
+}
+
+func (s sftpStdin) Close() error {
+	err := s.WriteCloser.Close()
+	s.sess.Close()
+	return err
+}
+
+// withSftp calls fn with a new sftp client, the client is closed when ctx
+// is done which aborts fn pending requests, fn has returned either way.
+func (c Client) withSftp(ctx context.Context, fn func(ftp *sftp.Client) error) error {
+
+	ftp, err := c.NewSftpContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ftp)
+	}()
+
+	select {
+	case <-ctx.Done():
+		ftp.Close()
+		<-done
+		return ctx.Err()
+	case err = <-done:
+		return err
+	}
+}
+
+func (c Client) cachedSftpLimits() *SftpLimits {
+
+	if c.limits == nil || c.Config == nil || !c.Config.SftpAutoTune {
+		return nil
+	}
+
+	c.limits.once.Do(func() {
+		c.limits.limits, _ = c.SftpLimits()
+	})
+
+	return c.limits.limits
+}
+
+// SftpLimits queries the server sftp limits, it returns nil without error
+// when the server doesn't support the limits@openssh.com extension.
+func (c Client) SftpLimits() (*SftpLimits, error) {
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	w, err := sess.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sess.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+
+	// init with version 3.
+	if err = writeSftpPacket(w, sshFxpInit, []byte{0, 0, 0, 3}); err != nil {
+		return nil, err
+	}
+
+	typ, data, err := readSftpPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sshFxpVersion || len(data) < 4 {
+		return nil, errors.New("sftp: unexpected version packet")
+	}
+
+	supported := false
+	for data = data[4:]; len(data) > 0; {
+		var name string
+		if name, data, err = sftpString(data); err != nil {
+			return nil, err
+		}
+		if _, data, err = sftpString(data); err != nil {
+			return nil, err
+		}
+		if name == "limits@openssh.com" {
+			supported = true
+		}
+	}
+
+	if !supported {
+		return nil, nil
+	}
+
+	ext := "limits@openssh.com"
+	req := make([]byte, 8+len(ext))
+	binary.BigEndian.PutUint32(req, 1)
+	binary.BigEndian.PutUint32(req[4:], uint32(len(ext)))
+	copy(req[8:], ext)
+
+	if err = writeSftpPacket(w, sshFxpExtended, req); err != nil {
+		return nil, err
+	}
+
+	if typ, data, err = readSftpPacket(r); err != nil {
+		return nil, err
+	}
+	if typ != sshFxpExtendedReply || len(data) < 36 {
+		return nil, errors.New("sftp: unexpected limits reply")
+	}
+
+	return &SftpLimits{
+		MaxPacketLength: binary.BigEndian.Uint64(data[4:]),
+		MaxReadLength:   binary.BigEndian.Uint64(data[12:]),
+		MaxWriteLength:  binary.BigEndian.Uint64(data[20:]),
+		MaxOpenHandles:  binary.BigEndian.Uint64(data[28:]),
+	}, nil
+}
+
+func writeSftpPacket(w io.Writer, typ byte, payload []byte) error {
+
+	b := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(1+len(payload)))
+	b[4] = typ
+	copy(b[5:], payload)
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readSftpPacket(r io.Reader) (byte, []byte, error) {
+
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 1 || n > 256*1024 {
+		return 0, nil, errors.New("sftp: invalid packet length")
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+
+	return b[0], b[1:], nil
+}
+
+func sftpString(b []byte) (string, []byte, error) {
+
+	if len(b) < 4 {
+		return "", nil, errors.New("sftp: short packet")
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return "", nil, errors.New("sftp: short packet")
+	}
+
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"strings"
+)
+
+// Shell is the remote shell command helpers generate syntax for.
+type Shell string
+
+const (
+	// ShellNone runs commands as is, with the server default shell, helpers assume POSIX syntax.
+	ShellNone Shell = "none"
+
+	ShellSh         Shell = "sh"
+	ShellBash       Shell = "bash"
+	ShellPowerShell Shell = "powershell"
+	ShellCmd        Shell = "cmd"
+)
+
+// shell returns the config remote shell, ShellNone by default.
+func (c *Config) shell() Shell {
+
+	if c == nil || c.RemoteShell == "" {
+		return ShellNone
+	}
+
+	return c.RemoteShell
+}
+
+// Windows reports whether the shell is a Windows one.
+func (s Shell) Windows() bool {
+	return s == ShellPowerShell || s == ShellCmd
+}
+
+// Wrap returns cmd executed by the shell.
+func (s Shell) Wrap(cmd string) string {
+
+	switch s {
+	case ShellSh, ShellBash:
+		return string(s) + " -c " + s.Quote(cmd)
+	case ShellPowerShell:
+		return "powershell -NoProfile -NonInteractive -Command " + ShellCmd.Quote(cmd)
+	case ShellCmd:
+		return "cmd /C " + cmd
+	default:
+		return cmd
+	}
+}
+
+// Quote quotes arg so the shell passes it literally.
+func (s Shell) Quote(arg string) string {
+
+	switch s {
+	case ShellPowerShell:
+		return "'" + strings.Replace(arg, "'", "''", -1) + "'"
+	case ShellCmd:
+		return `"` + strings.Replace(arg, `"`, `""`, -1) + `"`
+	default:
+		if arg != "" && strings.IndexFunc(arg, needsQuote) == -1 {
+			return arg
+		}
+		return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+}
+
+func needsQuote(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+}
+
+// command returns the command line running the executable at the sftp path
+// with args, each quoted for the shell.
+func (s Shell) command(path string, args []string) string {
+
+	if s.Windows() {
+		path = NativePath(path)
+	}
+
+	parts := []string{s.Quote(path)}
+	if s == ShellPowerShell {
+		// A quoted path is a string for PowerShell, & runs it.
+		parts[0] = "& " + parts[0]
+	}
+
+	for _, arg := range args {
+		parts = append(parts, s.Quote(arg))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol constants, see RFC 1928.
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+
+	socksSucceeded        = 0
+	socksHostUnreachable  = 4
+	socksCmdNotSupported  = 7
+	socksAddrNotSupported = 8
+)
+
+// StartSocksProxy runs a SOCKS5 server on listenAddr routing its connections
+// through the server, like ssh -D. Only the CONNECT command without
+// authentication is supported. It blocks until ctx is done or the listener
+// fails, the proxied connections are then closed.
+func (c Client) StartSocksProxy(ctx context.Context, listenAddr string) error {
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	err = forward(ctx, ln, func(conn net.Conn) (net.Conn, error) {
+
+		addr, err := socksHandshake(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := c.Dial("tcp", addr)
+		if err != nil {
+			socksReply(conn, socksHostUnreachable)
+			return nil, err
+		}
+
+		if err = socksReply(conn, socksSucceeded); err != nil {
+			target.Close()
+			return nil, err
+		}
+
+		return target, nil
+	}, c.Config.logf)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// socksHandshake negotiates the method and reads the CONNECT request of a
+// SOCKS5 client, it returns the requested host:port.
+func socksHandshake(conn net.Conn) (string, error) {
+
+	b := make([]byte, 255)
+
+	// Version and methods.
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return "", err
+	}
+	if b[0] != socksVersion {
+		return "", fmt.Errorf("socks: unsupported version %d", b[0])
+	}
+
+	methods := b[:b[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", errors.New("socks: no acceptable authentication method")
+	}
+
+	// Request: version, command, reserved and address type.
+	if _, err := io.ReadFull(conn, b[:4]); err != nil {
+		return "", err
+	}
+	if b[1] != socksConnect {
+		socksReply(conn, socksCmdNotSupported)
+		return "", fmt.Errorf("socks: unsupported command %d", b[1])
+	}
+
+	var host string
+
+	switch b[3] {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if b[3] == socksIPv6 {
+			size = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, b[:size]); err != nil {
+			return "", err
+		}
+		host = net.IP(b[:size]).String()
+
+	case socksDomain:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return "", err
+		}
+		size := int(b[0])
+		if _, err := io.ReadFull(conn, b[:size]); err != nil {
+			return "", err
+		}
+		host = string(b[:size])
+
+	default:
+		socksReply(conn, socksAddrNotSupported)
+		return "", fmt.Errorf("socks: unsupported address type %d", b[3])
+	}
+
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return "", err
+	}
+
+	port := binary.BigEndian.Uint16(b[:2])
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// socksReply sends a reply with the status code and an empty bind address.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,288 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHConfig is a parsed OpenSSH client config file.
+type SSHConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// DefaultSSHConfigPath returns the user ssh config path (~/.ssh/config).
+func DefaultSSHConfigPath() (string, error) {
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// LoadSSHConfig parses the ssh config file.
+func LoadSSHConfig(file string) (*SSHConfig, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseSSHConfig(f)
+}
+
+// ParseSSHConfig parses an ssh config, Match blocks and Include are ignored.
+func ParseSSHConfig(r io.Reader) (*SSHConfig, error) {
+
+	var (
+		line    int
+		config  = &SSHConfig{}
+		block   = sshConfigBlock{patterns: []string{"*"}}
+		scanner = bufio.NewScanner(r)
+		skip    bool
+	)
+
+	for scanner.Scan() {
+
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		key, value := splitSSHConfigLine(text)
+		if value == "" {
+			return nil, fmt.Errorf("ssh config line %d: missing value of %s", line, key)
+		}
+
+		switch strings.ToLower(key) {
+		case "host":
+			config.blocks = append(config.blocks, block)
+			block, skip = sshConfigBlock{patterns: sshConfigArgs(value)}, false
+		case "match":
+			config.blocks = append(config.blocks, block)
+			block, skip = sshConfigBlock{}, true
+		default:
+			if !skip {
+				block.options = append(block.options, [2]string{strings.ToLower(key), value})
+			}
+		}
+	}
+
+	config.blocks = append(config.blocks, block)
+
+	return config, scanner.Err()
+}
+
+// splitSSHConfigLine splits "Key value" and "Key=value" lines.
+func splitSSHConfigLine(line string) (string, string) {
+
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, ""
+	}
+
+	value := strings.TrimLeft(line[i:], " \t")
+	value = strings.TrimPrefix(value, "=")
+
+	return line[:i], strings.TrimSpace(value)
+}
+
+// sshConfigArgs splits a value on spaces, double quotes group words.
+func sshConfigArgs(value string) []string {
+
+	var (
+		args   []string
+		arg    strings.Builder
+		quoted bool
+	)
+
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(r)
+		}
+	}
+
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// match reports whether host matches the block patterns, like ssh a matching
+// negated pattern excludes the block whatever the other patterns.
+func (b sshConfigBlock) match(host string) bool {
+
+	matched := false
+
+	host = strings.ToLower(host)
+	for _, pattern := range b.patterns {
+
+		negate := strings.HasPrefix(pattern, "!")
+		if !matchSSHPattern(strings.ToLower(strings.TrimPrefix(pattern, "!")), host) {
+			continue
+		}
+
+		if negate {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+// matchSSHPattern matches s against an ssh pattern where * matches any
+// sequence and ? any single character.
+func matchSSHPattern(pattern, s string) bool {
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchSSHPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+
+	return s == ""
+}
+
+// Get returns the first value of key for host, like ssh the first obtained
+// value wins.
+func (s *SSHConfig) Get(host string, key string) string {
+
+	if values := s.GetAll(host, key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// GetAll returns all values of key for host in config order.
+func (s *SSHConfig) GetAll(host string, key string) []string {
+
+	var values []string
+
+	key = strings.ToLower(key)
+	for _, block := range s.blocks {
+		if block.match(host) {
+			for _, opt := range block.options {
+				if opt[0] == key {
+					values = append(values, opt[1])
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// ApplySSHConfig applies the host options of the ssh config, it supports
+// SendEnv and SetEnv.
+func (c *Config) ApplySSHConfig(s *SSHConfig, host string) {
+
+	for _, value := range s.GetAll(host, "SendEnv") {
+		c.SendEnv = append(c.SendEnv, sshConfigArgs(value)...)
+	}
+
+	for _, value := range s.GetAll(host, "SetEnv") {
+		for _, env := range sshConfigArgs(value) {
+
+			i := strings.IndexByte(env, '=')
+			if i <= 0 {
+				continue
+			}
+
+			if c.SetEnv == nil {
+				c.SetEnv = make(map[string]string)
+			}
+
+			// The first value of a variable wins.
+			if _, ok := c.SetEnv[env[:i]]; !ok {
+				c.SetEnv[env[:i]] = env[i+1:]
+			}
+		}
+	}
+}
+
+// setEnv sets the SendEnv and SetEnv vars on sess, like ssh the vars
+// rejected by the server are ignored.
+func (c *Config) setEnv(sess *ssh.Session) {
+
+	for _, pattern := range c.SendEnv {
+
+		if strings.HasPrefix(pattern, "-") {
+			continue
+		}
+
+		for _, env := range os.Environ() {
+
+			i := strings.IndexByte(env, '=')
+			if i <= 0 {
+				continue
+			}
+
+			if ok, _ := path.Match(pattern, env[:i]); ok && !c.unsent(env[:i]) {
+				sess.Setenv(env[:i], env[i+1:])
+			}
+		}
+	}
+
+	for name, value := range c.SetEnv {
+		sess.Setenv(name, value)
+	}
+}
+
+// unsent reports whether a "-pattern" of SendEnv excludes name.
+func (c *Config) unsent(name string) bool {
+
+	for _, pattern := range c.SendEnv {
+		if strings.HasPrefix(pattern, "-") {
+			if ok, _ := path.Match(pattern[1:], name); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
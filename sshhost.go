@@ -0,0 +1,231 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SystemSSHConfigPath is the system wide ssh config read by FromSSHConfig.
+var SystemSSHConfigPath = "/etc/ssh/ssh_config"
+
+// maxJumpHosts bounds ProxyJump chains, it stops config loops.
+const maxJumpHosts = 8
+
+// FromSSHConfig returns the config of the host alias from ~/.ssh/config and
+// the system ssh config, see SSHConfig.Config. Missing files are ignored.
+func FromSSHConfig(alias string) (*Config, error) {
+
+	file, err := DefaultSSHConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &SSHConfig{}
+	for _, file := range []string{file, SystemSSHConfigPath} {
+
+		s, err := LoadSSHConfig(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		// Like ssh the user config values come first and win.
+		config.blocks = append(config.blocks, s.blocks...)
+	}
+
+	return config.Config(alias)
+}
+
+// Config resolves the host alias into a config. It applies HostName, Port,
+// User, IdentityFile, UserKnownHostsFile, ProxyJump, ConnectTimeout, SendEnv
+// and SetEnv. Without IdentityFile the default ~/.ssh/id_* keys that exist
+// are used, jump hosts are resolved with the same config.
+func (s *SSHConfig) Config(alias string) (*Config, error) {
+	return s.config(alias, 0)
+}
+
+func (s *SSHConfig) config(alias string, jumps int) (*Config, error) {
+
+	if jumps > maxJumpHosts {
+		return nil, fmt.Errorf("ssh config %s: too many jump hosts", alias)
+	}
+
+	host := alias
+	if v := s.Get(alias, "HostName"); v != "" {
+		host = strings.NewReplacer("%h", alias, "%%", "%").Replace(v)
+	}
+
+	port := uint(DefaultPort)
+	if v := s.Get(alias, "Port"); v != "" {
+		p, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("ssh config %s: invalid port %q", alias, v)
+		}
+		port = uint(p)
+	}
+
+	login := s.Get(alias, "User")
+	if login == "" {
+		login = localUser()
+	}
+
+	home, _ := os.UserHomeDir()
+	expand := strings.NewReplacer("%d", home, "%h", host, "%p", strconv.Itoa(int(port)), "%r", login, "%u", localUser(), "%%", "%")
+	expandPath := func(p string) string {
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			p = home + p[1:]
+		}
+		return expand.Replace(p)
+	}
+
+	auth, err := s.identities(alias, expandPath)
+	if err != nil {
+		return nil, fmt.Errorf("ssh config %s: %w", alias, err)
+	}
+
+	var callback ssh.HostKeyCallback
+	if v := s.Get(alias, "UserKnownHostsFile"); v != "" && v != "none" {
+		callback, err = KnownHosts(expandPath(sshConfigArgs(v)[0]))
+	} else {
+		callback, err = DefaultKnownHosts()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ssh config %s: %w", alias, err)
+	}
+
+	timeout := DefaultTimeout
+	if v := s.Get(alias, "ConnectTimeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("ssh config %s: invalid connect timeout %q", alias, v)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	c := &Config{
+		Auth:     auth,
+		Addr:     host,
+		Port:     port,
+		Protocol: "tcp",
+		Timeout:  timeout,
+		ClientConfig: &ssh.ClientConfig{
+			User:            login,
+			Auth:            auth,
+			Timeout:         timeout,
+			HostKeyCallback: callback,
+		},
+	}
+
+	if c.Jump, err = s.jump(alias, jumps); err != nil {
+		return nil, err
+	}
+
+	c.ApplySSHConfig(s, alias)
+
+	return c, nil
+}
+
+// identities returns the IdentityFile keys of alias, or the default keys.
+func (s *SSHConfig) identities(alias string, expandPath func(string) string) (Auth, error) {
+
+	var signers []ssh.Signer
+
+	files := s.GetAll(alias, "IdentityFile")
+	if len(files) == 0 {
+		for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+			file := expandPath(filepath.Join("~", ".ssh", name))
+			if signer, err := GetSigner(file, ""); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+
+	for _, file := range files {
+		if file == "none" {
+			continue
+		}
+
+		signer, err := GetSigner(expandPath(sshConfigArgs(file)[0]), "")
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, nil
+	}
+
+	return Auth{ssh.PublicKeys(signers...)}, nil
+}
+
+// jump returns the ProxyJump chain of alias, each jump host being
+// "[user@]host[:port]" or an alias of the config.
+func (s *SSHConfig) jump(alias string, jumps int) (*Config, error) {
+
+	v := s.Get(alias, "ProxyJump")
+	if v == "" || v == "none" {
+		return nil, nil
+	}
+
+	var jump *Config
+	for _, hop := range strings.Split(v, ",") {
+
+		hop = strings.TrimSpace(hop)
+		jumps++
+
+		name, login, port := hop, "", ""
+		if i := strings.LastIndexByte(name, '@'); i >= 0 {
+			login, name = name[:i], name[i+1:]
+		}
+		if i := strings.LastIndexByte(name, ':'); i >= 0 && !strings.Contains(name[i+1:], "]") {
+			name, port = name[:i], name[i+1:]
+		}
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+
+		c, err := s.config(name, jumps)
+		if err != nil {
+			return nil, err
+		}
+
+		if login != "" {
+			c.ClientConfig.User = login
+		}
+		if port != "" {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("ssh config %s: invalid jump host %q", alias, hop)
+			}
+			c.Port = uint(p)
+		}
+
+		// The first hop is dialed first, it's the innermost jump.
+		if c.Jump == nil {
+			c.Jump = jump
+		}
+		jump = c
+	}
+
+	return jump, nil
+}
+
+func localUser() string {
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Line is a line of a command output streamed by RunStream.
+type Line struct {
+
+	// Text is the line without its line ending.
+	Text string
+
+	// Stderr is set for a stderr line.
+	Stderr bool
+
+	// Done marks the last value, sent once the command exited, Err is then
+	// the command error.
+	Done bool
+	Err  error
+}
+
+// RunWriter runs cmd writing its stdout and stderr as they come, for long
+// running commands or outputs too large to buffer. The error is an
+// *ExitError when the command didn't exit successfully.
+func (c Client) RunWriter(cmd string, stdout io.Writer, stderr io.Writer) error {
+
+	command, err := c.Command(cmd)
+	if err != nil {
+		return err
+	}
+	defer command.Session.Close()
+
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	return command.Run()
+}
+
+// RunStream runs cmd and sends its stdout and stderr lines, then a Done
+// line with the command error before closing the channel. The order of the
+// stdout and stderr lines is kept within each stream only. When ctx is done
+// the command is interrupted, the lines not read yet are dropped and the
+// channel is closed.
+func (c Client) RunStream(ctx context.Context, cmd string) (<-chan Line, error) {
+
+	command, err := c.CommandContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		out  = make(chan Line)
+		send = func(l Line) {
+			select {
+			case out <- l:
+			case <-ctx.Done():
+			}
+		}
+	)
+
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	command.Stdout, command.Stderr = stdoutW, stderrW
+
+	wg.Add(2)
+	go scanLines(stdout, false, send, wg.Done)
+	go scanLines(stderr, true, send, wg.Done)
+
+	go func() {
+		defer close(out)
+
+		err := command.Run()
+		command.Session.Close()
+		stdoutW.Close()
+		stderrW.Close()
+		wg.Wait()
+
+		send(Line{Done: true, Err: err})
+	}()
+
+	return out, nil
+}
+
+// scanLines calls send with each line of r until it's closed, r is read to
+// the end even when send drops the lines.
+func scanLines(r io.Reader, stderr bool, send func(Line), done func()) {
+
+	defer done()
+
+	br := bufio.NewReader(r)
+	for {
+		text, err := br.ReadString('\n')
+		if text != "" {
+			send(Line{Text: strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r"), Stderr: stderr})
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrSudoPassword is returned when sudo rejected or required a password.
+	ErrSudoPassword = errors.New("sudo: incorrect password")
+
+	// ErrSudoDenied is returned when the user isn't allowed to run the
+	// command with sudo.
+	ErrSudoDenied = errors.New("sudo: not allowed")
+)
+
+// sudoErrors are the sudo messages, lower case, of the sudo error kinds.
+var sudoErrors = []struct {
+	kind error
+	msg  []byte
+}{
+	{ErrSudoPassword, []byte("incorrect password")},
+	{ErrSudoPassword, []byte("sorry, try again")},
+	{ErrSudoPassword, []byte("a password is required")},
+	{ErrSudoDenied, []byte("not in the sudoers file")},
+	{ErrSudoDenied, []byte("is not allowed to")},
+	{ErrSudoDenied, []byte("may not run sudo")},
+}
+
+// SudoMethod is the privilege escalation command of a SudoConfig.
+type SudoMethod string
+
+const (
+	MethodSudo SudoMethod = "sudo"
+	MethodDoas SudoMethod = "doas"
+	MethodSu   SudoMethod = "su"
+)
+
+// SudoConfig is the privilege escalation of a host, used by RunSudo,
+// RunEscalate and EditFile.
+type SudoConfig struct {
+
+	// Method is sudo, doas or su, empty means sudo.
+	Method SudoMethod
+
+	// User the commands run as, empty means root.
+	User string
+
+	// Password returns the password written to sudo stdin, nil means none,
+	// Secret.Get fits. Only sudo reads it, doas and su must not prompt.
+	Password func(ctx context.Context) ([]byte, error)
+
+	// NonInteractive fails the commands that would prompt for a password,
+	// it's ignored by sudo when a Password is set.
+	NonInteractive bool
+}
+
+// command returns cmd run through sh -c with the escalation method, sudo
+// reads the password on stdin when password is true.
+func (s *SudoConfig) command(cmd string, password bool) string {
+
+	var args []string
+
+	switch s.Method {
+	case MethodDoas:
+		args = append(args, "doas")
+		if s.NonInteractive {
+			args = append(args, "-n")
+		}
+		if s.User != "" {
+			args = append(args, "-u", ShellSh.Quote(s.User))
+		}
+	case MethodSu:
+		user := s.User
+		if user == "" {
+			user = "root"
+		}
+		return "su " + ShellSh.Quote(user) + " -c " + ShellSh.Quote(cmd)
+	default:
+		args = append(args, "sudo")
+		if password {
+			args = append(args, "-S", "-p", "''")
+		} else if s.NonInteractive {
+			args = append(args, "-n")
+		}
+		if s.User != "" {
+			args = append(args, "-u", ShellSh.Quote(s.User))
+		}
+	}
+
+	return strings.Join(args, " ") + " " + ShellSh.Wrap(cmd)
+}
+
+// sudo returns the config escalation, plain sudo by default.
+func (c *Config) sudo() *SudoConfig {
+
+	if c == nil || c.Sudo == nil {
+		return &SudoConfig{}
+	}
+
+	return c.Sudo
+}
+
+// RunSudo runs cmd with the Config.Sudo escalation and returns its
+// combined output, without Config.Sudo it runs under sudo. Errors of sudo
+// itself wrap ErrSudoPassword or ErrSudoDenied.
+func (c Client) RunSudo(ctx context.Context, cmd string) ([]byte, error) {
+
+	command, err := c.sudoCommand(ctx, cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer command.Session.Close()
+
+	out, err := command.CombinedOutput()
+
+	return out, sudoError(err, out)
+}
+
+// Sudo runs cmd as root with sudo -S and the password written to its stdin,
+// the password prompt is disabled so the output is the command one. It
+// returns the stdout, the stderr is added to the error, see SudoContext.
+func (c Client) Sudo(cmd string, password string) ([]byte, error) {
+	return c.SudoContext(context.Background(), cmd, password)
+}
+
+// SudoContext is like Sudo with a context, sudo rejecting the password or
+// the user returns an error wrapping ErrSudoPassword or ErrSudoDenied.
+func (c Client) SudoContext(ctx context.Context, cmd string, password string) ([]byte, error) {
+
+	command, err := c.CommandContext(ctx, (&SudoConfig{}).command(cmd, true))
+	if err != nil {
+		return nil, err
+	}
+	defer command.Session.Close()
+
+	command.Stdin = strings.NewReader(password + "\n")
+
+	var stderr syncBuffer
+	command.Stderr = &stderr
+
+	out, err := command.Output()
+	if err == nil {
+		return out, nil
+	}
+
+	if msg := strings.TrimSpace(string(stderr.Bytes())); msg != "" {
+		return out, sudoError(fmt.Errorf("%w: %s", err, msg), stderr.Bytes())
+	}
+
+	return out, err
+}
+
+// sudoError wraps a failed command error with the sudo error kind of its
+// output, if any.
+func sudoError(err error, output []byte) error {
+
+	if err == nil {
+		return nil
+	}
+
+	output = bytes.ToLower(output)
+	for _, e := range sudoErrors {
+		if bytes.Contains(output, e.msg) {
+			return wrapError(e.kind, err)
+		}
+	}
+
+	return err
+}
+
+// sudoCommand returns cmd escalated with Config.Sudo, input is written to
+// its stdin after the password. When sudo needs no password, credentials
+// cached or NOPASSWD, none is sent so that input isn't polluted.
+func (c Client) sudoCommand(ctx context.Context, cmd string, input io.Reader) (*Cmd, error) {
+
+	s := c.Config.sudo()
+
+	var password []byte
+	if s.Password != nil && (s.Method == "" || s.Method == MethodSudo) {
+
+		needed := true
+		if input != nil {
+			noPrompt := &SudoConfig{User: s.User, NonInteractive: true}
+			_, err := c.RunContext(ctx, noPrompt.command("true", false))
+			needed = err != nil
+		}
+
+		if needed {
+			pass, err := s.Password(ctx)
+			if err != nil {
+				return nil, err
+			}
+			password = append(append(password, pass...), '\n')
+		}
+	}
+
+	command, err := c.CommandContext(ctx, s.command(cmd, password != nil))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case password != nil && input != nil:
+		command.Stdin = io.MultiReader(bytes.NewReader(password), input)
+	case password != nil:
+		command.Stdin = bytes.NewReader(password)
+	case input != nil:
+		command.Stdin = input
+	}
+
+	return command, nil
+}
+
+// sudoOutput runs cmd escalated and returns its stdout, the stderr is
+// added to the error.
+func (c Client) sudoOutput(ctx context.Context, cmd string, input io.Reader) ([]byte, error) {
+
+	command, err := c.sudoCommand(ctx, cmd, input)
+	if err != nil {
+		return nil, err
+	}
+	defer command.Session.Close()
+
+	return outputStderr(command)
+}
+
+// outputStderr returns the command stdout, the stderr is added to the error.
+func outputStderr(command *Cmd) ([]byte, error) {
+
+	var stderr syncBuffer
+	command.Stderr = &stderr
+
+	out, err := command.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr.Bytes())); msg != "" {
+			return out, fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+
+	return out, err
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	esc = 0x1b
+	bel = 0x07
+	del = 0x7f
+
+	// maxCSI drops longer control sequences.
+	maxCSI = 64
+)
+
+var (
+	pasteStart = []byte("\x1b[200~")
+	pasteEnd   = []byte("\x1b[201~")
+)
+
+type filterState int
+
+const (
+	stateText filterState = iota
+	stateEsc
+	stateCSI
+	stateString
+	stateStringEsc
+)
+
+// terminalFilter drops the escape sequences of remote output that can
+// abuse the local terminal.
+type terminalFilter struct {
+	w       io.Writer
+	state   filterState
+	seq     []byte
+	partial []byte
+}
+
+// NewTerminalFilter returns a writer forwarding remote terminal output to w
+// without the dangerous escape sequences: OSC, DCS, APC, PM and SOS strings
+// (titles, clipboard, hyperlinks...), window manipulation and reports, and
+// control characters other than BEL, BS, TAB, LF and CR. The 8-bit C1
+// controls are dropped both as UTF-8 runes and as raw bytes. Colors, cursor
+// moves and modes like bracketed paste are kept.
+func NewTerminalFilter(w io.Writer) io.Writer {
+	return &terminalFilter{w: w}
+}
+
+func (f *terminalFilter) Write(p []byte) (int, error) {
+
+	out := make([]byte, 0, len(p))
+
+	for _, b := range p {
+		switch f.state {
+
+		case stateText:
+			if len(f.partial) > 0 {
+				if b >= 0x80 && b <= 0xbf {
+					if f.partial = append(f.partial, b); utf8.FullRune(f.partial) {
+						out, f.partial = appendRune(out, f.partial), f.partial[:0]
+					}
+					continue
+				}
+				out, f.partial = appendRune(out, f.partial), f.partial[:0]
+			}
+
+			switch {
+			case b == esc:
+				f.state, f.seq = stateEsc, append(f.seq[:0], b)
+			case b < 0x20 && b != bel && b != '\b' && b != '\t' && b != '\n' && b != '\r':
+				// dropped control character
+			case b == del || (b >= 0x80 && b <= 0x9f):
+				// dropped DEL or raw C1 control
+			case b >= 0xc0:
+				f.partial = append(f.partial, b)
+			default:
+				out = append(out, b)
+			}
+
+		case stateEsc:
+			switch {
+			case b == '[':
+				f.state, f.seq = stateCSI, append(f.seq, b)
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				f.state = stateString
+			case b >= 0x20 && b < 0x7f:
+				out = append(append(out, f.seq...), b)
+				f.state = stateText
+			default:
+				f.state = stateText
+			}
+
+		case stateCSI:
+			f.seq = append(f.seq, b)
+			switch {
+			case b >= 0x40 && b <= 0x7e:
+				// Window manipulation can resize, move or report the title.
+				if b != 't' {
+					out = append(out, f.seq...)
+				}
+				f.state = stateText
+			case b < 0x20 || len(f.seq) > maxCSI:
+				f.state = stateText
+			}
+
+		case stateString:
+			switch b {
+			case bel:
+				f.state = stateText
+			case esc:
+				f.state = stateStringEsc
+			}
+
+		case stateStringEsc:
+			if b == '\\' {
+				f.state = stateText
+			} else if b != esc {
+				f.state = stateString
+			}
+		}
+	}
+
+	if len(out) > 0 {
+		if _, err := f.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// appendRune appends the UTF-8 sequence p to out unless it encodes a C1
+// control, the raw C1 bytes of an invalid sequence are dropped.
+func appendRune(out []byte, p []byte) []byte {
+
+	if r, size := utf8.DecodeRune(p); r != utf8.RuneError || size > 1 {
+		if r >= 0x80 && r <= 0x9f {
+			return out
+		}
+		return append(out, p...)
+	}
+
+	for _, b := range p {
+		if b < 0x80 || b > 0x9f {
+			out = append(out, b)
+		}
+	}
+
+	return out
+}
+
+// pasteFilter removes control characters from bracketed pastes.
+type pasteFilter struct {
+	r       io.Reader
+	buf     []byte
+	out     []byte
+	pending []byte
+	inPaste bool
+	err     error
+}
+
+// NewPasteFilter returns a reader of local terminal input that keeps the
+// bracketed paste markers but removes control characters and escapes from
+// the pasted text, so a paste can't inject keys like ^C or end the paste early.
+func NewPasteFilter(r io.Reader) io.Reader {
+	return &pasteFilter{r: r, buf: make([]byte, 4096)}
+}
+
+func (f *pasteFilter) Read(p []byte) (int, error) {
+
+	for len(f.out) == 0 && f.err == nil {
+
+		n, err := f.r.Read(f.buf)
+		for _, b := range f.buf[:n] {
+			f.feed(b)
+		}
+
+		// A partial marker is only held inside a paste, a lone ESC key
+		// press must not wait for the next key.
+		if !f.inPaste || err != nil {
+			f.emit(f.pending)
+			f.pending = nil
+		}
+
+		f.err = err
+	}
+
+	if len(f.out) == 0 {
+		return 0, f.err
+	}
+
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+
+	return n, nil
+}
+
+func (f *pasteFilter) feed(b byte) {
+
+	marker := pasteStart
+	if f.inPaste {
+		marker = pasteEnd
+	}
+
+	if len(f.pending) > 0 {
+
+		if candidate := append(f.pending, b); bytes.HasPrefix(marker, candidate) {
+			f.pending = candidate
+			if len(candidate) == len(marker) {
+				f.out = append(f.out, marker...)
+				f.inPaste, f.pending = !f.inPaste, nil
+			}
+			return
+		}
+
+		f.emit(f.pending)
+		f.pending = nil
+	}
+
+	if b == esc {
+		f.pending = append(f.pending, b)
+		return
+	}
+
+	f.emit([]byte{b})
+}
+
+func (f *pasteFilter) emit(p []byte) {
+	for _, b := range p {
+		if f.inPaste && (b < 0x20 || b == 0x7f) && b != '\t' && b != '\n' && b != '\r' {
+			continue
+		}
+		f.out = append(f.out, b)
+	}
+}
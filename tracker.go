@@ -0,0 +1,357 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"io"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes a channel opened by the client.
+type SessionInfo struct {
+
+	// Type is the channel type, like session or direct-tcpip.
+	Type string
+
+	// Kind is what the channel is used for: exec, shell, sftp or another
+	// subsystem name, forward, or empty for a session not started yet.
+	Kind string
+
+	// Detail is the exec command or the forward target address.
+	Detail string
+
+	// Opened is the time the channel was opened.
+	Opened time.Time
+
+	// LastActive is the time of the last channel read or write.
+	LastActive time.Time
+}
+
+// Age returns the time since the channel was opened.
+func (s SessionInfo) Age() time.Duration {
+	return time.Since(s.Opened)
+}
+
+// Sessions returns the channels opened by the client and not closed yet,
+// oldest first, exec commands are redacted. Channels opened by the server,
+// like the remote forward connections, aren't listed.
+func (c Client) Sessions() []SessionInfo {
+
+	if c.tracker == nil {
+		return nil
+	}
+
+	channels := c.tracker.sessions()
+	infos := make([]SessionInfo, len(channels))
+	for i, ch := range channels {
+		infos[i] = ch.info()
+		infos[i].Detail = c.Config.redactor().Redact(infos[i].Detail)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Opened.Before(infos[j].Opened) })
+
+	return infos
+}
+
+// trackedConn wraps the ssh connection to track the channels it opens.
+type trackedConn struct {
+	ssh.Conn
+
+	// last is the unix nano time of the last activity of any channel.
+	last int64
+
+	mu       sync.Mutex
+	channels map[*trackedChannel]struct{}
+
+	// stacks records the stack opening each channel, for LeakDetection.
+	stacks bool
+
+	// slots holds a value per session open with MaxConcurrentSessions,
+	// closes is closed and replaced each time a channel is closed.
+	slots  chan struct{}
+	closes chan struct{}
+
+	// probeMu serializes channelOf, probed is the channel answering its probe.
+	probeMu sync.Mutex
+	probed  *trackedChannel
+}
+
+func newTrackedConn(conn ssh.Conn) *trackedConn {
+	return &trackedConn{
+		Conn:     conn,
+		last:     time.Now().UnixNano(),
+		channels: make(map[*trackedChannel]struct{}),
+		closes:   make(chan struct{}),
+	}
+}
+
+// OpenChannel opens a tracked channel, it's untracked once the channel is
+// closed by either side.
+func (t *trackedConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+
+	ch, reqs, err := t.Conn.OpenChannel(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tch := &trackedChannel{Channel: ch, conn: t, typ: name, opened: now, last: now.UnixNano()}
+	if t.stacks {
+		tch.stack = string(debug.Stack())
+	}
+
+	if name == "direct-tcpip" || name == "direct-streamlocal@openssh.com" {
+		var target struct {
+			Host string
+			Port uint32
+		}
+		ssh.Unmarshal(data, &target)
+		if name == "direct-tcpip" {
+			target.Host = net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+		}
+		tch.kind, tch.detail = "forward", target.Host
+	}
+
+	t.mu.Lock()
+	t.channels[tch] = struct{}{}
+	t.mu.Unlock()
+	t.touch(&tch.last)
+
+	// The mux closes the requests channel when the channel is closed, it's
+	// untracked first so it's gone once Session.Wait returns.
+	out := make(chan *ssh.Request, 16)
+	go func() {
+		for req := range reqs {
+			out <- req
+		}
+
+		t.mu.Lock()
+		delete(t.channels, tch)
+		tch.gone = true
+		release, closes := tch.release, t.closes
+		t.closes = make(chan struct{})
+		t.mu.Unlock()
+		atomic.StoreInt64(&t.last, time.Now().UnixNano())
+
+		close(closes)
+		if release != nil {
+			release()
+		}
+
+		close(out)
+	}()
+
+	return tch, out, nil
+}
+
+func (t *trackedConn) touch(last *int64) {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(last, now)
+	atomic.StoreInt64(&t.last, now)
+}
+
+// sessions returns the open channels.
+func (t *trackedConn) sessions() []*trackedChannel {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	channels := make([]*trackedChannel, 0, len(t.channels))
+	for ch := range t.channels {
+		channels = append(channels, ch)
+	}
+
+	return channels
+}
+
+// channelProbe is a channel request answered by trackedChannel, it's never
+// sent to the server.
+const channelProbe = "channel-probe@goph"
+
+// channelOf returns the tracked channel of sess, nil if it isn't tracked.
+func (t *trackedConn) channelOf(sess *ssh.Session) *trackedChannel {
+
+	t.probeMu.Lock()
+	defer t.probeMu.Unlock()
+
+	t.probed = nil
+	sess.SendRequest(channelProbe, false, nil)
+
+	return t.probed
+}
+
+// trackedChannel records the channel activity.
+type trackedChannel struct {
+	ssh.Channel
+
+	last   int64
+	conn   *trackedConn
+	typ    string
+	opened time.Time
+
+	// closed is set once Close is called, stack is where the
+	// channel was opened with LeakDetection and reported once it leaked.
+	closed   int32
+	stack    string
+	reported int32
+
+	// gone is set once the channel is closed, release is then called, both
+	// guarded by conn.mu.
+	gone    bool
+	release func()
+
+	// kind and detail are set by the exec, shell or subsystem request.
+	mu     sync.Mutex
+	kind   string
+	detail string
+}
+
+func (c *trackedChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	c.conn.touch(&c.last)
+	return n, err
+}
+
+func (c *trackedChannel) Write(p []byte) (int, error) {
+	c.conn.touch(&c.last)
+	return c.Channel.Write(p)
+}
+
+func (c *trackedChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+
+	if name == channelProbe {
+		c.conn.probed = c
+		return true, nil
+	}
+
+	c.conn.touch(&c.last)
+
+	switch name {
+	case "exec", "subsystem", "shell":
+		var arg struct{ Value string }
+		ssh.Unmarshal(payload, &arg)
+
+		c.mu.Lock()
+		switch name {
+		case "exec":
+			c.kind, c.detail = name, arg.Value
+		case "subsystem":
+			c.kind = arg.Value
+		default:
+			c.kind = name
+		}
+		c.mu.Unlock()
+	}
+
+	return c.Channel.SendRequest(name, wantReply, payload)
+}
+
+func (c *trackedChannel) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Channel.Close()
+}
+
+// leaked returns whether the channel is still open and wasn't closed by
+// the client.
+func (c *trackedChannel) leaked() bool {
+
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return false
+	}
+
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+
+	_, open := c.conn.channels[c]
+	return open
+}
+
+func (c *trackedChannel) Stderr() io.ReadWriter {
+	return &activityReadWriter{ReadWriter: c.Channel.Stderr(), ch: c}
+}
+
+func (c *trackedChannel) info() SessionInfo {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return SessionInfo{
+		Type:       c.typ,
+		Kind:       c.kind,
+		Detail:     c.detail,
+		Opened:     c.opened,
+		LastActive: time.Unix(0, atomic.LoadInt64(&c.last)),
+	}
+}
+
+type activityReadWriter struct {
+	io.ReadWriter
+	ch *trackedChannel
+}
+
+func (rw *activityReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	rw.ch.conn.touch(&rw.ch.last)
+	return n, err
+}
+
+func (rw *activityReadWriter) Write(p []byte) (int, error) {
+	rw.ch.conn.touch(&rw.ch.last)
+	return rw.ReadWriter.Write(p)
+}
+
+// watchIdle closes the channels idle for IdleTimeout, and the connection
+// once it has no channel for IdleTimeout with IdleCloseConn, until the
+// connection is closed.
+func (c *Client) watchIdle() {
+
+	timeout := c.Config.IdleTimeout
+
+	done := make(chan struct{})
+	go func() {
+		c.Client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(checkInterval(timeout, 4))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+
+			channels := c.tracker.sessions()
+
+			for _, ch := range channels {
+				info := ch.info()
+				if now.Sub(info.LastActive) > timeout && c.Config.onIdle(c, &info) {
+					c.Config.logf("closing idle %s channel opened at %s", info.Type, info.Opened)
+					ch.Close()
+				}
+			}
+
+			last := time.Unix(0, atomic.LoadInt64(&c.tracker.last))
+			if c.Config.IdleCloseConn && len(channels) == 0 && now.Sub(last) > timeout && c.Config.onIdle(c, nil) {
+				c.Config.logf("closing idle connection to %s", c.RemoteAddr())
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// onIdle calls OnIdle, it returns whether the idle channel can be closed.
+func (c *Config) onIdle(client *Client, s *SessionInfo) bool {
+	return c.OnIdle == nil || c.OnIdle(client, s)
+}
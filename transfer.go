@@ -0,0 +1,330 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when a transferred file doesn't match the expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// TransferOption configures file transfers.
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	fsync     bool
+	progress  func(Progress)
+	algo      string
+	checksum  string
+	eol       string
+	mkdirs    bool
+	dirMode   os.FileMode
+	follow    bool
+	preserve  bool
+	include   []string
+	exclude   []string
+	workers   int
+	chunkSize int64
+}
+
+func newTransferOptions(opts []TransferOption) *transferOptions {
+
+	o := &transferOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Progress is the state of a transfer.
+type Progress struct {
+
+	// Bytes transferred so far.
+	Transferred int64
+
+	// Total size, -1 when unknown.
+	Total int64
+
+	// Average rate in bytes per second.
+	Rate float64
+}
+
+// WithFsync flushes uploaded files to the remote disk before returning, it
+// requires the server fsync@openssh.com extension.
+func WithFsync() TransferOption {
+	return func(o *transferOptions) {
+		o.fsync = true
+	}
+}
+
+// WithProgress calls fn as data is transferred.
+func WithProgress(fn func(Progress)) TransferOption {
+	return func(o *transferOptions) {
+		o.progress = fn
+	}
+}
+
+// WithChecksum verifies the transferred data against the hex encoded
+// checksum, algo is one of md5, sha1, sha256 or sha512.
+func WithChecksum(algo string, checksum string) TransferOption {
+	return func(o *transferOptions) {
+		o.algo = strings.ToLower(algo)
+		o.checksum = strings.ToLower(checksum)
+	}
+}
+
+// WithLineEnding converts the line endings of the transferred file to eol,
+// "\n" or "\r\n", like the ftp ascii mode. Use it for text files only.
+func WithLineEnding(eol string) TransferOption {
+	return func(o *transferOptions) {
+		o.eol = eol
+	}
+}
+
+// WithDirMode creates the missing remote parent directories with mode,
+// instead of the sftp server default which often is 0777.
+func WithDirMode(mode os.FileMode) TransferOption {
+	return func(o *transferOptions) {
+		o.mkdirs, o.dirMode = true, mode
+	}
+}
+
+// WithUmask is like WithDirMode with the mode 0777 minus umask.
+func WithUmask(umask os.FileMode) TransferOption {
+	return WithDirMode(0777 &^ umask)
+}
+
+// WithFollowSymlinks makes UploadDir upload the content of the local
+// symlinks targets instead of recreating the links, symlink cycles fail.
+func WithFollowSymlinks() TransferOption {
+	return func(o *transferOptions) {
+		o.follow = true
+	}
+}
+
+// WithPreserve makes UploadDir and DownloadDir keep the permissions and
+// modification times of the transferred files and directories.
+func WithPreserve() TransferOption {
+	return func(o *transferOptions) {
+		o.preserve = true
+	}
+}
+
+// WithInclude limits UploadDir and DownloadDir to the files matching one of
+// the glob patterns, see WithExclude for the matching rules.
+func WithInclude(patterns ...string) TransferOption {
+	return func(o *transferOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// WithExclude skips the files and directories matching one of the glob
+// patterns in UploadDir and DownloadDir. Patterns are matched with path.Match
+// against the slash separated path relative to the transferred directory and
+// against the base name, so "*.log" and "cache/*" both work.
+func WithExclude(patterns ...string) TransferOption {
+	return func(o *transferOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// selected reports whether the rel path passes the include and exclude
+// filters, includes only apply to files.
+func (o *transferOptions) selected(rel string, dir bool) bool {
+
+	if matchAny(o.exclude, rel) {
+		return false
+	}
+
+	return dir || len(o.include) == 0 || matchAny(o.include, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copy copies src to dst applying progress and checksum options, total is
+// the size of src or -1 if unknown.
+func (o *transferOptions) copy(dst io.Writer, src io.Reader, total int64) (int64, error) {
+
+	h, err := o.newHash()
+	if err != nil {
+		return 0, err
+	}
+
+	if h != nil {
+		dst = io.MultiWriter(dst, h)
+	}
+
+	var eol *eolWriter
+	if o.eol != "" {
+		if o.eol != "\n" && o.eol != "\r\n" {
+			return 0, fmt.Errorf("unsupported line ending %q", o.eol)
+		}
+		eol = &eolWriter{w: dst, eol: []byte(o.eol)}
+		dst = eol
+	}
+
+	if o.progress != nil {
+		dst = &progressWriter{Writer: dst, fn: o.progress, total: total, start: time.Now()}
+	}
+
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return n, err
+	}
+
+	if eol != nil {
+		if err = eol.flush(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, o.verify(h)
+}
+
+// newHash returns the checksum hash, nil without checksum option.
+func (o *transferOptions) newHash() (hash.Hash, error) {
+
+	if o.checksum == "" {
+		return nil, nil
+	}
+
+	switch o.algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", o.algo)
+}
+
+// verify compares the sum of h with the checksum option, a nil h passes.
+func (o *transferOptions) verify(h hash.Hash) error {
+
+	if h == nil {
+		return nil
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != o.checksum {
+		return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, sum, o.checksum)
+	}
+
+	return nil
+}
+
+// fileSize returns the size of f or -1 if unknown.
+func fileSize(f interface{ Stat() (os.FileInfo, error) }) int64 {
+
+	info, err := f.Stat()
+	if err != nil {
+		return -1
+	}
+
+	return info.Size()
+}
+
+type progressWriter struct {
+	io.Writer
+	fn          func(Progress)
+	total       int64
+	transferred int64
+	start       time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+
+	n, err := w.Writer.Write(p)
+	w.transferred += int64(n)
+
+	rate := 0.0
+	if elapsed := time.Since(w.start).Seconds(); elapsed > 0 {
+		rate = float64(w.transferred) / elapsed
+	}
+
+	w.fn(Progress{Transferred: w.transferred, Total: w.total, Rate: rate})
+
+	return n, err
+}
+
+// eolWriter rewrites "\r\n" and "\n" line endings to eol.
+type eolWriter struct {
+	w         io.Writer
+	eol       []byte
+	pendingCR bool
+	buf       []byte
+}
+
+func (e *eolWriter) Write(p []byte) (int, error) {
+
+	e.buf = e.buf[:0]
+
+	for _, b := range p {
+
+		if e.pendingCR {
+			e.pendingCR = false
+			if b == '\n' {
+				e.buf = append(e.buf, e.eol...)
+				continue
+			}
+			e.buf = append(e.buf, '\r')
+		}
+
+		switch b {
+		case '\r':
+			e.pendingCR = true
+		case '\n':
+			e.buf = append(e.buf, e.eol...)
+		default:
+			e.buf = append(e.buf, b)
+		}
+	}
+
+	if _, err := e.w.Write(e.buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// flush writes a trailing lone carriage return.
+func (e *eolWriter) flush() error {
+
+	if !e.pendingCR {
+		return nil
+	}
+
+	e.pendingCR = false
+	_, err := e.w.Write([]byte{'\r'})
+	return err
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecord is the max number of bytes recorded from the start of a connection.
+const maxRecord = 64 * 1024
+
+// recordConn records the first bytes read from the server, they hold the
+// server version and its unencrypted key exchange init packet. Recording
+// stops with stop, the connection is then only passed through.
+type recordConn struct {
+	net.Conn
+
+	stopped int32
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	err     error
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+
+	n, err := c.Conn.Read(p)
+
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return n, err
+	}
+
+	c.mu.Lock()
+	if room := maxRecord - c.buf.Len(); room > 0 && n > 0 {
+		if n < room {
+			room = n
+		}
+		c.buf.Write(p[:room])
+	}
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	return n, err
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+
+	n, err := c.Conn.Write(p)
+
+	if err != nil && atomic.LoadInt32(&c.stopped) == 0 {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+	}
+
+	return n, err
+}
+
+// stop ends the recording and returns the recorded bytes.
+func (c *recordConn) stop() []byte {
+
+	atomic.StoreInt32(&c.stopped, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.buf.Bytes()
+	c.buf = bytes.Buffer{}
+
+	return b
+}
+
+// ioErr returns the first read or write error of the connection.
+func (c *recordConn) ioErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Traffic is a snapshot of the connection byte counters.
+type Traffic struct {
+	BytesRead    int64
+	BytesWritten int64
+
+	// Rates in bytes per second since the previous snapshot or the connection.
+	ReadRate  float64
+	WriteRate float64
+}
+
+// countConn counts the bytes read and written on the connection.
+type countConn struct {
+	net.Conn
+
+	read    int64
+	written int64
+
+	mu   sync.Mutex
+	last Traffic
+	at   time.Time
+}
+
+func newCountConn(conn net.Conn) *countConn {
+	return &countConn{Conn: conn, at: time.Now()}
+}
+
+func (c *countConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+func (c *countConn) snapshot() Traffic {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	t := Traffic{
+		BytesRead:    atomic.LoadInt64(&c.read),
+		BytesWritten: atomic.LoadInt64(&c.written),
+	}
+
+	if elapsed := now.Sub(c.at).Seconds(); elapsed > 0 {
+		t.ReadRate = float64(t.BytesRead-c.last.BytesRead) / elapsed
+		t.WriteRate = float64(t.BytesWritten-c.last.BytesWritten) / elapsed
+	}
+
+	c.last, c.at = t, now
+
+	return t
+}
+
+// serverVersion returns the server identification string, lines sent
+// before it are ignored as the RFC allows.
+func serverVersion(b []byte) (string, []byte) {
+
+	for len(b) > 0 {
+
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			return "", nil
+		}
+
+		line := strings.TrimRight(string(b[:i]), "\r")
+		b = b[i+1:]
+
+		if strings.HasPrefix(line, "SSH-") {
+			return line, b
+		}
+	}
+
+	return "", nil
+}
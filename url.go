@@ -0,0 +1,81 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// UploadFromURL streams the body of url to remotePath without a local copy.
+// The file is written to a temp file renamed once complete, so a failed or
+// mismatching download never replaces remotePath. Progress, checksum and
+// fsync transfer options are supported.
+func (c Client) UploadFromURL(ctx context.Context, url string, remotePath string, opts ...TransferOption) (err error) {
+
+	// Errors hold the url which may embed a token.
+	defer func() {
+		err = c.Config.redactor().RedactError(err)
+	}()
+
+	o := newTransferOptions(opts)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	remotePath = c.remotePath(ftp, remotePath)
+
+	tmp, err := tempName(remotePath)
+	if err != nil {
+		return err
+	}
+
+	remote, err := ftp.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			ftp.Remove(tmp)
+		}
+	}()
+
+	if _, err = o.copy(remote, resp.Body, resp.ContentLength); err != nil {
+		remote.Close()
+		return err
+	}
+
+	if o.fsync {
+		if err = remote.Sync(); err != nil {
+			remote.Close()
+			return err
+		}
+	}
+
+	if err = remote.Close(); err != nil {
+		return err
+	}
+
+	return rename(ftp, tmp, remotePath)
+}
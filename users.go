@@ -0,0 +1,248 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// UserSpec is a remote user created by CreateUser.
+type UserSpec struct {
+
+	// Name is the login name.
+	Name string
+
+	// Home directory, empty means the useradd default.
+	Home string
+
+	// Shell is the login shell, empty means the useradd default.
+	Shell string
+
+	// UID, 0 means the next free one.
+	UID int
+
+	// System creates a system user.
+	System bool
+
+	// Groups are supplementary groups, they must exist.
+	Groups []string
+}
+
+// UserExists reports whether the remote user exists.
+func (c Client) UserExists(ctx context.Context, name string) (bool, error) {
+	return c.exists(ctx, "id -u "+ShellSh.Quote(name))
+}
+
+// GroupExists reports whether the remote group exists.
+func (c Client) GroupExists(ctx context.Context, name string) (bool, error) {
+	return c.exists(ctx, "getent group "+ShellSh.Quote(name))
+}
+
+// CreateUser creates the user with useradd and its home directory, an
+// existing user is left unchanged. It returns whether the user was created.
+// The commands run with Config.Sudo when set, like the other user helpers.
+func (c Client) CreateUser(ctx context.Context, u UserSpec) (bool, error) {
+
+	if exists, err := c.UserExists(ctx, u.Name); exists || err != nil {
+		return false, err
+	}
+
+	args := []string{"useradd", "-m"}
+	if u.Home != "" {
+		args = append(args, "-d", ShellSh.Quote(u.Home))
+	}
+	if u.Shell != "" {
+		args = append(args, "-s", ShellSh.Quote(u.Shell))
+	}
+	if u.UID > 0 {
+		args = append(args, "-u", strconv.Itoa(u.UID))
+	}
+	if u.System {
+		args = append(args, "-r")
+	}
+	if len(u.Groups) > 0 {
+		args = append(args, "-G", ShellSh.Quote(strings.Join(u.Groups, ",")))
+	}
+
+	_, err := c.privileged(ctx, strings.Join(append(args, ShellSh.Quote(u.Name)), " "), nil)
+
+	return err == nil, err
+}
+
+// CreateGroup creates the group with groupadd, it returns false when the
+// group already exists.
+func (c Client) CreateGroup(ctx context.Context, name string) (bool, error) {
+
+	if exists, err := c.GroupExists(ctx, name); exists || err != nil {
+		return false, err
+	}
+
+	_, err := c.privileged(ctx, "groupadd "+ShellSh.Quote(name), nil)
+
+	return err == nil, err
+}
+
+// AddToGroup adds the user to the supplementary group, it returns false
+// when the user already is a member.
+func (c Client) AddToGroup(ctx context.Context, user string, group string) (bool, error) {
+
+	member, err := c.InGroup(ctx, user, group)
+	if member || err != nil {
+		return false, err
+	}
+
+	_, err = c.privileged(ctx, "usermod -aG "+ShellSh.Quote(group)+" "+ShellSh.Quote(user), nil)
+
+	return err == nil, err
+}
+
+// RemoveFromGroup removes the user from the supplementary group with
+// gpasswd, it returns false when the user isn't a member.
+func (c Client) RemoveFromGroup(ctx context.Context, user string, group string) (bool, error) {
+
+	member, err := c.InGroup(ctx, user, group)
+	if !member || err != nil {
+		return false, err
+	}
+
+	_, err = c.privileged(ctx, "gpasswd -d "+ShellSh.Quote(user)+" "+ShellSh.Quote(group), nil)
+
+	return err == nil, err
+}
+
+// InGroup reports whether the user is a member of group.
+func (c Client) InGroup(ctx context.Context, user string, group string) (bool, error) {
+
+	out, err := c.privileged(ctx, "id -nG "+ShellSh.Quote(user), nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range strings.Fields(string(out)) {
+		if g == group {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// SetAuthorizedKeys replaces the authorized_keys of the user with keys,
+// the .ssh directory and the file get the 0700 and 0600 modes and are owned
+// by the user. It returns false when the file already had these keys.
+func (c Client) SetAuthorizedKeys(ctx context.Context, user string, keys []string) (bool, error) {
+
+	var data bytes.Buffer
+	for _, key := range keys {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return false, fmt.Errorf("authorized key %q: %w", key, err)
+		}
+		data.WriteString(strings.TrimSpace(key) + "\n")
+	}
+
+	return c.writeAuthorizedKeys(ctx, user, data.Bytes())
+}
+
+// authorizedKeysPath returns the authorized_keys path of the user.
+func (c Client) authorizedKeysPath(ctx context.Context, user string) (string, error) {
+
+	out, err := c.privileged(ctx, "getent passwd "+ShellSh.Quote(user), nil)
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(fields) < 7 || fields[5] == "" {
+		return "", fmt.Errorf("user %s has no home directory", user)
+	}
+
+	return strings.TrimRight(fields[5], "/") + "/.ssh/authorized_keys", nil
+}
+
+// readAuthorizedKeys returns the authorized_keys content of the user, empty
+// when the file doesn't exist.
+func (c Client) readAuthorizedKeys(ctx context.Context, user string) (string, []byte, error) {
+
+	file, err := c.authorizedKeysPath(ctx, user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := c.privileged(ctx, "if [ -e "+ShellSh.Quote(file)+" ]; then cat "+ShellSh.Quote(file)+"; fi", nil)
+
+	return file, data, err
+}
+
+// writeAuthorizedKeys atomically replaces the authorized_keys of the user
+// with data, unless it's unchanged.
+func (c Client) writeAuthorizedKeys(ctx context.Context, user string, data []byte) (bool, error) {
+
+	file, current, err := c.readAuthorizedKeys(ctx, user)
+	if err != nil || bytes.Equal(current, data) {
+		return false, err
+	}
+
+	dir := strings.TrimSuffix(file, "/authorized_keys")
+	tmp, err := tempName(file)
+	if err != nil {
+		return false, err
+	}
+
+	owner := ShellSh.Quote(user + ":")
+	script := strings.Join([]string{
+		"set -e",
+		"umask 077",
+		"mkdir -p " + ShellSh.Quote(dir),
+		"chmod 700 " + ShellSh.Quote(dir),
+		"chown " + owner + " " + ShellSh.Quote(dir),
+		"cat > " + ShellSh.Quote(tmp),
+		"chmod 600 " + ShellSh.Quote(tmp),
+		"chown " + owner + " " + ShellSh.Quote(tmp),
+		"mv -f " + ShellSh.Quote(tmp) + " " + ShellSh.Quote(file),
+	}, "\n")
+
+	_, err = c.privileged(ctx, ShellSh.Wrap(script), bytes.NewReader(data))
+
+	return err == nil, err
+}
+
+// privileged runs cmd with Config.Sudo when set and returns its stdout,
+// input is written to its stdin.
+func (c Client) privileged(ctx context.Context, cmd string, input io.Reader) ([]byte, error) {
+
+	if c.Config != nil && c.Config.Sudo != nil {
+		return c.sudoOutput(ctx, cmd, input)
+	}
+
+	command, err := c.CommandContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer command.Session.Close()
+
+	command.Stdin = input
+
+	return outputStderr(command)
+}
+
+// exists runs a lookup command, exit status 1 or 2 means not found.
+func (c Client) exists(ctx context.Context, cmd string) (bool, error) {
+
+	_, err := c.privileged(ctx, cmd, nil)
+
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && (exit.ExitStatus() == 1 || exit.ExitStatus() == 2) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
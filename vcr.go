@@ -0,0 +1,175 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// ErrNoInteraction is returned by a Replayer when a command was not recorded.
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// Interaction is a recorded command and its result, Output is base64
+// encoded in the fixture file so binary output is kept as is.
+type Interaction struct {
+	Command    string `json:"command"`
+	Output     []byte `json:"output"`
+	Error      string `json:"error,omitempty"`
+	ExitStatus int    `json:"exit_status,omitempty"`
+}
+
+// Cassette is a fixture file of recorded interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// ExitStatusError is implemented by the errors of commands that exited with
+// a status: *ssh.ExitError and *ReplayedError. Use it with errors.As to read
+// the exit status of both live and replayed commands.
+type ExitStatusError interface {
+	error
+	ExitStatus() int
+}
+
+// ReplayedError is the error of a replayed command, unlike the recorded
+// *ssh.ExitError it only holds the error message and the exit status.
+type ReplayedError struct {
+	Message string
+	Status  int
+}
+
+func (e *ReplayedError) Error() string {
+	return e.Message
+}
+
+// ExitStatus returns the recorded exit status.
+func (e *ReplayedError) ExitStatus() int {
+	return e.Status
+}
+
+// Recorder runs commands with a client and records them to a fixture file.
+type Recorder struct {
+	Client *Client
+	Path   string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns new recorder saving to path.
+func NewRecorder(client *Client, path string) *Recorder {
+	return &Recorder{Client: client, Path: path}
+}
+
+// Run runs cmd with the client and records its output.
+func (r *Recorder) Run(cmd string) ([]byte, error) {
+	return r.RunContext(context.Background(), cmd)
+}
+
+// RunContext runs cmd with ctx and records its output.
+func (r *Recorder) RunContext(ctx context.Context, cmd string) ([]byte, error) {
+
+	out, err := r.Client.RunContext(ctx, cmd)
+
+	redactor := r.Client.Config.redactor()
+
+	i := Interaction{Command: redactor.Redact(cmd), Output: []byte(redactor.Redact(string(out)))}
+	if err != nil {
+		i.Error = redactor.Redact(err.Error())
+
+		var exitErr ExitStatusError
+		if errors.As(err, &exitErr) {
+			i.ExitStatus = exitErr.ExitStatus()
+		}
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, i)
+	r.mu.Unlock()
+
+	return out, err
+}
+
+// Save writes the recorded interactions to the fixture file.
+func (r *Recorder) Save() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(r.Path, b, 0644)
+}
+
+// Replayer serves recorded interactions without a network. Interactions of
+// the same command are replayed in their recorded order.
+type Replayer struct {
+
+	// Redactor masks commands before lookup, it must match the recorder one.
+	Redactor *Redactor
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewReplayer loads a fixture file saved by a Recorder.
+func NewReplayer(path string) (*Replayer, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replayer{}
+	if err = json.Unmarshal(b, &r.cassette); err != nil {
+		return nil, err
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+
+	return r, nil
+}
+
+// Run returns the recorded output of cmd.
+func (r *Replayer) Run(cmd string) ([]byte, error) {
+	return r.RunContext(context.Background(), cmd)
+}
+
+// RunContext returns the recorded output of cmd, ctx is only checked for cancellation.
+func (r *Replayer) RunContext(ctx context.Context, cmd string) ([]byte, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cmd = r.Redactor.Redact(cmd)
+
+	for idx, i := range r.cassette.Interactions {
+
+		if r.used[idx] || i.Command != cmd {
+			continue
+		}
+
+		r.used[idx] = true
+
+		if i.Error != "" {
+			return i.Output, &ReplayedError{Message: i.Error, Status: i.ExitStatus}
+		}
+
+		return i.Output, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoInteraction, cmd)
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// WatchOp is a remote file change.
+type WatchOp int
+
+const (
+	WatchCreate WatchOp = iota + 1
+	WatchModify
+	WatchDelete
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchModify:
+		return "modify"
+	case WatchDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// WatchEvent is a change in a watched directory, Err is set when a poll failed.
+type WatchEvent struct {
+	Op   WatchOp
+	Path string
+	Info os.FileInfo
+	Err  error
+}
+
+// Watch polls remoteDir every interval and sends the changes of its entries,
+// a modification is a size or modification time change. The channel is
+// closed when ctx is done, ctx also bounds the sftp session setup.
+func (c Client) Watch(ctx context.Context, remoteDir string, interval time.Duration) (<-chan WatchEvent, error) {
+
+	if interval <= 0 {
+		return nil, errors.New("non-positive watch interval")
+	}
+
+	ftp, err := c.NewSftpContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteDir = c.remotePath(ftp, remoteDir)
+	fold := c.remoteWindows(ftp)
+
+	prev, err := snapshot(ftp, remoteDir, fold)
+	if err != nil {
+		ftp.Close()
+		return nil, fileError(err)
+	}
+
+	events := make(chan WatchEvent)
+
+	go func() {
+		defer close(events)
+		defer ftp.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cur, err := snapshot(ftp, remoteDir, fold)
+			if err != nil {
+				if !sendEvent(ctx, events, WatchEvent{Path: remoteDir, Err: fileError(err)}) {
+					return
+				}
+				continue
+			}
+
+			for _, e := range diffSnapshots(remoteDir, prev, cur) {
+				if !sendEvent(ctx, events, e) {
+					return
+				}
+			}
+			prev = cur
+		}
+	}()
+
+	return events, nil
+}
+
+// snapshot returns the dir entries by name, fold makes names case insensitive.
+func snapshot(ftp *sftp.Client, dir string, fold bool) (map[string]os.FileInfo, error) {
+
+	entries, err := ftp.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := make(map[string]os.FileInfo, len(entries))
+	for _, e := range entries {
+		snap[pathKey(e.Name(), fold)] = e
+	}
+
+	return snap, nil
+}
+
+func diffSnapshots(dir string, prev, cur map[string]os.FileInfo) []WatchEvent {
+
+	var events []WatchEvent
+
+	for key, info := range cur {
+		old, ok := prev[key]
+		switch {
+		case !ok:
+			events = append(events, WatchEvent{Op: WatchCreate, Path: path.Join(dir, info.Name()), Info: info})
+		case old.Size() != info.Size() || !old.ModTime().Equal(info.ModTime()):
+			events = append(events, WatchEvent{Op: WatchModify, Path: path.Join(dir, info.Name()), Info: info})
+		}
+	}
+
+	for key, info := range prev {
+		if _, ok := cur[key]; !ok {
+			events = append(events, WatchEvent{Op: WatchDelete, Path: path.Join(dir, info.Name()), Info: info})
+		}
+	}
+
+	return events
+}
+
+func sendEvent(ctx context.Context, events chan<- WatchEvent, e WatchEvent) bool {
+	select {
+	case events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
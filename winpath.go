@@ -0,0 +1,89 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+var drivePathRegexp = regexp.MustCompile(`^/?[A-Za-z]:([\\/]|$)`)
+
+// pathsCache detects once per client if the sftp server runs on Windows.
+type pathsCache struct {
+	once    sync.Once
+	windows bool
+}
+
+// RemotePath converts a Windows path like C:\dir\file to the sftp syntax
+// /C:/dir/file used by OpenSSH for Windows, other paths are returned as is.
+func RemotePath(p string) string {
+
+	if !drivePathRegexp.MatchString(p) {
+		return p
+	}
+
+	p = strings.Replace(p, `\`, "/", -1)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	return p
+}
+
+// NativePath converts a sftp path like /C:/dir/file to the Windows syntax
+// C:\dir\file, other paths are returned as is.
+func NativePath(p string) string {
+
+	if !drivePathRegexp.MatchString(p) {
+		return p
+	}
+
+	return strings.Replace(strings.TrimPrefix(p, "/"), "/", `\`, -1)
+}
+
+// remoteWindows reports whether the sftp server runs on Windows, detected
+// from its working directory drive letter.
+func (c Client) remoteWindows(ftp *sftp.Client) bool {
+
+	if c.Config != nil && c.Config.RemoteWindows {
+		return true
+	}
+
+	if c.paths == nil {
+		return false
+	}
+
+	c.paths.once.Do(func() {
+		if wd, err := ftp.Getwd(); err == nil {
+			c.paths.windows = drivePathRegexp.MatchString(wd)
+		}
+	})
+
+	return c.paths.windows
+}
+
+// remotePath normalizes p for the sftp server, backslashes are separators
+// and drive letters are rewritten on Windows servers only.
+func (c Client) remotePath(ftp *sftp.Client, p string) string {
+
+	if !c.remoteWindows(ftp) {
+		return p
+	}
+
+	return RemotePath(strings.Replace(p, `\`, "/", -1))
+}
+
+// pathKey returns the comparison key of a remote name, case insensitive on Windows.
+func pathKey(name string, fold bool) string {
+
+	if fold {
+		return strings.ToLower(name)
+	}
+
+	return name
+}